@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"math"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
@@ -11,145 +13,232 @@ import (
 )
 
 // runBenchmarks orchestrates the different performance tests for both filters.
-func runBenchmarks(db *sql.DB, bf *BloomFilter, cf *cuckoo.Filter) {
+// existingIDs are IDs known to be in the filters. db may be nil when the IDs
+// did not come from Postgres, in which case the database comparisons are skipped.
+func runBenchmarks(db *sql.DB, bf *BloomFilter, cf *cuckoo.Filter, existingIDs [][]byte) {
 	log.Println("\n--- Preparing data for benchmarks ---")
-
-	// Prepare a slice of 100,000 existing IDs
-	existingIDs := make([][]byte, 0, benchmark_n)
-	rows, err := db.Query("SELECT id FROM users LIMIT $1", benchmark_n)
-	if err != nil {
-		log.Fatalf("Failed to fetch existing IDs for benchmark: %v", err)
-	}
-	for rows.Next() {
-		var id uuid.UUID
-		if err := rows.Scan(&id); err != nil {
-			log.Printf("Error scanning existing ID: %v", err)
-			continue
-		}
-		existingIDs = append(existingIDs, id[:])
-	}
-	rows.Close()
-	log.Printf("Fetched %d existing IDs for testing.", len(existingIDs))
+	log.Printf("Using %d existing IDs for testing.", len(existingIDs))
 
 	// Prepare a slice of 100,000 non-existent IDs
-	nonExistentIDs := make([][]byte, 0, benchmark_n)
-	for i := 0; i < benchmark_n; i++ {
-		id := uuid.New()
-		nonExistentIDs = append(nonExistentIDs, id[:])
-	}
+	nonExistentIDs := newNonExistentIDs(benchmark_n)
 	log.Printf("Generated %d non-existent IDs for testing.", len(nonExistentIDs))
 
 	// Run the benchmarks
 	benchmarkNonExistentUsers(db, bf, cf, nonExistentIDs)
-	benchmarkExistingUsers(db, bf, cf, existingIDs)
-	benchmarkDeletions(cf, existingIDs) // Deletion is only possible with Cuckoo Filter
+	if db != nil {
+		benchmarkExistingUsers(db, bf, cf, existingIDs)
+	} else {
+		log.Println("No database: skipping the existing users benchmark.")
+	}
+	benchmarkDeletions(cf, existingIDs) // A standard Bloom Filter cannot delete
+	benchmarkCountingBloomDeletions(existingIDs)
+}
+
+// newNonExistentIDs generates n random IDs. With 2^122 possible UUIDs, none
+// of them is in the filters.
+func newNonExistentIDs(n int) [][]byte {
+	ids := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		id := uuid.New()
+		ids = append(ids, id[:])
+	}
+	return ids
+}
+
+// Metrics is the outcome of timing one operation over a set of items. The
+// counters are only set by the benchmarks that measure them.
+type Metrics struct {
+	Name      string        `json:"name"`
+	Ops       int           `json:"ops"`
+	Total     time.Duration `json:"total_ns"`
+	AvgPerOp  time.Duration `json:"avg_per_op_ns"`
+	P50       time.Duration `json:"p50_ns"`
+	P95       time.Duration `json:"p95_ns"`
+	P99       time.Duration `json:"p99_ns"`
+	OpsPerSec float64       `json:"ops_per_sec"`
+
+	FalsePositives *int `json:"false_positives,omitempty"` // Lookups of absent items that returned true
+	StillFound     *int `json:"still_found,omitempty"`     // Deleted items that were still found
+	FalseNegatives *int `json:"false_negatives,omitempty"` // Kept items that were no longer found
+}
+
+// newMetrics computes the timing figures of operations that took duration in
+// total, with samples holding the duration of each one. With no samples, e.g.
+// a benchmark over an empty ID set, every figure is zero.
+func newMetrics(name string, duration time.Duration, samples []time.Duration) Metrics {
+	numOps := len(samples)
+	if numOps == 0 {
+		return Metrics{Name: name, Total: duration}
+	}
+	sorted := slices.Clone(samples)
+	slices.Sort(sorted)
+	metrics := Metrics{
+		Name:     name,
+		Ops:      numOps,
+		Total:    duration,
+		AvgPerOp: duration / time.Duration(numOps),
+		P50:      percentile(sorted, 50),
+		P95:      percentile(sorted, 95),
+		P99:      percentile(sorted, 99),
+	}
+	if duration > 0 {
+		metrics.OpsPerSec = float64(numOps) / duration.Seconds()
+	}
+	return metrics
+}
+
+// percentile returns the p-th percentile of sorted using the nearest-rank
+// method: the smallest sample such that at least p% of the samples are less
+// than or equal to it.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank-1, 0)]
+}
+
+// timeOps runs op on every id, timing each call as well as the whole loop.
+// Reading the clock around each call adds a few tens of nanoseconds per op,
+// which shows in the totals of the in-memory filters.
+func timeOps(ids [][]byte, op func(id []byte)) (time.Duration, []time.Duration) {
+	samples := make([]time.Duration, 0, len(ids))
+	start := time.Now()
+	for _, id := range ids {
+		opStart := time.Now()
+		op(id)
+		samples = append(samples, time.Since(opStart))
+	}
+	return time.Since(start), samples
+}
+
+// BenchmarkReport gathers the metrics of one benchmark run.
+type BenchmarkReport struct {
+	Kind       string    `json:"kind"`
+	Metrics    []Metrics `json:"metrics"`
+	Conclusion string    `json:"conclusion,omitempty"`
 }
 
 // --- Benchmark for Non-Existent Items ---
-func benchmarkNonExistentUsers(db *sql.DB, bf *BloomFilter, cf *cuckoo.Filter, idsToTest [][]byte) {
+func benchmarkNonExistentUsers(db *sql.DB, bf *BloomFilter, cf *cuckoo.Filter, idsToTest [][]byte) BenchmarkReport {
+	report := BenchmarkReport{Kind: "non-existent"}
 	fmt.Println("\n-------------------------------------------------------------")
 	log.Printf("--- Benchmark: Non-Existent Users (%d lookups) ---", len(idsToTest))
 	fmt.Println("-------------------------------------------------------------")
 
 	// Test 1: Bloom Filter
 	bfFalsePositives := 0
-	startBf := time.Now()
-	for _, id := range idsToTest {
+	durationBf, samplesBf := timeOps(idsToTest, func(id []byte) {
 		if bf.Test(id) {
 			bfFalsePositives++
 		}
-	}
-	durationBf := time.Since(startBf)
+	})
 	fmt.Println("[Bloom Filter]")
-	printMetrics(durationBf, len(idsToTest))
-	fpRateBf := (float64(bfFalsePositives) / float64(len(idsToTest))) * 100
-	fmt.Printf("  False Positives:  %d (%.4f%%)\n", bfFalsePositives, fpRateBf)
+	metricsBf := newMetrics("Bloom Filter", durationBf, samplesBf)
+	metricsBf.FalsePositives = &bfFalsePositives
+	printMetrics(metricsBf)
+	report.Metrics = append(report.Metrics, metricsBf)
 
 	// Test 2: Cuckoo Filter
 	cfFalsePositives := 0
-	startCf := time.Now()
-	for _, id := range idsToTest {
+	durationCf, samplesCf := timeOps(idsToTest, func(id []byte) {
 		if cf.Lookup(id) {
 			cfFalsePositives++
 		}
-	}
-	durationCf := time.Since(startCf)
+	})
 	fmt.Println("\n[Cuckoo Filter]")
-	printMetrics(durationCf, len(idsToTest))
-	fpRateCf := (float64(cfFalsePositives) / float64(len(idsToTest))) * 100
-	fmt.Printf("  False Positives:  %d (%.4f%%)\n", cfFalsePositives, fpRateCf)
+	metricsCf := newMetrics("Cuckoo Filter", durationCf, samplesCf)
+	metricsCf.FalsePositives = &cfFalsePositives
+	printMetrics(metricsCf)
+	report.Metrics = append(report.Metrics, metricsCf)
 
+	if db == nil {
+		report.Conclusion = fmt.Sprintf("Cuckoo was %.2fx faster than Bloom.", float64(durationBf)/float64(durationCf))
+		fmt.Printf("\nConclusion: %s\n", report.Conclusion)
+		return report
+	}
 
 	// Test 3: Database Only
-	startDb := time.Now()
-	for _, idBytes := range idsToTest {
+	durationDb, samplesDb := timeOps(idsToTest, func(idBytes []byte) {
 		var id uuid.UUID
 		copy(id[:], idBytes)
 		db.QueryRow("SELECT id FROM users WHERE id = $1", id).Scan(&id)
-	}
-	durationDb := time.Since(startDb)
+	})
 	fmt.Println("\n[Database Only]")
-	printMetrics(durationDb, len(idsToTest))
+	metricsDb := newMetrics("Database Only", durationDb, samplesDb)
+	printMetrics(metricsDb)
+	report.Metrics = append(report.Metrics, metricsDb)
 
-	fmt.Printf("\nConclusion: Cuckoo was %.2fx faster than Bloom. Bloom was %.2fx faster than DB.\n", float64(durationBf)/float64(durationCf), float64(durationDb)/float64(durationBf))
+	report.Conclusion = fmt.Sprintf("Cuckoo was %.2fx faster than Bloom. Bloom was %.2fx faster than DB.", float64(durationBf)/float64(durationCf), float64(durationDb)/float64(durationBf))
+	fmt.Printf("\nConclusion: %s\n", report.Conclusion)
+	return report
 }
 
 // --- Benchmark for Existing Items ---
-func benchmarkExistingUsers(db *sql.DB, bf *BloomFilter, cf *cuckoo.Filter, idsToTest [][]byte) {
+func benchmarkExistingUsers(db *sql.DB, bf *BloomFilter, cf *cuckoo.Filter, idsToTest [][]byte) BenchmarkReport {
+	report := BenchmarkReport{Kind: "existing"}
 	fmt.Println("\n-------------------------------------------------------------")
 	log.Printf("--- Benchmark: Existing Users (%d lookups) ---", len(idsToTest))
 	fmt.Println("-------------------------------------------------------------")
 	
 	// Test 1: Bloom Filter + DB
-	startBf := time.Now()
-	for _, idBytes := range idsToTest {
+	durationBf, samplesBf := timeOps(idsToTest, func(idBytes []byte) {
 		if bf.Test(idBytes) {
 			var id uuid.UUID; copy(id[:], idBytes); db.QueryRow("SELECT id FROM users WHERE id = $1", id).Scan(&id)
 		}
-	}
-	durationBf := time.Since(startBf)
+	})
 	fmt.Println("[Bloom Filter + Database]")
-	printMetrics(durationBf, len(idsToTest))
+	metricsBf := newMetrics("Bloom Filter + Database", durationBf, samplesBf)
+	printMetrics(metricsBf)
+	report.Metrics = append(report.Metrics, metricsBf)
 
 	// Test 2: Cuckoo Filter + DB
-	startCf := time.Now()
-	for _, idBytes := range idsToTest {
+	durationCf, samplesCf := timeOps(idsToTest, func(idBytes []byte) {
 		if cf.Lookup(idBytes) {
 			var id uuid.UUID; copy(id[:], idBytes); db.QueryRow("SELECT id FROM users WHERE id = $1", id).Scan(&id)
 		}
-	}
-	durationCf := time.Since(startCf)
+	})
 	fmt.Println("\n[Cuckoo Filter + Database]")
-	printMetrics(durationCf, len(idsToTest))
+	metricsCf := newMetrics("Cuckoo Filter + Database", durationCf, samplesCf)
+	printMetrics(metricsCf)
+	report.Metrics = append(report.Metrics, metricsCf)
 
 	// Test 3: Database Only
-	startDb := time.Now()
-	for _, idBytes := range idsToTest {
+	durationDb, samplesDb := timeOps(idsToTest, func(idBytes []byte) {
 		var id uuid.UUID; copy(id[:], idBytes); db.QueryRow("SELECT id FROM users WHERE id = $1", id).Scan(&id)
-	}
-	durationDb := time.Since(startDb)
+	})
 	fmt.Println("\n[Database Only]")
-	printMetrics(durationDb, len(idsToTest))
-	
+	metricsDb := newMetrics("Database Only", durationDb, samplesDb)
+	printMetrics(metricsDb)
+	report.Metrics = append(report.Metrics, metricsDb)
+
+	if len(idsToTest) == 0 {
+		report.Conclusion = "No existing IDs to look up."
+		fmt.Printf("\nConclusion: %s\n", report.Conclusion)
+		return report
+	}
 	overheadBf := durationBf - durationDb
 	overheadCf := durationCf - durationDb
-	fmt.Printf("\nConclusion: Bloom Filter added %v overhead. Cuckoo Filter added %v overhead.\n", overheadBf/time.Duration(len(idsToTest)), overheadCf/time.Duration(len(idsToTest)))
+	report.Conclusion = fmt.Sprintf("Bloom Filter added %v overhead. Cuckoo Filter added %v overhead.", overheadBf/time.Duration(len(idsToTest)), overheadCf/time.Duration(len(idsToTest)))
+	fmt.Printf("\nConclusion: %s\n", report.Conclusion)
+	return report
 }
 
 // --- Benchmark for Deletions (Cuckoo Only) ---
-func benchmarkDeletions(cf *cuckoo.Filter, idsToTest [][]byte) {
+// The deleted items are inserted again at the end, so the filter stays warm
+// for later runs.
+func benchmarkDeletions(cf *cuckoo.Filter, idsToTest [][]byte) Metrics {
 	fmt.Println("\n-------------------------------------------------------------")
 	log.Printf("--- Benchmark: Deletions (%d items) ---", len(idsToTest))
 	fmt.Println("-------------------------------------------------------------")
 
 	// Test 1: Deletion performance
-	start := time.Now()
-	for _, id := range idsToTest {
+	duration, samples := timeOps(idsToTest, func(id []byte) {
 		cf.Delete(id)
-	}
-	duration := time.Since(start)
+	})
 	fmt.Println("[Cuckoo Filter Deletion]")
-	printMetrics(duration, len(idsToTest))
+	metrics := newMetrics("Cuckoo Filter Deletion", duration, samples)
+	printMetrics(metrics)
 
 	// Test 2: Verification
 	foundCount := 0
@@ -160,14 +249,74 @@ func benchmarkDeletions(cf *cuckoo.Filter, idsToTest [][]byte) {
 	}
 	fmt.Printf("\nVerification: After deleting %d items, %d were still found in the filter.\n", len(idsToTest), foundCount)
 	fmt.Println("Note: A standard Bloom Filter does not support deletion.")
+	metrics.StillFound = &foundCount
+
+	for _, id := range idsToTest {
+		cf.Insert(id)
+	}
+	return metrics
+}
+
+// --- Benchmark for Deletions (Counting Bloom Filter) ---
+// The Counting Bloom Filter is built just for this test, holding idsToTest
+// plus the same number of fresh IDs that are kept. After deleting idsToTest,
+// every kept ID must still be found: a miss would be a false negative.
+func benchmarkCountingBloomDeletions(idsToTest [][]byte) Metrics {
+	fmt.Println("\n-------------------------------------------------------------")
+	log.Printf("--- Benchmark: Counting Bloom Filter Deletions (%d items) ---", len(idsToTest))
+	fmt.Println("-------------------------------------------------------------")
+
+	keptIDs := make([][]byte, 0, len(idsToTest))
+	for range idsToTest {
+		id := uuid.New()
+		keptIDs = append(keptIDs, id[:])
+	}
+
+	m, k := optimalParameters(uint64(len(idsToTest)+len(keptIDs)), false_positive_rate)
+	cbf := NewCountingBloomFilter(m, k)
+	for _, id := range idsToTest {
+		cbf.Add(id)
+	}
+	for _, id := range keptIDs {
+		cbf.Add(id)
+	}
+
+	// Test 1: Deletion performance
+	duration, samples := timeOps(idsToTest, func(id []byte) {
+		cbf.Remove(id)
+	})
+	fmt.Println("[Counting Bloom Filter Deletion]")
+	metrics := newMetrics("Counting Bloom Filter Deletion", duration, samples)
+	printMetrics(metrics)
+
+	// Test 2: Verification
+	foundCount := 0
+	for _, id := range idsToTest {
+		if cbf.Test(id) {
+			foundCount++
+		}
+	}
+	falseNegatives := 0
+	for _, id := range keptIDs {
+		if !cbf.Test(id) {
+			falseNegatives++
+		}
+	}
+	fmt.Printf("\nVerification: After deleting %d items, %d were still found in the filter.\n", len(idsToTest), foundCount)
+	fmt.Printf("False Negatives:  %d of %d kept items\n", falseNegatives, len(keptIDs))
+	metrics.StillFound = &foundCount
+	metrics.FalseNegatives = &falseNegatives
+	return metrics
 }
 
 // printMetrics is a helper function to display performance results.
-func printMetrics(duration time.Duration, numOps int) {
-	avg := duration / time.Duration(numOps)
-	opsPerSec := float64(numOps) / duration.Seconds()
-	
-	fmt.Printf("  Total Time:       %v\n", duration)
-	fmt.Printf("  Avg. Per Lookup:  %v\n", avg)
-	fmt.Printf("  Ops/Second:       %.2f\n", opsPerSec)
+func printMetrics(m Metrics) {
+	fmt.Printf("  Total Time:       %v\n", m.Total)
+	fmt.Printf("  Avg. Per Lookup:  %v\n", m.AvgPerOp)
+	fmt.Printf("  p50 / p95 / p99:  %v / %v / %v\n", m.P50, m.P95, m.P99)
+	fmt.Printf("  Ops/Second:       %.2f\n", m.OpsPerSec)
+	if m.FalsePositives != nil {
+		fpRate := float64(*m.FalsePositives) / float64(m.Ops) * 100
+		fmt.Printf("  False Positives:  %d (%.4f%%)\n", *m.FalsePositives, fpRate)
+	}
 }
\ No newline at end of file
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestNewMetricsWithoutSamples(t *testing.T) {
+	metrics := newMetrics("empty", 0, nil)
+	if metrics != (Metrics{Name: "empty"}) {
+		t.Errorf("got %+v, want zero figures", metrics)
+	}
+	// NaN or infinite figures would not even encode.
+	if _, err := json.Marshal(newMetrics("empty", time.Millisecond, []time.Duration{})); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewMetricsPercentiles(t *testing.T) {
+	// 1ms to 100ms, shuffled: the p-th percentile is p milliseconds.
+	samples := make([]time.Duration, 100)
+	for i := range samples {
+		samples[i] = time.Duration((i*37)%100+1) * time.Millisecond
+	}
+	metrics := newMetrics("latency", time.Second, samples)
+	for _, c := range []struct {
+		name      string
+		got, want time.Duration
+	}{
+		{"p50", metrics.P50, 50 * time.Millisecond},
+		{"p95", metrics.P95, 95 * time.Millisecond},
+		{"p99", metrics.P99, 99 * time.Millisecond},
+		{"average", metrics.AvgPerOp, 10 * time.Millisecond},
+	} {
+		if c.got != c.want {
+			t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+	if metrics.OpsPerSec != 100 {
+		t.Errorf("ops/s = %v, want 100", metrics.OpsPerSec)
+	}
+	if samples[0] != time.Millisecond || samples[1] != 38*time.Millisecond {
+		t.Error("newMetrics reordered the caller's samples")
+	}
+}
+
+func TestPercentileNearestRank(t *testing.T) {
+	sorted := []time.Duration{10, 20, 30, 40}
+	for _, c := range []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, 10},
+		{25, 10},
+		{26, 20},
+		{50, 20},
+		{99, 40},
+		{100, 40},
+	} {
+		if got := percentile(sorted, c.p); got != c.want {
+			t.Errorf("percentile(%v, %v) = %v, want %v", sorted, c.p, got, c.want)
+		}
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("percentile of no samples = %v, want 0", got)
+	}
+}
+
+func TestBenchmarkExistingUsersWithoutIDs(t *testing.T) {
+	filters := newTestFilters(10)
+	// No lookup runs, so the database is never queried.
+	report := benchmarkExistingUsers(nil, filters.Bloom, filters.Cuckoo, nil)
+	for _, metrics := range report.Metrics {
+		if metrics.Ops != 0 {
+			t.Errorf("%s: timed %d lookups of no IDs", metrics.Name, metrics.Ops)
+		}
+	}
+	if report.Conclusion == "" {
+		t.Error("the report has no conclusion")
+	}
+}
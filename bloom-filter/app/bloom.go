@@ -1,19 +1,22 @@
 package main
 
 import (
-	"hash"
+	"fmt"
 	"hash/fnv"
+	"math"
+	"math/bits"
+	"sync/atomic"
 
 	"github.com/spaolacci/murmur3"
 )
 
-// BloomFilter defines the data structure
+// BloomFilter defines the data structure.
+// It is safe for concurrent use: hashing keeps no shared state and the bits
+// are set and read atomically.
 type BloomFilter struct {
 	m      uint64       // Size of the bit array
 	k      uint64       // Number of hash functions
 	bitset []uint64     // We use an array of uint64 for efficiency
-	hash1  hash.Hash64  // First hash function
-	hash2  hash.Hash64  // Second hash function
 }
 
 // NewBloomFilter creates and initializes a new Bloom Filter
@@ -22,45 +25,174 @@ func NewBloomFilter(m, k uint64) *BloomFilter {
 		m:      m,
 		k:      k,
 		bitset: make([]uint64, (m+63)/64), // Round up to the next multiple of 64
-		hash1:  murmur3.New64(),
-		hash2:  fnv.New64a(),
 	}
 }
 
-// getHashes uses the double-hashing technique to generate k hashes
-func (bf *BloomFilter) getHashes(data []byte) (uint64, uint64) {
-	bf.hash1.Reset()
-	bf.hash1.Write(data)
-	h1 := bf.hash1.Sum64()
+// NewBloomFilterForCapacity creates a Bloom Filter sized for n items at a
+// target false-positive rate p, using the textbook optimal parameters:
+//
+//	m = -(n * ln(p)) / (ln 2)^2   (rounded up)
+//	k = (m / n) * ln 2            (rounded to the nearest integer, at least 1)
+func NewBloomFilterForCapacity(n uint64, p float64) *BloomFilter {
+	m, k := optimalParameters(n, p)
+	return NewBloomFilter(m, k)
+}
+
+// optimalParameters computes the bit-array size m and hash count k for n items at rate p.
+func optimalParameters(n uint64, p float64) (m, k uint64) {
+	if n == 0 {
+		n = 1
+	}
+	m = uint64(math.Ceil(-(float64(n) * math.Log(p)) / (math.Ln2 * math.Ln2)))
+	if m == 0 {
+		m = 1
+	}
+	k = uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k == 0 {
+		k = 1
+	}
+	return m, k
+}
+
+// getHashes uses the double-hashing technique to generate k hashes.
+// A fresh FNV hasher is used on every call, so no state is shared between goroutines.
+func getHashes(data []byte) (uint64, uint64) {
+	h1 := murmur3.Sum64(data)
+
+	hash2 := fnv.New64a()
+	hash2.Write(data)
+	h2 := hash2.Sum64()
 
-	bf.hash2.Reset()
-	bf.hash2.Write(data)
-	h2 := bf.hash2.Sum64()
-	
 	return h1, h2
 }
 
+// probeStart reduces the hashes of an item for a filter of m bits: the first
+// probe is h1 mod m and each next one is step bits further, wrapping around.
+//
+// With h2 itself as the step, an h2 that is a multiple of m would put every
+// probe on the same bit, and one sharing a large factor with m would cycle
+// after a few probes. The step is instead taken in [1, m-1] and moved up to
+// the next value coprime with m, so the probes visit m distinct bits before
+// repeating, whatever m is.
+func probeStart(h1, h2, m uint64) (index, step uint64) {
+	if m == 1 {
+		return 0, 0
+	}
+	step = h2%(m-1) + 1
+	for gcd(step, m) != 1 {
+		step = step%(m-1) + 1
+	}
+	return h1 % m, step
+}
+
+// gcd returns the greatest common divisor of a and b.
+func gcd(a, b uint64) uint64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// getProbes returns the first bit and the step of the probes of data in a
+// filter of m bits.
+func getProbes(data []byte, m uint64) (index, step uint64) {
+	h1, h2 := getHashes(data)
+	return probeStart(h1, h2, m)
+}
+
 // Add adds an item to the filter
 func (bf *BloomFilter) Add(data []byte) {
-	h1, h2 := bf.getHashes(data)
+	index, step := getProbes(data, bf.m)
 	for i := uint64(0); i < bf.k; i++ {
-		// hash(i) = h1 + i * h2
-		index := (h1 + i*h2) % bf.m
 		// Set the bit at position 'index' to 1
-		bf.bitset[index/64] |= (1 << (index % 64))
+		atomic.OrUint64(&bf.bitset[index/64], 1<<(index%64))
+		index = (index + step) % bf.m
+	}
+	bf.clearPadding()
+}
+
+// clearPadding zeroes the unused high bits of the last word when m is not a
+// multiple of 64, so counting or serializing whole words never sees bits
+// beyond m.
+func (bf *BloomFilter) clearPadding() {
+	if used := bf.m % 64; used != 0 {
+		atomic.AndUint64(&bf.bitset[len(bf.bitset)-1], 1<<used-1)
 	}
 }
 
 // Test checks if an item "probably" is in the set
 func (bf *BloomFilter) Test(data []byte) bool {
-	h1, h2 := bf.getHashes(data)
+	index, step := getProbes(data, bf.m)
 	for i := uint64(0); i < bf.k; i++ {
-		index := (h1 + i*h2) % bf.m
 		// If we find a single bit 0, the item DEFINITELY is not in the set
-		if (bf.bitset[index/64] & (1 << (index % 64))) == 0 {
+		if (atomic.LoadUint64(&bf.bitset[index/64]) & (1 << (index % 64))) == 0 {
 			return false
 		}
+		index = (index + step) % bf.m
 	}
 	// If all bits are 1, the item PROBABLY is in the set
 	return true
-}
\ No newline at end of file
+}
+
+// SetBitCount counts the bits currently set in the filter. Together with m it
+// tells how saturated the filter is.
+func (bf *BloomFilter) SetBitCount() uint64 {
+	var count uint64
+	for i := range bf.bitset {
+		count += uint64(bits.OnesCount64(atomic.LoadUint64(&bf.bitset[i])))
+	}
+	return count
+}
+
+// MemoryBytes returns the size of the bit array in bytes.
+func (bf *BloomFilter) MemoryBytes() int {
+	return len(bf.bitset) * 8
+}
+
+// EstimatedCount estimates how many distinct items were added, using the
+// standard estimator n* = -(m/k) * ln(1 - X/m), where X is the number of set bits.
+func (bf *BloomFilter) EstimatedCount() uint64 {
+	x := float64(bf.SetBitCount())
+	m := float64(bf.m)
+	if x >= m {
+		// Every bit is set: the filter is saturated and the estimate diverges.
+		return math.MaxUint64
+	}
+	return uint64(math.Round(-(m / float64(bf.k)) * math.Log(1-x/m)))
+}
+
+// CurrentFalsePositiveRate estimates the probability that Test returns true
+// for an item that was never added: the chance that all k probed bits are
+// set, i.e. (X/m)^k.
+func (bf *BloomFilter) CurrentFalsePositiveRate() float64 {
+	fillRatio := float64(bf.SetBitCount()) / float64(bf.m)
+	return math.Pow(fillRatio, float64(bf.k))
+}
+
+// Union merges other into bf by OR-ing their bit arrays. The result behaves
+// exactly like a single filter built over the items of both. Both filters
+// must have the same m and k.
+func (bf *BloomFilter) Union(other *BloomFilter) error {
+	if err := bf.CheckParams(other.m, other.k); err != nil {
+		return fmt.Errorf("cannot union filters: %w", err)
+	}
+	for i := range bf.bitset {
+		atomic.OrUint64(&bf.bitset[i], atomic.LoadUint64(&other.bitset[i]))
+	}
+	bf.clearPadding()
+	return nil
+}
+
+// Intersect keeps in bf only the bits also set in other, by AND-ing their bit
+// arrays. Items added to both filters still test true; the false-positive
+// rate may be higher than a filter built over the intersection alone. Both
+// filters must have the same m and k.
+func (bf *BloomFilter) Intersect(other *BloomFilter) error {
+	if err := bf.CheckParams(other.m, other.k); err != nil {
+		return fmt.Errorf("cannot intersect filters: %w", err)
+	}
+	for i := range bf.bitset {
+		atomic.AndUint64(&bf.bitset[i], atomic.LoadUint64(&other.bitset[i]))
+	}
+	return nil
+}
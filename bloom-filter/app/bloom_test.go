@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// testItems returns n distinct items with the given prefix.
+func testItems(prefix string, n int) [][]byte {
+	items := make([][]byte, n)
+	for i := range items {
+		items[i] = []byte(fmt.Sprintf("%s-%d", prefix, i))
+	}
+	return items
+}
+
+// Run with -race: Add and Test share no hashing state and touch the bits atomically.
+func TestConcurrentAddAndTest(t *testing.T) {
+	bf := NewBloomFilterForCapacity(10000, 0.01)
+	const writers = 4
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(2)
+		items := testItems(fmt.Sprint("writer-", w), 1000)
+		go func() {
+			defer wg.Done()
+			for _, item := range items {
+				bf.Add(item)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for _, item := range items {
+				bf.Test(item)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for w := 0; w < writers; w++ {
+		for _, item := range testItems(fmt.Sprint("writer-", w), 1000) {
+			if !bf.Test(item) {
+				t.Fatalf("%s was added concurrently but is not found", item)
+			}
+		}
+	}
+}
+
+func TestNewBloomFilterForCapacityUsesOptimalParameters(t *testing.T) {
+	bf := NewBloomFilterForCapacity(20_000_000, 0.01)
+	// m = -(n ln p) / (ln 2)^2 = 191,701,167.55 bits, rounded up;
+	// k = (m / n) ln 2 = 6.64, rounded to 7.
+	if bf.m != 191_701_168 || bf.k != 7 {
+		t.Errorf("n=20M, p=0.01 gives m=%d k=%d, want m=191701168 k=7", bf.m, bf.k)
+	}
+	if words := len(bf.bitset); words != (191_701_168+63)/64 {
+		t.Errorf("bit array has %d words, want %d", words, (191_701_168+63)/64)
+	}
+
+	for _, tc := range []struct {
+		n    uint64
+		p    float64
+		m, k uint64
+	}{
+		{1000, 0.01, 9586, 7},
+		{1000, 0.5, 1443, 1},
+		{0, 0.01, 10, 7}, // Sized as for one item
+	} {
+		if m, k := optimalParameters(tc.n, tc.p); m != tc.m || k != tc.k {
+			t.Errorf("n=%d p=%v gives m=%d k=%d, want m=%d k=%d", tc.n, tc.p, m, k, tc.m, tc.k)
+		}
+	}
+}
+
+func TestEstimatedCountAndFalsePositiveRate(t *testing.T) {
+	bf := NewBloomFilterForCapacity(20000, 0.01)
+	if n := bf.EstimatedCount(); n != 0 {
+		t.Errorf("an empty filter estimates %d items", n)
+	}
+	for _, n := range []int{1000, 10000, 20000} {
+		bf := NewBloomFilterForCapacity(20000, 0.01)
+		for _, item := range testItems("item", n) {
+			bf.Add(item)
+		}
+		if estimate := float64(bf.EstimatedCount()); math.Abs(estimate-float64(n)) > 0.03*float64(n) {
+			t.Errorf("%d items added, estimate %.0f: off by more than 3%%", n, estimate)
+		}
+	}
+
+	// At design capacity the rate is about the target p.
+	for _, item := range testItems("item", 20000) {
+		bf.Add(item)
+	}
+	falsePositives := 0
+	for _, item := range testItems("absent", 100000) {
+		if bf.Test(item) {
+			falsePositives++
+		}
+	}
+	measured, estimated := float64(falsePositives)/100000, bf.CurrentFalsePositiveRate()
+	if estimated < 0.005 || estimated > 0.015 || math.Abs(measured-estimated) > 0.003 {
+		t.Errorf("estimated false-positive rate %.4f, measured %.4f; want both near 0.01", estimated, measured)
+	}
+}
+
+func TestUnionOfShardFiltersMatchesOneFilter(t *testing.T) {
+	shardA, shardB := testItems("shard-a", 5000), testItems("shard-b", 5000)
+	a, b, all := NewBloomFilterForCapacity(10000, 0.01), NewBloomFilterForCapacity(10000, 0.01), NewBloomFilterForCapacity(10000, 0.01)
+	for _, item := range shardA {
+		a.Add(item)
+		all.Add(item)
+	}
+	for _, item := range shardB {
+		b.Add(item)
+		all.Add(item)
+	}
+
+	if err := a.Union(b); err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range append(shardA, shardB...) {
+		if !a.Test(item) {
+			t.Fatalf("%s is missing from the union", item)
+		}
+	}
+	if !slices.Equal(a.bitset, all.bitset) {
+		t.Error("the union differs from a filter built over both shards")
+	}
+
+	if err := a.Union(NewBloomFilter(a.m+1, a.k)); err == nil {
+		t.Error("Union accepted a filter with another m")
+	}
+	if err := a.Intersect(NewBloomFilter(a.m, a.k+1)); err == nil {
+		t.Error("Intersect accepted a filter with another k")
+	}
+}
+
+func TestIntersectKeepsItemsOfBothFilters(t *testing.T) {
+	common := testItems("common", 1000)
+	a, b := NewBloomFilterForCapacity(3000, 0.01), NewBloomFilterForCapacity(3000, 0.01)
+	for _, item := range common {
+		a.Add(item)
+		b.Add(item)
+	}
+	for _, item := range testItems("only-a", 1000) {
+		a.Add(item)
+	}
+	for _, item := range testItems("only-b", 1000) {
+		b.Add(item)
+	}
+
+	if err := a.Intersect(b); err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range common {
+		if !a.Test(item) {
+			t.Fatalf("%s, added to both filters, is missing from the intersection", item)
+		}
+	}
+}
+
+// probedBits returns the distinct bit indices Add sets for items.
+func probedBits(bf *BloomFilter, items [][]byte) map[uint64]bool {
+	indices := make(map[uint64]bool)
+	for _, item := range items {
+		index, step := getProbes(item, bf.m)
+		for i := uint64(0); i < bf.k; i++ {
+			indices[index] = true
+			index = (index + step) % bf.m
+		}
+	}
+	return indices
+}
+
+func TestSetBitCountAndMemoryBytes(t *testing.T) {
+	for _, m := range []uint64{64, 100, 1000} {
+		bf := NewBloomFilter(m, 3)
+		if got, want := bf.MemoryBytes(), int((m+63)/64*8); got != want {
+			t.Errorf("m=%d: MemoryBytes() = %d, want %d", m, got, want)
+		}
+		items := testItems("item", 20)
+		for _, item := range items {
+			bf.Add(item)
+		}
+		if got, want := bf.SetBitCount(), uint64(len(probedBits(bf, items))); got != want {
+			t.Errorf("m=%d: SetBitCount() = %d, want the %d bits the items probe", m, got, want)
+		}
+	}
+
+	// The first and last bits of m=100, on both sides of the word boundary.
+	bf := NewBloomFilter(100, 1)
+	for _, index := range []uint64{0, 63, 64, 99} {
+		bf.bitset[index/64] |= 1 << (index % 64)
+	}
+	if got := bf.SetBitCount(); got != 4 {
+		t.Errorf("SetBitCount() = %d with bits 0, 63, 64 and 99 set, want 4", got)
+	}
+}
+
+func TestPaddingBitsStayClear(t *testing.T) {
+	const padding = ^uint64(1<<(100%64) - 1)
+
+	bf := NewBloomFilter(100, 7)
+	for _, item := range testItems("item", 1000) {
+		bf.Add(item)
+	}
+	if last := bf.bitset[len(bf.bitset)-1]; last&padding != 0 {
+		t.Errorf("last word %#x has bits set beyond m=100", last)
+	}
+	if got := bf.SetBitCount(); got != 100 {
+		t.Errorf("SetBitCount() = %d after saturating m=100, want 100", got)
+	}
+
+	// A union must not carry over padding bits the other filter has set.
+	dirty := NewBloomFilter(100, 7)
+	dirty.bitset[len(dirty.bitset)-1] = ^uint64(0)
+	merged := NewBloomFilter(100, 7)
+	if err := merged.Union(dirty); err != nil {
+		t.Fatal(err)
+	}
+	if last := merged.bitset[len(merged.bitset)-1]; last&padding != 0 {
+		t.Errorf("after Union the last word %#x has bits set beyond m=100", last)
+	}
+}
+
+// checkDistinctProbes fails the test if the k probes of an item with
+// hashes h1 and h2 in a filter of m bits hit any bit twice.
+func checkDistinctProbes(t *testing.T, h1, h2, m, k uint64) {
+	t.Helper()
+	index, step := probeStart(h1, h2, m)
+	seen := make(map[uint64]bool, k)
+	for i := uint64(0); i < k; i++ {
+		if index >= m {
+			t.Fatalf("h1=%d h2=%d m=%d: probe %d is bit %d, beyond m", h1, h2, m, i, index)
+		}
+		if seen[index] {
+			t.Fatalf("h1=%d h2=%d m=%d: probe %d hits bit %d twice", h1, h2, m, i, index)
+		}
+		seen[index] = true
+		index = (index + step) % m
+	}
+}
+
+func TestProbesHitDistinctBits(t *testing.T) {
+	const k = 7
+	// A power of two, a prime, and non-powers of two with many small factors
+	// like the m optimalParameters computes.
+	for _, m := range []uint64{1024, 1009, 1000, 958_506} {
+		for _, item := range testItems("item", 10000) {
+			h1, h2 := getHashes(item)
+			checkDistinctProbes(t, h1, h2, m, k)
+		}
+		// A raw h2 that is a multiple of m, or shares a large factor with it,
+		// would put the probes on one bit or a short cycle.
+		for _, h2 := range []uint64{0, m, 7 * m, m / 2, m/2 + 1, m - 1} {
+			checkDistinctProbes(t, 12345, h2, m, k)
+		}
+	}
+
+	// With k equal to m, every bit is probed exactly once.
+	for _, m := range []uint64{8, 12, 30} {
+		for h2 := uint64(0); h2 < 2*m; h2++ {
+			checkDistinctProbes(t, 3, h2, m, m)
+		}
+	}
+
+	// The smallest filter that can hold k distinct bits ends up with all of
+	// them set by a single item.
+	for _, m := range []uint64{8, 12} {
+		bf := NewBloomFilter(m, m)
+		bf.Add([]byte("item"))
+		if got := bf.SetBitCount(); got != m {
+			t.Errorf("one item set %d of the %d bits with k=%d, want all of them", got, m, m)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+// maxCounter is the largest value a 4-bit counter can hold
+const maxCounter = 15
+
+// nibbleCounters packs two 4-bit counters into each byte.
+// Counter i lives in the low nibble of byte i/2 when i is even,
+// and in the high nibble when i is odd.
+type nibbleCounters []uint8
+
+// newNibbleCounters allocates room for n counters, all starting at zero
+func newNibbleCounters(n uint64) nibbleCounters {
+	return make(nibbleCounters, (n+1)/2) // Round up to the next whole byte
+}
+
+// get returns the value of counter i
+func (c nibbleCounters) get(i uint64) uint8 {
+	b := c[i/2]
+	if i%2 == 0 {
+		return b & 0x0F
+	}
+	return b >> 4
+}
+
+// set writes v (0..15) into counter i without touching its neighbor nibble
+func (c nibbleCounters) set(i uint64, v uint8) {
+	v &= 0x0F
+	if i%2 == 0 {
+		c[i/2] = (c[i/2] & 0xF0) | v
+	} else {
+		c[i/2] = (c[i/2] & 0x0F) | (v << 4)
+	}
+}
+
+// increment adds one to counter i, saturating at 15 instead of wrapping to 0
+func (c nibbleCounters) increment(i uint64) {
+	if v := c.get(i); v < maxCounter {
+		c.set(i, v+1)
+	}
+}
+
+// decrement subtracts one from counter i, flooring at 0 instead of wrapping to 15
+func (c nibbleCounters) decrement(i uint64) {
+	if v := c.get(i); v > 0 {
+		c.set(i, v-1)
+	}
+}
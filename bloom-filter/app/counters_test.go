@@ -0,0 +1,58 @@
+package main
+
+import "testing"
+
+func TestNibbleCountersSaturateWithoutTouchingNeighbors(t *testing.T) {
+	c := newNibbleCounters(5) // 3 bytes, the last high nibble unused
+	if len(c) != 3 {
+		t.Fatalf("5 counters take %d bytes, want 3", len(c))
+	}
+
+	// Give every counter a distinct value, then drive each one past both
+	// ends in turn, checking that no other counter changes.
+	want := []uint8{1, 2, 3, 4, 5}
+	for i, v := range want {
+		c.set(uint64(i), v)
+	}
+	for i := uint64(0); i < 5; i++ {
+		for n := 0; n < 20; n++ {
+			c.increment(i)
+		}
+		if got := c.get(i); got != maxCounter {
+			t.Errorf("counter %d is %d after 20 increments, want it saturated at %d", i, got, maxCounter)
+		}
+		for n := 0; n < 20; n++ {
+			c.decrement(i)
+		}
+		want[i] = 0
+		for j, v := range want {
+			if got := c.get(uint64(j)); got != v {
+				t.Errorf("after driving counter %d, counter %d is %d, want %d", i, j, got, v)
+			}
+		}
+	}
+	if c[2]>>4 != 0 {
+		t.Errorf("the unused high nibble of the last byte is %d, want 0", c[2]>>4)
+	}
+}
+
+func TestCountingBloomFilterSaturatedItem(t *testing.T) {
+	cbf := NewCountingBloomFilter(1000, 4)
+	item := []byte("hot-item")
+	for n := 0; n < 20; n++ {
+		cbf.Add(item)
+	}
+
+	// The counters stopped at 15, so the 16th remove finds the item absent.
+	for n := 1; n <= 20; n++ {
+		if removed := cbf.Remove(item); removed != (n <= maxCounter) {
+			t.Fatalf("remove %d of an item added 20 times returned %v", n, removed)
+		}
+	}
+	if cbf.Test(item) {
+		t.Error("an item added and removed 20 times is still present")
+	}
+	if other := []byte("never-added"); cbf.Test(other) {
+		t.Error("an empty filter reports an item that was never added")
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import "sync"
+
+// CountingBloomFilter is a Bloom Filter variant that supports deletion.
+// Each position holds a 4-bit counter instead of a single bit: Add increments
+// the k counters of an item and Remove decrements them. Counters saturate at
+// 15, which is plenty for the load a well-sized filter sees.
+type CountingBloomFilter struct {
+	mu       sync.RWMutex
+	m        uint64         // Number of counters
+	k        uint64         // Number of hash functions
+	counters nibbleCounters // Two 4-bit counters per byte
+}
+
+// NewCountingBloomFilter creates a Counting Bloom Filter with m counters and k hash functions.
+// It uses 4x the memory of a BloomFilter with the same m.
+func NewCountingBloomFilter(m, k uint64) *CountingBloomFilter {
+	return &CountingBloomFilter{
+		m:        m,
+		k:        k,
+		counters: newNibbleCounters(m),
+	}
+}
+
+// Add adds an item to the filter
+func (cbf *CountingBloomFilter) Add(data []byte) {
+	index, step := getProbes(data, cbf.m)
+
+	cbf.mu.Lock()
+	defer cbf.mu.Unlock()
+	for i := uint64(0); i < cbf.k; i++ {
+		cbf.counters.increment(index)
+		index = (index + step) % cbf.m
+	}
+}
+
+// Remove deletes an item from the filter. Items that are not (probably) in
+// the filter are ignored, since decrementing their counters would create
+// false negatives for other items. It reports whether the item was removed.
+func (cbf *CountingBloomFilter) Remove(data []byte) bool {
+	index, step := getProbes(data, cbf.m)
+
+	cbf.mu.Lock()
+	defer cbf.mu.Unlock()
+	if !cbf.test(index, step) {
+		return false
+	}
+	for i := uint64(0); i < cbf.k; i++ {
+		cbf.counters.decrement(index)
+		index = (index + step) % cbf.m
+	}
+	return true
+}
+
+// Test checks if an item "probably" is in the set
+func (cbf *CountingBloomFilter) Test(data []byte) bool {
+	index, step := getProbes(data, cbf.m)
+
+	cbf.mu.RLock()
+	defer cbf.mu.RUnlock()
+	return cbf.test(index, step)
+}
+
+// test checks the k counters of an item, given by its first probe and step;
+// the caller must hold the lock.
+func (cbf *CountingBloomFilter) test(index, step uint64) bool {
+	for i := uint64(0); i < cbf.k; i++ {
+		// A single zero counter means the item DEFINITELY is not in the set
+		if cbf.counters.get(index) == 0 {
+			return false
+		}
+		index = (index + step) % cbf.m
+	}
+	return true
+}
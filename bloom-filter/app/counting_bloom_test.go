@@ -0,0 +1,91 @@
+package main
+
+import "testing"
+
+// newTestCountingFilter returns a Counting Bloom Filter sized for n items at 1%.
+func newTestCountingFilter(n int) *CountingBloomFilter {
+	m, k := optimalParameters(uint64(n), 0.01)
+	return NewCountingBloomFilter(m, k)
+}
+
+func TestCountingBloomFilterAddRemoveReadd(t *testing.T) {
+	cbf := newTestCountingFilter(2000)
+	removed, kept := testItems("removed", 1000), testItems("kept", 1000)
+	for _, item := range append(removed, kept...) {
+		cbf.Add(item)
+	}
+
+	for _, item := range removed {
+		if !cbf.Remove(item) {
+			t.Fatalf("Remove(%s) of an added item returned false", item)
+		}
+	}
+	for _, item := range kept {
+		if !cbf.Test(item) {
+			t.Fatalf("%s was kept but is no longer found", item)
+		}
+	}
+	stillFound := 0
+	for _, item := range removed {
+		if cbf.Test(item) {
+			stillFound++
+		}
+	}
+	// A removed item can only still test true as a false positive, at about 1%.
+	if stillFound > 30 {
+		t.Errorf("%d of %d removed items are still found", stillFound, len(removed))
+	}
+
+	for _, item := range removed {
+		cbf.Add(item)
+	}
+	for _, item := range removed {
+		if !cbf.Test(item) {
+			t.Fatalf("%s was added again but is not found", item)
+		}
+	}
+}
+
+func TestCountingBloomFilterIgnoresRemovingAbsentItems(t *testing.T) {
+	cbf := newTestCountingFilter(100)
+	item := []byte("item")
+	cbf.Add(item)
+	if cbf.Remove([]byte("never-added")) {
+		t.Error("removing an item that was never added returned true")
+	}
+	if !cbf.Test(item) {
+		t.Error("removing an absent item made a present one disappear")
+	}
+}
+
+func TestCountingBloomFilterDeletingEverythingEmptiesIt(t *testing.T) {
+	cbf := newTestCountingFilter(1000)
+	items := testItems("item", 1000)
+	for _, item := range items {
+		cbf.Add(item)
+	}
+	for _, item := range items {
+		cbf.Remove(item)
+	}
+	// Every counter is back to zero, so nothing can be found, not even as a
+	// false positive.
+	for _, item := range items {
+		if cbf.Test(item) {
+			t.Fatalf("%s is still found after deleting every item", item)
+		}
+	}
+}
+
+func TestCountingBloomDeletionBenchmarkKeepsEveryOtherItem(t *testing.T) {
+	metrics := benchmarkCountingBloomDeletions(newNonExistentIDs(2000))
+	if metrics.Ops != 2000 {
+		t.Errorf("timed %d deletions, want 2000", metrics.Ops)
+	}
+	if metrics.FalseNegatives == nil || *metrics.FalseNegatives != 0 {
+		t.Errorf("false negatives %v after deletion, want 0", metrics.FalseNegatives)
+	}
+	// Deleted items can only still be found as false positives, at about 1%.
+	if metrics.StillFound == nil || *metrics.StillFound > 60 {
+		t.Errorf("%v of 2000 deleted items were still found", metrics.StillFound)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"os"
+
+	"github.com/google/uuid"
+)
+
+// IDSource yields the user IDs used to warm up the filters.
+// NextID returns false once the source is exhausted.
+type IDSource interface {
+	NextID() ([]byte, bool)
+}
+
+// openIDSource selects the ID source from the ID_SOURCE environment variable:
+// "postgres" (the default) seeds and reads the users table, while "memory"
+// generates random IDs so the demo runs without a database. The returned
+// *sql.DB is nil for the in-memory source.
+func openIDSource(n int) (IDSource, *sql.DB) {
+	switch source := os.Getenv("ID_SOURCE"); source {
+	case "", "postgres":
+		db := connectDB()
+		_, err := db.Exec(`CREATE TABLE IF NOT EXISTS users (id UUID PRIMARY KEY, name TEXT, profile_data TEXT)`)
+		if err != nil {
+			log.Fatalf("Failed to create table: %v", err)
+		}
+		seedDatabase(db, n)
+		return newPostgresIDSource(db), db
+	case "memory":
+		log.Printf("Using %d random in-memory IDs; database benchmarks will be skipped.", n)
+		return newMemoryIDSource(n), nil
+	default:
+		log.Fatalf("Unknown ID_SOURCE %q: use \"postgres\" or \"memory\"", source)
+		return nil, nil
+	}
+}
+
+// postgresIDSource streams the IDs of the users table.
+type postgresIDSource struct {
+	rows *sql.Rows
+}
+
+func newPostgresIDSource(db *sql.DB) *postgresIDSource {
+	rows, err := db.Query("SELECT id FROM users")
+	if err != nil {
+		log.Fatalf("Failed to fetch IDs for filter warm-up: %v", err)
+	}
+	return &postgresIDSource{rows: rows}
+}
+
+func (s *postgresIDSource) NextID() ([]byte, bool) {
+	for s.rows.Next() {
+		var id uuid.UUID
+		if err := s.rows.Scan(&id); err != nil {
+			log.Printf("Error scanning ID: %v", err)
+			continue
+		}
+		return id[:], true
+	}
+	if err := s.rows.Err(); err != nil {
+		log.Printf("Error reading IDs: %v", err)
+	}
+	s.rows.Close()
+	return nil, false
+}
+
+// memoryIDSource generates n random IDs.
+type memoryIDSource struct {
+	remaining int
+}
+
+func newMemoryIDSource(n int) *memoryIDSource {
+	return &memoryIDSource{remaining: n}
+}
+
+func (s *memoryIDSource) NextID() ([]byte, bool) {
+	if s.remaining == 0 {
+		return nil, false
+	}
+	s.remaining--
+	id := uuid.New()
+	return id[:], true
+}
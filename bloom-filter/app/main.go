@@ -1,10 +1,13 @@
 package main
 
 import (
+	"errors"
+	"io/fs"
 	"log"
+	"net/http"
+	"os"
 	"time"
 
-	"github.com/google/uuid"
 	cuckoo "github.com/seiflotfy/cuckoofilter"
 )
 
@@ -12,9 +15,9 @@ const (
 	// Using 20 million items as the target dataset size
 	n_items = 20_000_000
 	
-	// Bloom Filter parameters for n=20M, p=1%
-	m_bits   = 191_701_179 // ~23 MB
-	k_hashes = 7
+	// Target false-positive rate for the Bloom Filter.
+	// For n=20M and p=1% this gives m ≈ 191.7M bits (~23 MB) and k = 7.
+	false_positive_rate = 0.01
 
 	// Cuckoo Filter capacity (next power of 2 >= n_items)
 	// 2^25 = 33,554,432
@@ -24,48 +27,60 @@ const (
 )
 
 func main() {
-	// 1. Connect to DB and seed if necessary (code remains the same)
-	db := connectDB()
-	defer db.Close()
-	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS users (id UUID PRIMARY KEY, name TEXT, profile_data TEXT)`)
-	if err != nil {
-		log.Fatalf("Failed to create table: %v", err)
+	// 1. Open the ID source: Postgres (seeded if necessary) or random in-memory IDs
+	source, db := openIDSource(n_items)
+	if db != nil {
+		defer db.Close()
 	}
-	seedDatabase(db, n_items)
 
-	// 3. Create both filters
+	// 2. Create both filters, restoring the Bloom Filter from a snapshot if there is one
 	log.Println("Creating Bloom and Cuckoo filters in memory...")
-	bloomFilter := NewBloomFilter(m_bits, k_hashes)
-	cuckooFilter := cuckoo.NewFilter(cuckoo_capacity)
+	filters := &Filters{Cuckoo: cuckoo.NewFilter(cuckoo_capacity)}
+	snapshotPath := os.Getenv("BLOOM_SNAPSHOT")
+	if snapshotPath != "" && db == nil {
+		log.Println("Ignoring BLOOM_SNAPSHOT: in-memory IDs change on every run.")
+		snapshotPath = ""
+	}
+	if snapshotPath != "" {
+		m, k := optimalParameters(n_items, false_positive_rate)
+		bf, err := loadSnapshot(snapshotPath, m, k)
+		switch {
+		case err == nil:
+			log.Printf("Bloom Filter restored from %s.", snapshotPath)
+			filters.Bloom, filters.BloomRestored = bf, true
+		case errors.Is(err, fs.ErrNotExist):
+			log.Printf("No Bloom Filter snapshot at %s yet; it will be written after the warm-up.", snapshotPath)
+		default:
+			log.Printf("Ignoring the Bloom Filter snapshot: %v", err)
+		}
+	}
+	if filters.Bloom == nil {
+		filters.Bloom = NewBloomFilterForCapacity(n_items, false_positive_rate)
+	}
 
-	log.Println("Warming up both filters with data from the DB. This may take a while...")
+	log.Println("Warming up the filters. This may take a while...")
 	startTime := time.Now()
+	count := filters.WarmUp(source, ProgressEvery(5_000_000, func(done int) {
+		log.Printf("... %d million IDs added to filters", done/1_000_000)
+	}))
+	log.Printf("Filters warmed up with %d items in %v.", count, time.Since(startTime))
+	log.Printf("Bloom Filter estimates %d items, current false-positive rate %.4f%%.", filters.Bloom.EstimatedCount(), filters.Bloom.CurrentFalsePositiveRate()*100)
+	log.Printf("Bloom Filter uses %.1f MB with %d of %d bits set.", float64(filters.Bloom.MemoryBytes())/(1<<20), filters.Bloom.SetBitCount(), filters.Bloom.m)
 
-	rows, err := db.Query("SELECT id FROM users")
-	if err != nil {
-		log.Fatalf("Failed to fetch IDs for filter warm-up: %v", err)
+	if snapshotPath != "" && !filters.BloomRestored {
+		if err := saveSnapshot(snapshotPath, filters.Bloom); err != nil {
+			log.Printf("Error writing the Bloom Filter snapshot: %v", err)
+		} else {
+			log.Printf("Bloom Filter snapshot written to %s.", snapshotPath)
+		}
 	}
-	defer rows.Close()
 
-	var id uuid.UUID
-	count := 0
-	for rows.Next() {
-		if err := rows.Scan(&id); err != nil {
-			log.Printf("Error scanning ID: %v", err)
-			continue
-		}
-		// Add the same ID to both filters
-		idBytes := id[:]
-		bloomFilter.Add(idBytes)
-		cuckooFilter.Insert(idBytes)
-		count++
+	// 3. Run the comparative benchmarks
+	runBenchmarks(db, filters.Bloom, filters.Cuckoo, filters.ExistingIDs)
 
-		if count%5_000_000 == 0 {
-			log.Printf("... %d million IDs added to filters", count/1_000_000)
-		}
+	// 4. Optionally keep the warmed filters around to run the benchmarks on demand
+	if addr := os.Getenv("BENCHMARK_ADDR"); addr != "" {
+		log.Printf("Serving benchmarks on %s (POST /benchmark/{non-existent,existing,deletions})", addr)
+		log.Fatal(http.ListenAndServe(addr, newBenchmarkServer(db, filters.Bloom, filters.Cuckoo, filters.ExistingIDs)))
 	}
-	log.Printf("Filters warmed up with %d items in %v.", count, time.Since(startTime))
-
-	// 4. Run the comparative benchmarks
-	runBenchmarks(db, bloomFilter, cuckooFilter)
 }
\ No newline at end of file
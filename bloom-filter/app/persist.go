@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+// bloomMagic identifies a serialized BloomFilter ("BLM3"). It changes with
+// every change to the bits an item sets: "BLMF" predates forcing h2 odd and
+// "BLM2" predates the step coprime with m. Older snapshots set different
+// bits, so loading them would produce false negatives.
+const (
+	bloomMagic   uint32 = 0x424C4D33
+	bloomMagicV1 uint32 = 0x424C4D46
+	bloomMagicV2 uint32 = 0x424C4D32
+)
+
+const (
+	// maxSnapshotBits bounds the m a snapshot may declare, far above any
+	// sizing in use, so a corrupt header cannot overflow the bitset size.
+	maxSnapshotBits = 1 << 40
+	// snapshotChunkWords is how many bitset words ReadBloomFilter reads at once.
+	snapshotChunkWords = 1 << 16
+)
+
+// WriteTo serializes the filter as: magic, m, k, then the bitset words,
+// all little-endian. It implements io.WriterTo, so a warmed filter can be
+// snapshotted to disk and reloaded with ReadBloomFilter instead of warming up again.
+func (bf *BloomFilter) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	var written int64
+	buf := make([]byte, 8)
+
+	header := []uint64{uint64(bloomMagic), bf.m, bf.k}
+	for _, v := range header {
+		binary.LittleEndian.PutUint64(buf, v)
+		n, err := bw.Write(buf)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	for i := range bf.bitset {
+		binary.LittleEndian.PutUint64(buf, atomic.LoadUint64(&bf.bitset[i]))
+		n, err := bw.Write(buf)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, bw.Flush()
+}
+
+// ReadBloomFilter loads a filter written by WriteTo.
+// It rejects data that is not a serialized filter or whose bitset does not
+// match the stored m. The bitset grows as it is read, so a header claiming a
+// huge m in front of a short file fails without allocating memory for all of it.
+func ReadBloomFilter(r io.Reader) (*BloomFilter, error) {
+	br := bufio.NewReader(r)
+	header := make([]uint64, 3)
+	if err := binary.Read(br, binary.LittleEndian, header); err != nil {
+		return nil, fmt.Errorf("reading bloom filter header: %w", err)
+	}
+	if (uint32(header[0]) == bloomMagicV1 || uint32(header[0]) == bloomMagicV2) && header[0]>>32 == 0 {
+		return nil, errors.New("bloom filter snapshot uses an older hash layout; warm the filter up again")
+	}
+	if uint32(header[0]) != bloomMagic || header[0]>>32 != 0 {
+		return nil, errors.New("not a serialized bloom filter")
+	}
+
+	m, k := header[1], header[2]
+	if m == 0 || k == 0 || m > maxSnapshotBits {
+		return nil, fmt.Errorf("invalid bloom filter parameters m=%d k=%d", m, k)
+	}
+
+	words := (m + 63) / 64
+	bitset := make([]uint64, 0, min(words, snapshotChunkWords))
+	for uint64(len(bitset)) < words {
+		start := len(bitset)
+		bitset = append(bitset, make([]uint64, min(words-uint64(start), snapshotChunkWords))...)
+		if err := binary.Read(br, binary.LittleEndian, bitset[start:]); err != nil {
+			return nil, fmt.Errorf("reading bloom filter bitset (m=%d): %w", m, err)
+		}
+	}
+	bf := &BloomFilter{m: m, k: k, bitset: bitset}
+	if _, err := br.ReadByte(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected trailing data after bloom filter bitset (m=%d)", m)
+	}
+	bf.clearPadding()
+	return bf, nil
+}
+
+// CheckParams returns an error if the filter was not built with the given m
+// and k, e.g. when a snapshot on disk is stale after a sizing change.
+func (bf *BloomFilter) CheckParams(m, k uint64) error {
+	if bf.m != m || bf.k != k {
+		return fmt.Errorf("bloom filter parameters mismatch: got m=%d k=%d, expected m=%d k=%d", bf.m, bf.k, m, k)
+	}
+	return nil
+}
+
+// loadSnapshot reads a filter saved with saveSnapshot and checks that it was
+// built with m and k.
+func loadSnapshot(path string, m, k uint64) (*BloomFilter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	bf, err := ReadBloomFilter(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading snapshot %s: %w", path, err)
+	}
+	if err := bf.CheckParams(m, k); err != nil {
+		return nil, fmt.Errorf("snapshot %s: %w", path, err)
+	}
+	return bf, nil
+}
+
+// saveSnapshot writes bf to path. It writes to a temporary file first and
+// renames it, so an interrupted save never leaves a truncated snapshot.
+func saveSnapshot(path string, bf *BloomFilter) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := bf.WriteTo(file); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	cuckoo "github.com/seiflotfy/cuckoofilter"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	bf := NewBloomFilterForCapacity(10000, 0.01)
+	added := testItems("added", 10000)
+	for _, item := range added {
+		bf.Add(item)
+	}
+
+	var buf bytes.Buffer
+	if _, err := bf.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := ReadBloomFilter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.m != bf.m || loaded.k != bf.k {
+		t.Fatalf("loaded m=%d k=%d, want m=%d k=%d", loaded.m, loaded.k, bf.m, bf.k)
+	}
+	for _, item := range append(added, testItems("absent", 10000)...) {
+		if got, want := loaded.Test(item), bf.Test(item); got != want {
+			t.Fatalf("Test(%s) = %v after loading, %v before", item, got, want)
+		}
+	}
+}
+
+func TestReadBloomFilterRejectsBadData(t *testing.T) {
+	var buf bytes.Buffer
+	NewBloomFilter(100, 3).WriteTo(&buf)
+	snapshot := buf.Bytes()
+
+	for name, data := range map[string][]byte{
+		"empty":          nil,
+		"not a filter":   []byte("definitely not a bloom filter snapshot"),
+		"truncated":      snapshot[:len(snapshot)-1],
+		"trailing bytes": append(bytes.Clone(snapshot), 0),
+	} {
+		if _, err := ReadBloomFilter(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: ReadBloomFilter succeeded", name)
+		}
+	}
+}
+
+func TestReadBloomFilterDoesNotTrustAHugeM(t *testing.T) {
+	for _, m := range []uint64{maxSnapshotBits, maxSnapshotBits + 1, math.MaxUint64} {
+		header := make([]byte, 24)
+		binary.LittleEndian.PutUint64(header, uint64(bloomMagic))
+		binary.LittleEndian.PutUint64(header[8:], m)
+		binary.LittleEndian.PutUint64(header[16:], 3)
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err := ReadBloomFilter(bytes.NewReader(append(header, make([]byte, 64)...)))
+		runtime.ReadMemStats(&after)
+		if err == nil {
+			t.Errorf("m=%d: ReadBloomFilter accepted a snapshot with 8 bitset words", m)
+		}
+		// The bitset is 128 GiB at maxSnapshotBits; only what is read gets allocated
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 16<<20 {
+			t.Errorf("m=%d: ReadBloomFilter allocated %d bytes for a %d-byte snapshot", m, allocated, len(header)+64)
+		}
+	}
+}
+
+func TestSaveAndLoadSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bloom.snap")
+	if _, err := loadSnapshot(path, 100, 3); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("loading a missing snapshot: %v, want fs.ErrNotExist", err)
+	}
+
+	bf := NewBloomFilter(100, 3)
+	bf.Add([]byte("item"))
+	if err := saveSnapshot(path, bf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadSnapshot(path, 100, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Test([]byte("item")) {
+		t.Error("the loaded snapshot lost an item")
+	}
+	if _, err := loadSnapshot(path, 200, 3); err == nil {
+		t.Error("a snapshot with another m was accepted")
+	}
+}
+
+func TestWarmUpLeavesARestoredBloomFilterAlone(t *testing.T) {
+	restored := NewBloomFilter(1000, 3)
+	filters := &Filters{Bloom: restored, Cuckoo: cuckoo.NewFilter(1024), BloomRestored: true}
+
+	if n := filters.WarmUp(newMemoryIDSource(100), nil); n != 100 {
+		t.Fatalf("warmed up with %d IDs, want 100", n)
+	}
+	if restored.SetBitCount() != 0 {
+		t.Error("WarmUp added IDs to a restored Bloom Filter")
+	}
+	if filters.Cuckoo.Count() != 100 {
+		t.Errorf("the Cuckoo Filter holds %d IDs, want 100", filters.Cuckoo.Count())
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import "sync"
+
+const (
+	// scalableGrowth is how much larger each new sub-filter is than the previous one.
+	scalableGrowth = 2
+	// scalableTightening multiplies the error rate of each new sub-filter.
+	// The overall rate is bounded by p0 / (1 - r), so the first sub-filter
+	// gets p0 = p * (1 - r) to keep the total under p.
+	scalableTightening = 0.9
+)
+
+// ScalableBloomFilter is a Bloom Filter that keeps its false-positive rate
+// under a target no matter how many items are added (Almeida et al., 2007).
+// It chains standard filters: when the active one reaches its design
+// capacity, a new one that is larger and has a tighter error rate is added.
+// An item probably is in the set if any sub-filter contains it.
+type ScalableBloomFilter struct {
+	mu       sync.RWMutex
+	filters  []*BloomFilter
+	capacity uint64  // Design capacity of the active sub-filter
+	rate     float64 // False-positive rate of the active sub-filter
+	count    uint64  // Items added to the active sub-filter
+}
+
+// NewScalableBloomFilter creates a filter sized for initialCapacity items
+// that grows as needed, keeping the overall false-positive rate under p.
+func NewScalableBloomFilter(initialCapacity uint64, p float64) *ScalableBloomFilter {
+	if initialCapacity == 0 {
+		initialCapacity = 1
+	}
+	sbf := &ScalableBloomFilter{
+		capacity: initialCapacity,
+		rate:     p * (1 - scalableTightening),
+	}
+	sbf.filters = []*BloomFilter{NewBloomFilterForCapacity(sbf.capacity, sbf.rate)}
+	return sbf
+}
+
+// Add adds an item to the filter, growing it if the active sub-filter is full
+func (sbf *ScalableBloomFilter) Add(data []byte) {
+	sbf.mu.Lock()
+	defer sbf.mu.Unlock()
+
+	if sbf.count >= sbf.capacity {
+		sbf.capacity *= scalableGrowth
+		sbf.rate *= scalableTightening
+		sbf.filters = append(sbf.filters, NewBloomFilterForCapacity(sbf.capacity, sbf.rate))
+		sbf.count = 0
+	}
+	sbf.filters[len(sbf.filters)-1].Add(data)
+	sbf.count++
+}
+
+// Test checks if an item "probably" is in the set
+func (sbf *ScalableBloomFilter) Test(data []byte) bool {
+	sbf.mu.RLock()
+	defer sbf.mu.RUnlock()
+
+	// Newest first: recent items are the most likely to be looked up
+	for i := len(sbf.filters) - 1; i >= 0; i-- {
+		if sbf.filters[i].Test(data) {
+			return true
+		}
+	}
+	return false
+}
+
+// SubFilters returns how many sub-filters the filter has grown to.
+func (sbf *ScalableBloomFilter) SubFilters() int {
+	sbf.mu.RLock()
+	defer sbf.mu.RUnlock()
+	return len(sbf.filters)
+}
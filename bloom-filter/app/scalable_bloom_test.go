@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestScalableBloomFilterKeepsFalsePositiveRateWhenGrowing(t *testing.T) {
+	const capacity, target = 10000, 0.01
+	sbf := NewScalableBloomFilter(capacity, target)
+	added := testItems("added", 3*capacity)
+	for _, item := range added {
+		sbf.Add(item)
+	}
+
+	// 10k + 20k items fill the first two sub-filters exactly.
+	if n := sbf.SubFilters(); n != 2 {
+		t.Errorf("%d sub-filters for 3x the initial capacity, want 2", n)
+	}
+	for _, item := range added {
+		if !sbf.Test(item) {
+			t.Fatalf("%s was added but is not found", item)
+		}
+	}
+
+	falsePositives := 0
+	const lookups = 100000
+	for _, item := range testItems("absent", lookups) {
+		if sbf.Test(item) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / lookups; rate > target {
+		t.Errorf("false-positive rate %.4f at 3x the initial capacity, want under %.2f", rate, target)
+	}
+
+	// A fixed filter of the same initial size degrades well past the target.
+	fixed := NewBloomFilterForCapacity(capacity, target)
+	for _, item := range added {
+		fixed.Add(item)
+	}
+	if rate := fixed.CurrentFalsePositiveRate(); rate < 5*target {
+		t.Errorf("a fixed filter at 3x its capacity has a rate of %.4f, want it well above the target", rate)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+
+	cuckoo "github.com/seiflotfy/cuckoofilter"
+)
+
+// benchmarkServer exposes the warmed filters over HTTP, so the benchmarks can
+// be run again without warming up the filters from scratch.
+type benchmarkServer struct {
+	db          *sql.DB
+	bf          *BloomFilter
+	cf          *cuckoo.Filter
+	existingIDs [][]byte
+
+	// mu serializes the runs: concurrent runs would skew each other's timings,
+	// and the deletions benchmark temporarily removes items from the cuckoo filter.
+	mu sync.Mutex
+}
+
+// newBenchmarkServer returns the handler for POST /benchmark/{kind}, where kind
+// is one of "non-existent", "existing" or "deletions".
+func newBenchmarkServer(db *sql.DB, bf *BloomFilter, cf *cuckoo.Filter, existingIDs [][]byte) http.Handler {
+	s := &benchmarkServer{db: db, bf: bf, cf: cf, existingIDs: existingIDs}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /benchmark/{kind}", s.handleBenchmark)
+	return mux
+}
+
+func (s *benchmarkServer) handleBenchmark(w http.ResponseWriter, r *http.Request) {
+	kind := r.PathValue("kind")
+
+	var run func() BenchmarkReport
+	switch kind {
+	case "non-existent":
+		run = func() BenchmarkReport {
+			return benchmarkNonExistentUsers(s.db, s.bf, s.cf, newNonExistentIDs(benchmark_n))
+		}
+	case "existing":
+		if s.db == nil {
+			http.Error(w, "the existing users benchmark needs a database (ID_SOURCE=postgres)", http.StatusConflict)
+			return
+		}
+		run = func() BenchmarkReport {
+			return benchmarkExistingUsers(s.db, s.bf, s.cf, s.existingIDs)
+		}
+	case "deletions":
+		run = func() BenchmarkReport {
+			return BenchmarkReport{
+				Kind: kind,
+				Metrics: []Metrics{
+					benchmarkDeletions(s.cf, s.existingIDs),
+					benchmarkCountingBloomDeletions(s.existingIDs),
+				},
+			}
+		}
+	default:
+		http.Error(w, "unknown benchmark kind: "+kind, http.StatusNotFound)
+		return
+	}
+
+	s.mu.Lock()
+	report := run()
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("Error encoding benchmark report: %v", err)
+	}
+}
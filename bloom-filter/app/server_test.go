@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestBenchmarkServer returns a benchmark server without a database, over
+// filters warmed up with n in-memory IDs.
+func newTestBenchmarkServer(t *testing.T, n int) http.Handler {
+	t.Helper()
+	filters := newTestFilters(n)
+	if added := filters.WarmUp(newMemoryIDSource(n), nil); added != n {
+		t.Fatalf("warmed up %d IDs, want %d", added, n)
+	}
+	return newBenchmarkServer(nil, filters.Bloom, filters.Cuckoo, filters.ExistingIDs)
+}
+
+// postBenchmark runs the benchmark of the given kind through handler.
+func postBenchmark(handler http.Handler, kind string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/benchmark/"+kind, nil))
+	return rec
+}
+
+// decodeReport decodes the benchmark report in rec, failing unless the
+// request succeeded.
+func decodeReport(t *testing.T, rec *httptest.ResponseRecorder) BenchmarkReport {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	var report BenchmarkReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestBenchmarkServerNonExistent(t *testing.T) {
+	report := decodeReport(t, postBenchmark(newTestBenchmarkServer(t, 1000), "non-existent"))
+	if report.Kind != "non-existent" || len(report.Metrics) != 2 {
+		t.Fatalf("got a %q report with %d metrics, want non-existent with Bloom and Cuckoo", report.Kind, len(report.Metrics))
+	}
+	for _, metrics := range report.Metrics {
+		if metrics.Ops != benchmark_n || metrics.Total <= 0 || metrics.OpsPerSec <= 0 {
+			t.Errorf("%s: missing timings in %+v", metrics.Name, metrics)
+		}
+		if metrics.FalsePositives == nil {
+			t.Errorf("%s: no false positive count", metrics.Name)
+		}
+	}
+	if report.Conclusion == "" {
+		t.Error("the report has no conclusion")
+	}
+}
+
+func TestBenchmarkServerDeletions(t *testing.T) {
+	const n = 1000
+	report := decodeReport(t, postBenchmark(newTestBenchmarkServer(t, n), "deletions"))
+	if len(report.Metrics) != 2 {
+		t.Fatalf("got %d metrics, want Cuckoo and Counting Bloom", len(report.Metrics))
+	}
+	for _, metrics := range report.Metrics {
+		if metrics.Ops != n || metrics.Total <= 0 {
+			t.Errorf("%s: missing timings in %+v", metrics.Name, metrics)
+		}
+		if metrics.StillFound == nil {
+			t.Errorf("%s: no count of deleted items still found", metrics.Name)
+		}
+	}
+}
+
+func TestBenchmarkServerRejectedRequests(t *testing.T) {
+	handler := newTestBenchmarkServer(t, 10)
+	for _, c := range []struct {
+		kind string
+		want int
+	}{
+		{"unknown", http.StatusNotFound},
+		{"existing", http.StatusConflict}, // No database
+	} {
+		if rec := postBenchmark(handler, c.kind); rec.Code != c.want {
+			t.Errorf("POST /benchmark/%s: got %d, want %d", c.kind, rec.Code, c.want)
+		}
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/benchmark/non-existent", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /benchmark/non-existent: got %d, want 405", rec.Code)
+	}
+}
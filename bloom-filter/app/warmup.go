@@ -0,0 +1,52 @@
+package main
+
+import cuckoo "github.com/seiflotfy/cuckoofilter"
+
+// Filters holds the filters under comparison, warmed up with the same IDs.
+type Filters struct {
+	Bloom  *BloomFilter
+	Cuckoo *cuckoo.Filter
+
+	// BloomRestored means Bloom was loaded from a snapshot and already holds
+	// the IDs, so WarmUp leaves it alone.
+	BloomRestored bool
+
+	// ExistingIDs keeps the first IDs added, up to benchmark_n, as the
+	// "existing users" of the benchmarks.
+	ExistingIDs [][]byte
+}
+
+// WarmUp adds every ID of source to both filters (only the Cuckoo Filter if
+// the Bloom Filter was restored) and returns how many were added.
+// progress, if not nil, is called after each ID with the number added so
+// far; wrap it with ProgressEvery to be called less often.
+func (f *Filters) WarmUp(source IDSource, progress func(done int)) int {
+	done := 0
+	for {
+		id, ok := source.NextID()
+		if !ok {
+			return done
+		}
+		if !f.BloomRestored {
+			f.Bloom.Add(id)
+		}
+		f.Cuckoo.Insert(id)
+		if len(f.ExistingIDs) < benchmark_n {
+			f.ExistingIDs = append(f.ExistingIDs, id)
+		}
+		done++
+
+		if progress != nil {
+			progress(done)
+		}
+	}
+}
+
+// ProgressEvery returns a progress callback that only calls fn once every n items.
+func ProgressEvery(n int, fn func(done int)) func(done int) {
+	return func(done int) {
+		if done%n == 0 {
+			fn(done)
+		}
+	}
+}
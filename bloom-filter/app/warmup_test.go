@@ -0,0 +1,68 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	cuckoo "github.com/seiflotfy/cuckoofilter"
+)
+
+// newTestFilters returns empty filters sized for n IDs. Like main, the Cuckoo
+// Filter gets headroom: near full, inserts start to fail.
+func newTestFilters(n int) *Filters {
+	return &Filters{
+		Bloom:  NewBloomFilterForCapacity(uint64(n), false_positive_rate),
+		Cuckoo: cuckoo.NewFilter(uint(4 * n)),
+	}
+}
+
+func TestWarmUpAndBenchmarkFromMemory(t *testing.T) {
+	const n = 2000
+	filters := newTestFilters(n)
+	if added := filters.WarmUp(newMemoryIDSource(n), nil); added != n {
+		t.Fatalf("warmed up %d IDs, want %d", added, n)
+	}
+	if len(filters.ExistingIDs) != n {
+		t.Fatalf("kept %d existing IDs, want %d", len(filters.ExistingIDs), n)
+	}
+	for _, id := range filters.ExistingIDs {
+		if !filters.Bloom.Test(id) || !filters.Cuckoo.Lookup(id) {
+			t.Fatalf("warmed-up ID %x is missing from a filter", id)
+		}
+	}
+
+	report := benchmarkNonExistentUsers(nil, filters.Bloom, filters.Cuckoo, newNonExistentIDs(n))
+	if len(report.Metrics) != 2 {
+		t.Fatalf("got %d metrics without a database, want Bloom and Cuckoo only", len(report.Metrics))
+	}
+	for _, metrics := range report.Metrics {
+		if metrics.Ops != n {
+			t.Errorf("%s: timed %d lookups, want %d", metrics.Name, metrics.Ops, n)
+		}
+		// About 1% for Bloom and 3% for Cuckoo; allow plenty of slack.
+		if metrics.FalsePositives == nil || *metrics.FalsePositives > n/10 {
+			t.Errorf("%s: %v false positives in %d lookups", metrics.Name, metrics.FalsePositives, n)
+		}
+	}
+	if report.Conclusion == "" {
+		t.Error("the report has no conclusion")
+	}
+
+	// The whole run must go through without a database.
+	runBenchmarks(nil, filters.Bloom, filters.Cuckoo, filters.ExistingIDs)
+}
+
+func TestWarmUpReportsProgress(t *testing.T) {
+	var every, sampled []int
+	filters := newTestFilters(2500)
+	filters.WarmUp(newMemoryIDSource(2500), func(done int) { every = append(every, done) })
+	if len(every) != 2500 || every[0] != 1 || every[len(every)-1] != 2500 {
+		t.Errorf("progress was called %d times, want once per ID from 1 to 2500", len(every))
+	}
+
+	filters = newTestFilters(2500)
+	filters.WarmUp(newMemoryIDSource(2500), ProgressEvery(1000, func(done int) { sampled = append(sampled, done) }))
+	if !slices.Equal(sampled, []int{1000, 2000}) {
+		t.Errorf("ProgressEvery(1000) was called with %v, want [1000 2000]", sampled)
+	}
+}
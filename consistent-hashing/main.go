@@ -1,41 +1,370 @@
 package main
 
 import (
+	"encoding/binary"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"hash/crc32"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
 	"sort"
 	"strconv"
+	"sync"
 )
 
-type ConsistentHashing struct {
-	ring    []uint32
-	hashMap map[uint32]string
-	nodes   map[string]map[string]string
-	vnodes  int
+// Store holds the records of a single node. The ring only decides which node
+// owns a key; moving records between nodes goes through their stores, so a
+// node can be backed by a real KV store instead of the in-memory default.
+type Store[V any] interface {
+	Get(key string) (V, bool)
+	Set(key string, value V)
+	Delete(key string)
+	Keys() []string // A snapshot: the store may be modified while iterating over it
+	Len() int
 }
 
-func NewConsistentHashing(vnodes int) *ConsistentHashing {
-	return &ConsistentHashing{
-		ring:    make([]uint32, 0),
-		hashMap: make(map[uint32]string),
-		nodes:   make(map[string]map[string]string),
-		vnodes:  vnodes,
+// memoryStore is the default Store, a plain map.
+type memoryStore[V any] map[string]V
+
+func newMemoryStore[V any](nodeName string) Store[V] {
+	return make(memoryStore[V])
+}
+
+func (s memoryStore[V]) Get(key string) (V, bool) {
+	value, ok := s[key]
+	return value, ok
+}
+
+func (s memoryStore[V]) Set(key string, value V) { s[key] = value }
+
+func (s memoryStore[V]) Delete(key string) { delete(s, key) }
+
+func (s memoryStore[V]) Keys() []string {
+	keys := make([]string, 0, len(s))
+	for key := range s {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (s memoryStore[V]) Len() int { return len(s) }
+
+// ConsistentHashing is safe for concurrent use: lookups share a read lock,
+// while topology changes take the write lock. V is the type of the stored values.
+type ConsistentHashing[V any] struct {
+	mu       sync.RWMutex
+	ring     []uint64
+	hashMap  map[uint64]string
+	nodes    map[string]Store[V]
+	newStore func(nodeName string) Store[V] // Creates the store of a node when it is added
+	vnodes   int
+	hashFn   func([]byte) uint64
+	salts    map[string]map[int]int // Per node: VNode index -> salt used to escape a hash collision
+	weights  map[string]int         // Per node: VNode multiplier set by AddWeightedNode; absent means 1
+
+	loadFactor float64 // Bounded-load factor c; values below 1 disable the bound
+	spread     bool    // Derive VNode keys through mix64, see SetVNodeSpread
+}
+
+// NewConsistentHashing creates an empty ring. An optional hash function can be
+// passed to replace the default crc32.
+func NewConsistentHashing[V any](vnodes int, hashFn ...func([]byte) uint64) *ConsistentHashing[V] {
+	ch := &ConsistentHashing[V]{
+		ring:     make([]uint64, 0),
+		hashMap:  make(map[uint64]string),
+		nodes:    make(map[string]Store[V]),
+		newStore: newMemoryStore[V],
+		vnodes:   vnodes,
+		hashFn:   crc32Hash,
+		salts:    make(map[string]map[int]int),
+		weights:  make(map[string]int),
+	}
+	if len(hashFn) > 0 && hashFn[0] != nil {
+		ch.hashFn = hashFn[0]
+	}
+	return ch
+}
+
+// SetStoreFactory sets how the store of each node is created, e.g. to back
+// every node with its own KV store. It applies to nodes added afterwards, so
+// it should be called before any node is added.
+func (ch *ConsistentHashing[V]) SetStoreFactory(newStore func(nodeName string) Store[V]) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.newStore = newStore
+}
+
+// SetVNodeSpread changes how VNode keys are derived. By default the i-th
+// VNode of a node is hash("node#i"); similar strings like "node-0#1" and
+// "node-0#2" hash to correlated positions with crc32, which clumps VNodes
+// together when there are few of them. With spread enabled, the node's hash
+// is combined with a per-replica salt and run through mix64 first.
+//
+// It changes every VNode's position, so it must be called before any node
+// is added, and a ring restored with Load must use the same setting.
+func (ch *ConsistentHashing[V]) SetVNodeSpread(enabled bool) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.spread = enabled
+}
+
+// mix64 is MurmurHash3's 64-bit finalizer.
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// crc32Hash is the default hash function, widened to the ring's uint64 space.
+func crc32Hash(data []byte) uint64 {
+	return uint64(crc32.ChecksumIEEE(data))
+}
+
+// hashKey generates a hash for a string key using the ring's hash function.
+func (ch *ConsistentHashing[V]) hashKey(key string) uint64 {
+	return ch.hashFn([]byte(key))
+}
+
+// vnodeHash computes the ring position of a node's i-th VNode.
+// A non-zero salt re-hashes the VNode to a different position.
+func (ch *ConsistentHashing[V]) vnodeHash(nodeName string, i, salt int) uint64 {
+	if ch.spread {
+		// Mix the node's hash with the replica index and salt, then hash the
+		// 8 mixed bytes, so VNodes stay in the same space as key hashes.
+		seed := ch.hashKey(nodeName) + uint64(i+1)*0x9e3779b97f4a7c15 + uint64(salt)<<48
+		key := make([]byte, 8)
+		binary.LittleEndian.PutUint64(key, mix64(seed))
+		return ch.hashFn(key)
+	}
+	if salt == 0 {
+		return ch.hashKey(fmt.Sprintf("%s#%d", nodeName, i))
+	}
+	return ch.hashKey(fmt.Sprintf("%s#%d#%d", nodeName, i, salt))
+}
+
+// weight returns the VNode multiplier of a node: 1 unless it was added with
+// AddWeightedNode.
+func (ch *ConsistentHashing[V]) weight(nodeName string) int {
+	if weight, ok := ch.weights[nodeName]; ok {
+		return weight
+	}
+	return 1
+}
+
+// vnodeHashes registers all VNodes of a node in the hashMap and returns their hashes.
+func (ch *ConsistentHashing[V]) vnodeHashes(nodeName string) []uint64 {
+	return ch.vnodeHashRange(nodeName, 0, ch.vnodes*ch.weight(nodeName))
+}
+
+// vnodeHashRange registers the VNodes [from, to) of a node in the hashMap and
+// returns their hashes. If a VNode's hash is already taken, it is re-hashed
+// with an increasing salt until a free slot is found, and the salt is
+// recorded for removeVNodeRange.
+func (ch *ConsistentHashing[V]) vnodeHashRange(nodeName string, from, to int) []uint64 {
+	hashes := make([]uint64, 0, to-from)
+	for i := from; i < to; i++ {
+		salt := 0
+		hash := ch.vnodeHash(nodeName, i, salt)
+		for {
+			if _, taken := ch.hashMap[hash]; !taken {
+				break
+			}
+			salt++
+			hash = ch.vnodeHash(nodeName, i, salt)
+		}
+		if salt > 0 {
+			if ch.salts[nodeName] == nil {
+				ch.salts[nodeName] = make(map[int]int)
+			}
+			ch.salts[nodeName][i] = salt
+		}
+		hashes = append(hashes, hash)
+		ch.hashMap[hash] = nodeName
+	}
+	return hashes
+}
+
+// addVNodes places all VNodes of a node on the ring, keeping it sorted.
+// Only the new hashes are sorted; they are then merged into the ring in O(n),
+// instead of re-sorting the whole ring on every addition.
+func (ch *ConsistentHashing[V]) addVNodes(nodeName string) {
+	hashes := ch.vnodeHashes(nodeName)
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+
+	merged := make([]uint64, 0, len(ch.ring)+len(hashes))
+	i, j := 0, 0
+	for i < len(ch.ring) && j < len(hashes) {
+		if ch.ring[i] <= hashes[j] {
+			merged = append(merged, ch.ring[i])
+			i++
+		} else {
+			merged = append(merged, hashes[j])
+			j++
+		}
+	}
+	merged = append(merged, ch.ring[i:]...)
+	merged = append(merged, hashes[j:]...)
+	ch.ring = merged
+}
+
+// appendVNodes places all VNodes of a node on the ring without sorting it.
+// It is meant for bulk loads; the caller must call sortRing once at the end.
+func (ch *ConsistentHashing[V]) appendVNodes(nodeName string) {
+	ch.ring = append(ch.ring, ch.vnodeHashes(nodeName)...)
+}
+
+// removeVNodes takes all VNodes of a node off the ring, keeping it sorted.
+func (ch *ConsistentHashing[V]) removeVNodes(nodeName string) {
+	ch.removeVNodeRange(nodeName, 0, ch.vnodes*ch.weight(nodeName))
+	delete(ch.salts, nodeName)
+	delete(ch.weights, nodeName)
+}
+
+// removeVNodeRange takes the VNodes [from, to) of a node off the ring, keeping it sorted.
+func (ch *ConsistentHashing[V]) removeVNodeRange(nodeName string, from, to int) {
+	hashesToRemove := make(map[uint64]bool)
+	for i := from; i < to; i++ {
+		hash := ch.vnodeHash(nodeName, i, ch.salts[nodeName][i])
+		hashesToRemove[hash] = true
+		delete(ch.hashMap, hash)
+		delete(ch.salts[nodeName], i)
 	}
+	newRing := make([]uint64, 0, len(ch.ring))
+	for _, hash := range ch.ring {
+		if !hashesToRemove[hash] {
+			newRing = append(newRing, hash)
+		}
+	}
+	ch.ring = newRing
 }
 
-// hashKey generates a uint32 hash for a string key.
-func hashKey(key string) uint32 {
-	return crc32.ChecksumIEEE([]byte(key))
+// sortRing restores the ring's ordering after VNodes were appended.
+func (ch *ConsistentHashing[V]) sortRing() {
+	sort.Slice(ch.ring, func(i, j int) bool { return ch.ring[i] < ch.ring[j] })
 }
 
+// SetVNodes changes the number of VNodes per node to n without rebuilding the
+// ring: every node gains VNodes n-1 down to the current count, or loses the
+// ones above n, both scaled by the node's weight. Keys whose owner changed
+// are then moved, and their number is returned. More VNodes smooth out the
+// arcs and so lower LoadImbalance.
+func (ch *ConsistentHashing[V]) SetVNodes(n int) (moved int, err error) {
+	if n < 1 {
+		return 0, fmt.Errorf("invalid VNode count %d: must be at least 1", n)
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if n == ch.vnodes {
+		return 0, nil
+	}
+
+	// Nodes are handled in name order so hash collisions resolve the same way
+	// on every run.
+	nodeNames := sortedKeys(ch.nodes)
+	if n > ch.vnodes {
+		for _, nodeName := range nodeNames {
+			weight := ch.weight(nodeName)
+			ch.ring = append(ch.ring, ch.vnodeHashRange(nodeName, ch.vnodes*weight, n*weight)...)
+		}
+		ch.sortRing()
+	} else {
+		for _, nodeName := range nodeNames {
+			weight := ch.weight(nodeName)
+			ch.removeVNodeRange(nodeName, n*weight, ch.vnodes*weight)
+		}
+	}
+	fmt.Printf("\n🔧 Changing VNodes per node from %d to %d...\n", ch.vnodes, n)
+	ch.vnodes = n
+
+	for sourceNode, store := range ch.nodes {
+		for _, key := range store.Keys() {
+			targetNode, _ := ch.ringOwner(key)
+			if targetNode == sourceNode {
+				continue
+			}
+			value, _ := store.Get(key)
+			ch.nodes[targetNode].Set(key, value)
+			store.Delete(key)
+			moved++
+		}
+	}
+
+	fmt.Printf("✅ %d records were moved.\n", moved)
+	return moved, nil
+}
 
 // GetNode finds the node responsible for a data key.
-func (ch *ConsistentHashing) GetNode(key string) (string, error) {
+func (ch *ConsistentHashing[V]) GetNode(key string) (string, error) {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+	return ch.getNode(key)
+}
+
+// Set stores a record on the node responsible for its key and returns that node.
+func (ch *ConsistentHashing[V]) Set(key string, value V) (string, error) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	nodeName, err := ch.getNode(key)
+	if err != nil {
+		return "", err
+	}
+	ch.nodes[nodeName].Set(key, value)
+	return nodeName, nil
+}
+
+// getNode is the lock-free core of GetNode, for callers already holding the lock.
+func (ch *ConsistentHashing[V]) getNode(key string) (string, error) {
+	idx, err := ch.ringIndex(key)
+	if err != nil {
+		return "", err
+	}
+
+	if ch.loadFactor < 1 {
+		nodeHash := ch.ring[idx]
+		return ch.hashMap[nodeHash], nil
+	}
+
+	// Bounded loads: skip nodes that are already at capacity and keep
+	// walking clockwise until a node with room is found.
+	bound := ch.loadBound()
+	for i := 0; i < len(ch.ring); i++ {
+		nodeName := ch.hashMap[ch.ring[(idx+i)%len(ch.ring)]]
+		if ch.nodes[nodeName].Len()+1 <= bound {
+			return nodeName, nil
+		}
+	}
+	return ch.hashMap[ch.ring[idx]], nil
+}
+
+// ringOwner finds the node whose arc a key falls in, ignoring bounded loads.
+// Rebalancing moves stored keys to it and verification expects them there:
+// with the bound, getNode's answer depends on the current loads, which the
+// moves themselves change. The caller holds the lock.
+func (ch *ConsistentHashing[V]) ringOwner(key string) (string, error) {
+	idx, err := ch.ringIndex(key)
+	if err != nil {
+		return "", err
+	}
+	return ch.hashMap[ch.ring[idx]], nil
+}
+
+// ringIndex returns the index of the first VNode clockwise from a key's hash.
+func (ch *ConsistentHashing[V]) ringIndex(key string) (int, error) {
 	if len(ch.ring) == 0 {
-		return "", fmt.Errorf("no nodes in the ring")
+		return 0, fmt.Errorf("no nodes in the ring")
 	}
 
-	keyHash := hashKey(key)
+	keyHash := ch.hashKey(key)
 
 	// Find the first node in the ring whose hash is >= the key hash.
 	idx := sort.Search(len(ch.ring), func(i int) bool {
@@ -47,30 +376,180 @@ func (ch *ConsistentHashing) GetNode(key string) (string, error) {
 	if idx == len(ch.ring) {
 		idx = 0
 	}
+	return idx, nil
+}
+
+// loadBound returns the maximum number of records a node may hold under
+// bounded loads: ceil(averageLoad * c), counting the key being placed.
+func (ch *ConsistentHashing[V]) loadBound() int {
+	total := 1
+	for _, store := range ch.nodes {
+		total += store.Len()
+	}
+	average := float64(total) / float64(len(ch.nodes))
+	return int(math.Ceil(average * ch.loadFactor))
+}
+
+// SetLoadFactor enables "consistent hashing with bounded loads" with factor c:
+// GetNode skips any node whose record count would exceed ceil(averageLoad * c).
+// Values of c below 1 disable the bound and restore plain consistent hashing.
+//
+// With the bound enabled, a key's owner depends on the current loads, so
+// lookups are meant for placing new keys rather than locating stored ones.
+// Rebalancing ignores the bound: AddNode, SetVNodes and ApplyPlan move keys
+// to the node whose arc they fall in, and check them there.
+func (ch *ConsistentHashing[V]) SetLoadFactor(c float64) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.loadFactor = c
+}
+
+// GetNodes returns the 'replicas' distinct physical nodes responsible for a key.
+// It walks the ring clockwise from the key's hash, skipping VNodes that belong
+// to nodes already selected, and wraps around the end of the ring.
+func (ch *ConsistentHashing[V]) GetNodes(key string, replicas int) ([]string, error) {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
+	if len(ch.ring) == 0 {
+		return nil, fmt.Errorf("no nodes in the ring")
+	}
+	if replicas < 1 {
+		return nil, fmt.Errorf("invalid replica count %d: must be at least 1", replicas)
+	}
+	if replicas > len(ch.nodes) {
+		return nil, fmt.Errorf("cannot place %d replicas on %d nodes", replicas, len(ch.nodes))
+	}
+
+	keyHash := ch.hashKey(key)
+	start := sort.Search(len(ch.ring), func(i int) bool {
+		return ch.ring[i] >= keyHash
+	})
+
+	result := make([]string, 0, replicas)
+	seen := make(map[string]bool)
+	for i := 0; i < len(ch.ring) && len(result) < replicas; i++ {
+		nodeName := ch.hashMap[ch.ring[(start+i)%len(ch.ring)]]
+		if seen[nodeName] {
+			continue
+		}
+		seen[nodeName] = true
+		result = append(result, nodeName)
+	}
+	return result, nil
+}
+
+// GetNodesForKeys routes many keys at once and groups them by owner node.
+// Instead of a binary search per key, the key hashes are sorted and swept
+// against the ring in a single pass.
+func (ch *ConsistentHashing[V]) GetNodesForKeys(keys []string) map[string][]string {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
+	result := make(map[string][]string)
+	if len(ch.ring) == 0 {
+		return result
+	}
+
+	type hashedKey struct {
+		key  string
+		hash uint64
+	}
+	hashed := make([]hashedKey, len(keys))
+	for i, key := range keys {
+		hashed[i] = hashedKey{key: key, hash: ch.hashKey(key)}
+	}
+	sort.Slice(hashed, func(i, j int) bool { return hashed[i].hash < hashed[j].hash })
+
+	idx := 0
+	for _, hk := range hashed {
+		// Advance to the first VNode whose hash is >= the key hash.
+		for idx < len(ch.ring) && ch.ring[idx] < hk.hash {
+			idx++
+		}
+		// Past the last VNode, keys wrap around to the first one.
+		nodeName := ch.hashMap[ch.ring[idx%len(ch.ring)]]
+		result[nodeName] = append(result[nodeName], hk.key)
+	}
+	return result
+}
 
-	nodeHash := ch.ring[idx]
-	return ch.hashMap[nodeHash], nil
+// RebalanceReport describes the data moved by AddNode or RemoveNode.
+// Consistent hashing predicts that about 1/N of the keys move, where N is the
+// number of nodes with the changed node included; ExpectedFraction holds that
+// figure to compare FractionMoved against.
+type RebalanceReport struct {
+	Node             string
+	KeysMoved        int
+	TotalKeys        int
+	FractionMoved    float64 // KeysMoved / TotalKeys, 0 when there are no keys
+	ExpectedFraction float64 // 1/N
 }
 
-// AddNode adds a node and redistributes data from other nodes to it.
-func (ch *ConsistentHashing) AddNode(nodeName string) {
+// newRebalanceReport builds the report of a change of nodeName on a ring that
+// has numNodes nodes when nodeName is included.
+func newRebalanceReport(nodeName string, moved, total, numNodes int) RebalanceReport {
+	report := RebalanceReport{
+		Node:             nodeName,
+		KeysMoved:        moved,
+		TotalKeys:        total,
+		ExpectedFraction: 1 / float64(numNodes),
+	}
+	if total > 0 {
+		report.FractionMoved = float64(moved) / float64(total)
+	}
+	return report
+}
+
+// totalKeys counts the records stored across all nodes. The caller holds the lock.
+func (ch *ConsistentHashing[V]) totalKeys() int {
+	total := 0
+	for _, store := range ch.nodes {
+		total += store.Len()
+	}
+	return total
+}
+
+// AddNode adds a node and redistributes data from other nodes to it, returning
+// a report of the records moved. Adding a node that already exists is a no-op.
+//
+// After the move, every moved key is checked to resolve to the new node and
+// to be stored there; an error is returned if any is misplaced, since that
+// means the ring and the stores no longer agree.
+func (ch *ConsistentHashing[V]) AddNode(nodeName string) (RebalanceReport, error) {
+	return ch.AddWeightedNode(nodeName, 1)
+}
+
+// AddWeightedNode is AddNode for a node with weight times as many VNodes as
+// the others, so it owns about weight times as many keys, e.g. for a machine
+// with more capacity. The weight applies while the node stays on the ring.
+func (ch *ConsistentHashing[V]) AddWeightedNode(nodeName string, weight int) (RebalanceReport, error) {
+	if weight < 1 {
+		return RebalanceReport{Node: nodeName}, fmt.Errorf("invalid weight %d for node '%s': must be at least 1", weight, nodeName)
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.addWeightedNode(nodeName, weight)
+}
+
+// addWeightedNode is AddWeightedNode for callers holding the lock and having
+// checked the weight.
+func (ch *ConsistentHashing[V]) addWeightedNode(nodeName string, weight int) (RebalanceReport, error) {
 	if _, exists := ch.nodes[nodeName]; exists {
 		fmt.Printf("! Node '%s' already exists.\n", nodeName)
-		return
+		return newRebalanceReport(nodeName, 0, ch.totalKeys(), len(ch.nodes)), nil
 	}
 
 	fmt.Printf("\n✨ Adding node '%s' and redistributing data...\n", nodeName)
 
 	// 1. Add the new node and its VNodes to the ring first.
 	// This updates the state so that GetNode works correctly for redistribution.
-	ch.nodes[nodeName] = make(map[string]string)
-	for i := 0; i < ch.vnodes; i++ {
-		vnodeKey := fmt.Sprintf("%s#%d", nodeName, i)
-		hash := hashKey(vnodeKey)
-		ch.ring = append(ch.ring, hash)
-		ch.hashMap[hash] = nodeName
+	ch.nodes[nodeName] = ch.newStore(nodeName)
+	if weight > 1 {
+		ch.weights[nodeName] = weight
 	}
-	sort.Slice(ch.ring, func(i, j int) bool { return ch.ring[i] < ch.ring[j] })
+	ch.addVNodes(nodeName)
 
 	// 2. Find and move the data that now belongs to the new node.
 	keysMoved := 0
@@ -80,12 +559,12 @@ func (ch *ConsistentHashing) AddNode(nodeName string) {
 	// all the keys to be moved.
 	keysToMove := make(map[string][]string) // Map of: sourceNode -> [keys]
 
-	for sourceNode, data := range ch.nodes {
+	for sourceNode, store := range ch.nodes {
 		if sourceNode == nodeName {
 			continue
 		}
-		for key := range data {
-			targetNode, _ := ch.GetNode(key)
+		for _, key := range store.Keys() {
+			targetNode, _ := ch.ringOwner(key)
 			if targetNode == nodeName {
 				keysToMove[sourceNode] = append(keysToMove[sourceNode], key)
 			}
@@ -95,102 +574,631 @@ func (ch *ConsistentHashing) AddNode(nodeName string) {
 	// Now, we actually move the keys.
 	for sourceNode, keys := range keysToMove {
 		for _, key := range keys {
-			value := ch.nodes[sourceNode][key]
-			ch.nodes[nodeName][key] = value
-			delete(ch.nodes[sourceNode], key)
+			value, _ := ch.nodes[sourceNode].Get(key)
+			ch.nodes[nodeName].Set(key, value)
+			ch.nodes[sourceNode].Delete(key)
 			movesBySource[sourceNode]++
 			keysMoved++
 		}
 	}
 
-	fmt.Printf("✅ %d records were moved to the new node '%s'.\n", keysMoved, nodeName)
-	if len(movesBySource) > 0 {
-		for sourceNode, count := range movesBySource {
-			fmt.Printf("  -> From '%s': %d records\n", sourceNode, count)
+	report := newRebalanceReport(nodeName, keysMoved, ch.totalKeys(), len(ch.nodes))
+	fmt.Printf("✅ %d records were moved to the new node '%s' (%.2f%% of all keys, ~%.2f%% expected).\n", keysMoved, nodeName, report.FractionMoved*100, report.ExpectedFraction*100)
+	for _, sourceNode := range sortedKeys(movesBySource) {
+		fmt.Printf("  -> From '%s': %d records\n", sourceNode, movesBySource[sourceNode])
+	}
+
+	var misplaced []string
+	for _, keys := range keysToMove {
+		misplaced = append(misplaced, ch.misplacedKeys(keys)...)
+	}
+	if len(misplaced) > 0 {
+		return report, fmt.Errorf("%d of %d keys moved to node '%s' are misplaced, e.g. '%s'", len(misplaced), keysMoved, nodeName, misplaced[0])
+	}
+	return report, nil
+}
+
+// misplacedKeys returns the keys that are not stored on the node ringOwner
+// resolves them to. It is the check verifyKeys runs, for callers holding the lock.
+func (ch *ConsistentHashing[V]) misplacedKeys(keys []string) []string {
+	var misplaced []string
+	for _, key := range keys {
+		nodeName, err := ch.ringOwner(key)
+		if err != nil {
+			misplaced = append(misplaced, key)
+			continue
+		}
+		if _, found := ch.nodes[nodeName].Get(key); !found {
+			misplaced = append(misplaced, key)
 		}
 	}
+	return misplaced
 }
 
-// RemoveNode removes a node and redistributes its data to other nodes.
-func (ch *ConsistentHashing) RemoveNode(nodeName string) error {
+// AddNodes adds several nodes at once, sorting the ring a single time at the
+// end. It is intended for bootstrapping a cluster; any existing data that now
+// belongs to one of the new nodes is moved to it.
+func (ch *ConsistentHashing[V]) AddNodes(names []string) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	added := make(map[string]bool)
+	for _, nodeName := range names {
+		if _, exists := ch.nodes[nodeName]; exists {
+			fmt.Printf("! Node '%s' already exists.\n", nodeName)
+			continue
+		}
+		ch.nodes[nodeName] = ch.newStore(nodeName)
+		ch.appendVNodes(nodeName)
+		added[nodeName] = true
+	}
+	ch.sortRing()
+
+	for sourceNode, store := range ch.nodes {
+		if added[sourceNode] {
+			continue
+		}
+		for _, key := range store.Keys() {
+			targetNode, _ := ch.ringOwner(key)
+			if added[targetNode] {
+				value, _ := store.Get(key)
+				ch.nodes[targetNode].Set(key, value)
+				store.Delete(key)
+			}
+		}
+	}
+}
+
+// nodeConfig is one entry of a node list file read by LoadNodes.
+type nodeConfig struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight,omitempty"` // 0 or omitted means 1
+}
+
+// LoadNodes adds the nodes listed in a JSON file, e.g.
+//
+//	[{"name": "node-0"}, {"name": "node-1", "weight": 2}]
+//
+// with AddWeightedNode. The whole list is checked before any node is added,
+// under the same lock, so no other call can change the ring in between: a
+// missing name, a negative weight, a name listed twice or a node already on
+// the ring is an error and leaves the ring unchanged.
+func (ch *ConsistentHashing[V]) LoadNodes(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening node list: %w", err)
+	}
+	defer file.Close()
+
+	var nodes []nodeConfig
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&nodes); err != nil {
+		return fmt.Errorf("decoding node list %s: %w", path, err)
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	seen := make(map[string]bool, len(nodes))
+	for i, node := range nodes {
+		_, exists := ch.nodes[node.Name]
+		switch {
+		case node.Name == "":
+			err = fmt.Errorf("node %d in %s has no name", i, path)
+		case node.Weight < 0:
+			err = fmt.Errorf("invalid weight %d for node '%s' in %s", node.Weight, node.Name, path)
+		case seen[node.Name]:
+			err = fmt.Errorf("node '%s' is listed twice in %s", node.Name, path)
+		case exists:
+			err = fmt.Errorf("node '%s' in %s is already on the ring", node.Name, path)
+		}
+		if err != nil {
+			break
+		}
+		seen[node.Name] = true
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		if _, err := ch.addWeightedNode(node.Name, max(node.Weight, 1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ownerExcluding finds the node responsible for a key as if 'excluded' were
+// not on the ring, by skipping its VNodes while walking clockwise.
+// It returns "" if no other node is on the ring.
+func (ch *ConsistentHashing[V]) ownerExcluding(key, excluded string) string {
+	keyHash := ch.hashKey(key)
+	start := sort.Search(len(ch.ring), func(i int) bool {
+		return ch.ring[i] >= keyHash
+	})
+	for i := 0; i < len(ch.ring); i++ {
+		nodeName := ch.hashMap[ch.ring[(start+i)%len(ch.ring)]]
+		if nodeName != excluded {
+			return nodeName
+		}
+	}
+	return ""
+}
+
+// planRemoval computes, without changing any state, where each key stored on
+// nodeName would go if the node were removed. It returns key -> destination.
+func (ch *ConsistentHashing[V]) planRemoval(nodeName string) map[string]string {
+	destinations := make(map[string]string, ch.nodes[nodeName].Len())
+	for _, key := range ch.nodes[nodeName].Keys() {
+		destinations[key] = ch.ownerExcluding(key, nodeName)
+	}
+	return destinations
+}
+
+// PlanRemoveNode previews the impact of RemoveNode: it returns, per destination
+// node, how many keys would move there. No data is moved.
+func (ch *ConsistentHashing[V]) PlanRemoveNode(nodeName string) (map[string]int, error) {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
 	if _, exists := ch.nodes[nodeName]; !exists {
-		return fmt.Errorf("node '%s' not found", nodeName)
+		return nil, fmt.Errorf("node '%s' not found", nodeName)
 	}
 
-	fmt.Printf("\nRemoving node '%s' and redistributing its data...\n", nodeName)
+	movesByDest := make(map[string]int)
+	for _, destNode := range ch.planRemoval(nodeName) {
+		movesByDest[destNode]++
+	}
+	return movesByDest, nil
+}
 
-	// 1. Save the data to be moved BEFORE changing the ring.
-	dataToMove := ch.nodes[nodeName]
+// RemoveNode removes a node and redistributes its data to other nodes,
+// returning a report of the records moved.
+// If it is the last node, there is nowhere to move its data: the node is
+// removed anyway, leaving the ring empty, and its data is returned to the
+// caller as orphaned. Otherwise orphaned is nil.
+func (ch *ConsistentHashing[V]) RemoveNode(nodeName string) (report RebalanceReport, orphaned map[string]V, err error) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
 
-	// 2. Remove all VNodes from the ring.
-	hashesToRemove := make(map[uint32]bool)
-	for i := 0; i < ch.vnodes; i++ {
-		vnodeKey := fmt.Sprintf("%s#%d", nodeName, i)
-		hash := hashKey(vnodeKey)
-		hashesToRemove[hash] = true
-		delete(ch.hashMap, hash)
+	if _, exists := ch.nodes[nodeName]; !exists {
+		return RebalanceReport{}, nil, fmt.Errorf("node '%s' not found", nodeName)
 	}
-	newRing := make([]uint32, 0, len(ch.ring))
-	for _, hash := range ch.ring {
-		if !hashesToRemove[hash] {
-			newRing = append(newRing, hash)
+	total, numNodes := ch.totalKeys(), len(ch.nodes)
+
+	if len(ch.nodes) == 1 {
+		fmt.Printf("\nRemoving the last node '%s'; its data has nowhere to go...\n", nodeName)
+		store := ch.nodes[nodeName]
+		orphaned = make(map[string]V, store.Len())
+		for _, key := range store.Keys() {
+			orphaned[key], _ = store.Get(key)
 		}
+		ch.removeVNodes(nodeName)
+		delete(ch.nodes, nodeName)
+		fmt.Printf("! %d records from node '%s' were orphaned.\n", len(orphaned), nodeName)
+		return newRebalanceReport(nodeName, 0, total, numNodes), orphaned, nil
 	}
-	ch.ring = newRing
 
-	// 3. Delete the node from the storage map. The data map is still in 'dataToMove'.
+	fmt.Printf("\nRemoving node '%s' and redistributing its data...\n", nodeName)
+
+	// 1. Keep the store to move data from and plan its destinations BEFORE changing the ring.
+	source := ch.nodes[nodeName]
+	destinations := ch.planRemoval(nodeName)
+
+	// 2. Remove all VNodes from the ring.
+	ch.removeVNodes(nodeName)
+
+	// 3. Delete the node from the storage map. Its store is still in 'source'.
 	delete(ch.nodes, nodeName)
 
 	// 4. Redistribute the data to their new destination nodes.
 	movesByDest := make(map[string]int)
-	for key, value := range dataToMove {
-		newNode, _ := ch.GetNode(key)
-		ch.nodes[newNode][key] = value
+	for key, newNode := range destinations {
+		value, _ := source.Get(key)
+		ch.nodes[newNode].Set(key, value)
+		source.Delete(key)
 		movesByDest[newNode]++
 	}
 
-	fmt.Printf("✅ %d records were moved from node '%s'.\n", len(dataToMove), nodeName)
-	if len(movesByDest) > 0 {
-		for destNode, count := range movesByDest {
-			fmt.Printf("  -> To '%s': %d records\n", destNode, count)
+	report = newRebalanceReport(nodeName, len(destinations), total, numNodes)
+	fmt.Printf("✅ %d records were moved from node '%s' (%.2f%% of all keys, ~%.2f%% expected).\n", len(destinations), nodeName, report.FractionMoved*100, report.ExpectedFraction*100)
+	for _, destNode := range sortedKeys(movesByDest) {
+		fmt.Printf("  -> To '%s': %d records\n", destNode, movesByDest[destNode])
+	}
+	return report, nil, nil
+}
+
+// Migration describes a single key moving from one node to another.
+type Migration struct {
+	Key  string
+	From string
+	To   string
+}
+
+// ApplyPlan applies several topology changes at once. The ring is brought to
+// its final state first, so every affected key is moved at most once, straight
+// to its final destination, instead of hopping between intermediate owners.
+func (ch *ConsistentHashing[V]) ApplyPlan(adds []string, removes []string) []Migration {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	// 1. Work out which changes actually apply to the current topology.
+	toAdd := make(map[string]bool)
+	for _, nodeName := range adds {
+		if _, exists := ch.nodes[nodeName]; exists {
+			fmt.Printf("! Node '%s' already exists.\n", nodeName)
+			continue
+		}
+		toAdd[nodeName] = true
+	}
+	toRemove := make(map[string]bool)
+	for _, nodeName := range removes {
+		if _, exists := ch.nodes[nodeName]; !exists && !toAdd[nodeName] {
+			fmt.Printf("! Node '%s' not found.\n", nodeName)
+			continue
+		}
+		toRemove[nodeName] = true
+	}
+	if len(ch.nodes)+len(toAdd)-len(toRemove) <= 0 {
+		fmt.Println("! The plan would leave the ring empty; nothing was applied.")
+		return nil
+	}
+
+	fmt.Printf("\n📋 Applying plan: +%d / -%d nodes...\n", len(toAdd), len(toRemove))
+
+	// 2. Bring the ring to its final state.
+	for nodeName := range toAdd {
+		ch.nodes[nodeName] = ch.newStore(nodeName)
+		ch.appendVNodes(nodeName)
+	}
+	ch.sortRing()
+	for nodeName := range toRemove {
+		ch.removeVNodes(nodeName)
+	}
+
+	// 3. Find every key whose final owner differs from where it lives now.
+	var migrations []Migration
+	for sourceNode, store := range ch.nodes {
+		for _, key := range store.Keys() {
+			targetNode, _ := ch.ringOwner(key)
+			if targetNode != sourceNode {
+				migrations = append(migrations, Migration{Key: key, From: sourceNode, To: targetNode})
+			}
+		}
+	}
+
+	// 4. Move each key once, then drop the removed nodes' storage.
+	for _, m := range migrations {
+		value, _ := ch.nodes[m.From].Get(m.Key)
+		ch.nodes[m.To].Set(m.Key, value)
+		ch.nodes[m.From].Delete(m.Key)
+	}
+	for nodeName := range toRemove {
+		delete(ch.nodes, nodeName)
+	}
+
+	fmt.Printf("✅ %d records were moved to their final nodes.\n", len(migrations))
+	return migrations
+}
+
+// Stats returns the number of records stored on each node.
+func (ch *ConsistentHashing[V]) Stats() map[string]int {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
+	stats := make(map[string]int, len(ch.nodes))
+	for name, store := range ch.nodes {
+		stats[name] = store.Len()
+	}
+	return stats
+}
+
+// Locations returns the node each stored key lives on.
+func (ch *ConsistentHashing[V]) Locations() map[string]string {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
+	locations := make(map[string]string, ch.totalKeys())
+	for nodeName, store := range ch.nodes {
+		for _, key := range store.Keys() {
+			locations[key] = nodeName
+		}
+	}
+	return locations
+}
+
+// LoadImbalance returns the ratio between the most loaded node's record count
+// and the mean record count across all nodes. A perfectly balanced ring
+// returns 1.0; an empty ring (no nodes or no records) also returns 1.0.
+func (ch *ConsistentHashing[V]) LoadImbalance() float64 {
+	stats := ch.Stats()
+	if len(stats) == 0 {
+		return 1.0
+	}
+
+	total, maxCount := 0, 0
+	for _, count := range stats {
+		total += count
+		maxCount = max(maxCount, count)
+	}
+	if total == 0 {
+		return 1.0
+	}
+
+	mean := float64(total) / float64(len(stats))
+	return float64(maxCount) / mean
+}
+
+// DistributionUniformity runs Pearson's chi-squared test of the records per
+// node against a uniform split: it returns the statistic
+// sum((count - expected)^2 / expected) and its degrees of freedom (nodes - 1).
+// A node's expected count is its share of the total by weight, which is the
+// mean when no node was added with AddWeightedNode.
+//
+// If the keys were spread uniformly at random, chiSquare would follow the
+// chi-squared distribution with df degrees of freedom, whose mean is df.
+// A statistic above the critical value for df (e.g. 16.92 for df = 9 at the
+// 5% level) rejects uniformity. Note that a ring gives each node an arc of
+// unequal size, so with enough keys the statistic grows with the key count
+// even for a reasonable ring: compare rings at the same key count. An empty
+// ring returns (0, 0) and a ring without records (0, nodes - 1).
+func (ch *ConsistentHashing[V]) DistributionUniformity() (chiSquare float64, df int) {
+	stats := ch.Stats()
+	if len(stats) == 0 {
+		return 0, 0
+	}
+
+	total := 0
+	for _, count := range stats {
+		total += count
+	}
+	df = len(stats) - 1
+	if total == 0 {
+		return 0, df
+	}
+
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+	totalWeight := 0
+	for nodeName := range stats {
+		totalWeight += ch.weight(nodeName)
+	}
+	for nodeName, count := range stats {
+		expected := float64(total) * float64(ch.weight(nodeName)) / float64(totalWeight)
+		diff := float64(count) - expected
+		chiSquare += diff * diff / expected
+	}
+	return chiSquare, df
+}
+
+// GapCV returns the coefficient of variation (standard deviation / mean) of
+// the gaps between consecutive VNodes. Lower means more evenly spaced VNodes
+// and so more even arcs. The wrap-around gap is left out, since the size of
+// the hash space depends on the hash function.
+func (ch *ConsistentHashing[V]) GapCV() float64 {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
+	if len(ch.ring) < 3 {
+		return 0
+	}
+	gaps := make([]float64, len(ch.ring)-1)
+	mean := 0.0
+	for i := range gaps {
+		gaps[i] = float64(ch.ring[i+1] - ch.ring[i])
+		mean += gaps[i]
+	}
+	mean /= float64(len(gaps))
+
+	variance := 0.0
+	for _, gap := range gaps {
+		variance += (gap - mean) * (gap - mean)
+	}
+	variance /= float64(len(gaps))
+	return math.Sqrt(variance) / mean
+}
+
+// VNode is a single point on the ring and the physical node that owns it.
+type VNode struct {
+	Hash uint64 `json:"hash"`
+	Node string `json:"node"`
+}
+
+// RingLayout returns every VNode on the ring, sorted by hash. It is meant for
+// debugging distribution problems, e.g. plotting the gaps between VNodes.
+func (ch *ConsistentHashing[V]) RingLayout() []VNode {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
+	layout := make([]VNode, len(ch.ring))
+	for i, hash := range ch.ring {
+		layout[i] = VNode{Hash: hash, Node: ch.hashMap[hash]}
+	}
+	return layout
+}
+
+// nodeResponse is the body returned by GET /node/{key}.
+type nodeResponse struct {
+	Key  string `json:"key"`
+	Node string `json:"node"`
+}
+
+// newRingServer exposes a ring over HTTP, e.g. to feed a visualization:
+//
+//	GET /ring        every VNode, sorted by hash (see RingLayout)
+//	GET /node/{key}  the node owning a key
+func newRingServer[V any](ch *ConsistentHashing[V]) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ring", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ch.RingLayout())
+	})
+	mux.HandleFunc("GET /node/{key}", func(w http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("key")
+		node, err := ch.GetNode(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(nodeResponse{Key: key, Node: node})
+	})
+	return mux
+}
+
+// ringSnapshot is the persisted form of a ring. VNode hashes are deliberately
+// left out: they are recomputed from the node names on load. Salts are kept,
+// since how a hash collision was resolved depends on the order the nodes were
+// added in, which the snapshot does not otherwise record.
+type ringSnapshot[V any] struct {
+	VNodes  int                     `json:"vnodes"`
+	Weights map[string]int          `json:"weights,omitempty"` // Only nodes whose weight is not 1
+	Salts   map[string]map[int]int  `json:"salts,omitempty"`   // Only VNodes that escaped a collision
+	Nodes   map[string]map[string]V `json:"nodes"`
+}
+
+// Save writes the node list, VNode count, node weights, VNode salts and stored
+// data to w as JSON.
+func (ch *ConsistentHashing[V]) Save(w io.Writer) error {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
+	snapshot := ringSnapshot[V]{VNodes: ch.vnodes, Weights: ch.weights, Salts: make(map[string]map[int]int), Nodes: make(map[string]map[string]V, len(ch.nodes))}
+	for nodeName, salts := range ch.salts {
+		if len(salts) > 0 {
+			snapshot.Salts[nodeName] = salts
+		}
+	}
+	for nodeName, store := range ch.nodes {
+		data := make(map[string]V, store.Len())
+		for _, key := range store.Keys() {
+			data[key], _ = store.Get(key)
+		}
+		snapshot.Nodes[nodeName] = data
+	}
+	return json.NewEncoder(w).Encode(snapshot)
+}
+
+// Load replaces the ring's state with a snapshot previously written by Save.
+// Every VNode is put back at the position it had when the snapshot was saved,
+// salt included, so the loaded ring routes every key like the saved one. The
+// ring must use the same hash function and VNode spread setting as the one
+// that was saved; a VNode landing on a taken position means it does not, and
+// is an error that leaves the ring unchanged.
+func (ch *ConsistentHashing[V]) Load(r io.Reader) error {
+	var snapshot ringSnapshot[V]
+	if err := json.NewDecoder(r).Decode(&snapshot); err != nil {
+		return fmt.Errorf("decoding ring snapshot: %w", err)
+	}
+	if snapshot.VNodes <= 0 {
+		return fmt.Errorf("invalid VNode count %d in snapshot", snapshot.VNodes)
+	}
+	weights := make(map[string]int, len(snapshot.Weights))
+	for nodeName, weight := range snapshot.Weights {
+		if _, exists := snapshot.Nodes[nodeName]; !exists || weight < 1 {
+			return fmt.Errorf("invalid weight %d for node '%s' in snapshot", weight, nodeName)
+		}
+		if weight > 1 {
+			weights[nodeName] = weight
+		}
+	}
+	salts := make(map[string]map[int]int, len(snapshot.Salts))
+	for nodeName, nodeSalts := range snapshot.Salts {
+		if _, exists := snapshot.Nodes[nodeName]; !exists {
+			return fmt.Errorf("salts for unknown node '%s' in snapshot", nodeName)
+		}
+		count := snapshot.VNodes * max(weights[nodeName], 1)
+		for i, salt := range nodeSalts {
+			if i < 0 || i >= count || salt < 1 {
+				return fmt.Errorf("invalid salt %d for VNode %d of node '%s' in snapshot", salt, i, nodeName)
+			}
+		}
+		salts[nodeName] = nodeSalts
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	ring := make([]uint64, 0, len(snapshot.Nodes)*snapshot.VNodes)
+	hashMap := make(map[uint64]string)
+	for _, nodeName := range sortedKeys(snapshot.Nodes) {
+		count := snapshot.VNodes * max(weights[nodeName], 1)
+		for i := 0; i < count; i++ {
+			hash := ch.vnodeHash(nodeName, i, salts[nodeName][i])
+			if owner, taken := hashMap[hash]; taken {
+				return fmt.Errorf("VNode %d of node '%s' collides with node '%s': the snapshot was saved with another hash function or VNode spread setting", i, nodeName, owner)
+			}
+			hashMap[hash] = nodeName
+			ring = append(ring, hash)
+		}
+	}
+
+	ch.vnodes = snapshot.VNodes
+	ch.ring = ring
+	ch.hashMap = hashMap
+	ch.salts = salts
+	ch.weights = weights
+	ch.nodes = make(map[string]Store[V], len(snapshot.Nodes))
+	for nodeName, data := range snapshot.Nodes {
+		store := ch.newStore(nodeName)
+		for key, value := range data {
+			store.Set(key, value)
 		}
+		ch.nodes[nodeName] = store
 	}
+	ch.sortRing()
 	return nil
 }
 
-func (ch *ConsistentHashing) printNodeStats() {
+func (ch *ConsistentHashing[V]) printNodeStats() {
+	stats := ch.Stats()
+
 	fmt.Println("\n--- Current Node Status ---")
 	total := 0
-	nodeNames := make([]string, 0, len(ch.nodes))
-	for name := range ch.nodes {
+	nodeNames := make([]string, 0, len(stats))
+	for name := range stats {
 		nodeNames = append(nodeNames, name)
 	}
 	sort.Strings(nodeNames)
 
 	for _, name := range nodeNames {
-		count := len(ch.nodes[name])
+		count := stats[name]
 		fmt.Printf("Node %-8s: %d records\n", name, count)
 		total += count
 	}
 	fmt.Printf("----------------------------\n")
 	fmt.Printf("Total Records: %d\n", total)
+	fmt.Printf("Load Imbalance: %.3f\n", ch.LoadImbalance())
+	fmt.Printf("VNode Gap CV: %.3f\n", ch.GapCV())
+	chiSquare, df := ch.DistributionUniformity()
+	fmt.Printf("Chi-Squared: %.1f (df=%d)\n", chiSquare, df)
 	fmt.Printf("----------------------------\n")
 }
 
-func verifyKeys(ch *ConsistentHashing, users map[string]string) {
+// sortedKeys returns the keys of m in ascending order, so output built from a
+// map is the same on every run.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// verifyKeys checks that every key in keys is stored on the node GetNode
+// returns for it. Keys are checked, and errors reported, in the given order.
+func verifyKeys[V any](ch *ConsistentHashing[V], keys []string) {
 	fmt.Println("\n🔎 Verifying the location of all keys...")
 	
 	correct := 0
 	incorrect := 0
 	
-	actualLocations := make(map[string]string)
-	for nodeName, data := range ch.nodes {
-		for key := range data {
-			actualLocations[key] = nodeName
-		}
-	}
+	actualLocations := ch.Locations()
 
-	for key := range users {
+	for _, key := range keys {
 		expectedNode, _ := ch.GetNode(key)
 		actualNode, found := actualLocations[key]
 
@@ -210,46 +1218,163 @@ func verifyKeys(ch *ConsistentHashing, users map[string]string) {
 	fmt.Printf("----------------------------\n")
 }
 
+// demoConfig holds the parameters of the simulation, set from the command line.
+type demoConfig struct {
+	users     int
+	nodes     int
+	vnodes    int
+	op        string // "all", "add", "remove" or "verify"
+	node      string // Node added or removed by the "add" and "remove" operations
+	seed      int64  // Non-zero: random user keys from this seed, processed in sorted order
+	serve     string // If set, the address to serve the ring over HTTP on after the run
+	spread    bool   // Place VNodes with SetVNodeSpread
+	nodesFile string // If set, the initial nodes are read from this file with LoadNodes instead of -nodes
+}
+
+// parseFlags reads the simulation parameters from args. With no flags it
+// returns the defaults: 1,000,000 users on 10 nodes with 1000 VNodes each,
+// removing node-4 and then adding node-10.
+func parseFlags(args []string) (demoConfig, error) {
+	var cfg demoConfig
+	fs := flag.NewFlagSet("consistent-hashing", flag.ContinueOnError)
+	fs.IntVar(&cfg.users, "users", 1000000, "number of user records")
+	fs.IntVar(&cfg.nodes, "nodes", 10, "number of initial nodes")
+	fs.StringVar(&cfg.nodesFile, "nodes-file", "", "read the initial nodes and their weights from this JSON file instead of -nodes")
+	fs.IntVar(&cfg.vnodes, "vnodes", 1000, "number of VNodes per node")
+	fs.StringVar(&cfg.op, "op", "all", "operation to run: all (remove node-4, then add node-10), add, remove or verify")
+	fs.StringVar(&cfg.node, "node", "", "node for -op add (default: the next node-N) or -op remove (default: node-0)")
+	fs.Int64Var(&cfg.seed, "seed", 0, "generate random user keys from this seed and process them in sorted order, so a run can be reproduced exactly (0: sequential keys)")
+	fs.BoolVar(&cfg.spread, "spread", false, "derive VNode keys through a mixing function for a more even spread with few VNodes")
+	fs.StringVar(&cfg.serve, "serve", "", "after the run, serve the ring over HTTP on this address (e.g. :8080)")
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if cfg.users < 0 || cfg.nodes < 1 || cfg.vnodes < 1 {
+		return cfg, fmt.Errorf("-users must be >= 0, -nodes and -vnodes >= 1")
+	}
+	switch cfg.op {
+	case "all", "verify":
+	case "add":
+		if cfg.node == "" {
+			cfg.node = "node-" + strconv.Itoa(cfg.nodes)
+		}
+	case "remove":
+		if cfg.node == "" {
+			cfg.node = "node-0"
+		}
+	default:
+		return cfg, fmt.Errorf("unknown -op %q: use all, add, remove or verify", cfg.op)
+	}
+	return cfg, nil
+}
+
 func main() {
-	const numUsers = 1000000
-	const initialNodes = 10
-	const numVNodes = 1000
-
-	fmt.Printf("📝 Creating %d user records...\n", numUsers)
-	users := make(map[string]string)
-	for i := 0; i < numUsers; i++ {
-		key := "user_" + strconv.Itoa(i)
-		users[key] = "data_for_" + key
+	cfg, err := parseFlags(os.Args[1:])
+	if err != nil {
+		if err != flag.ErrHelp {
+			fmt.Println(err)
+		}
+		os.Exit(2)
 	}
 
-	ch := NewConsistentHashing(numVNodes)
+	fmt.Printf("📝 Creating %d user records...\n", cfg.users)
+	users := generateUsers(cfg.users, cfg.seed)
 
-	fmt.Printf("⚙️  Adding %d initial nodes to the ring (with %d VNodes each)...\n", initialNodes, numVNodes)
-	for i := 0; i < initialNodes; i++ {
-		nodeName := "node-" + strconv.Itoa(i)
-		ch.nodes[nodeName] = make(map[string]string)
-		for j := 0; j < ch.vnodes; j++ {
-			vnodeKey := fmt.Sprintf("%s#%d", nodeName, j)
-			hash := hashKey(vnodeKey)
-			ch.ring = append(ch.ring, hash)
-			ch.hashMap[hash] = nodeName
+	// Map iteration order is random. In seeded mode keys are placed and
+	// verified in sorted order instead, so the same seed gives the same run.
+	keys := make([]string, 0, len(users))
+	if cfg.seed != 0 {
+		keys = sortedKeys(users)
+	} else {
+		for key := range users {
+			keys = append(keys, key)
 		}
 	}
-	sort.Slice(ch.ring, func(i, j int) bool { return ch.ring[i] < ch.ring[j] })
+
+	ch := NewConsistentHashing[string](cfg.vnodes)
+	ch.SetVNodeSpread(cfg.spread)
+
+	if cfg.nodesFile != "" {
+		fmt.Printf("⚙️  Adding the nodes listed in %s to the ring (with %d VNodes per unit of weight)...\n", cfg.nodesFile, cfg.vnodes)
+		if err := ch.LoadNodes(cfg.nodesFile); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else {
+		fmt.Printf("⚙️  Adding %d initial nodes to the ring (with %d VNodes each)...\n", cfg.nodes, cfg.vnodes)
+		initialNodeNames := make([]string, 0, cfg.nodes)
+		for i := 0; i < cfg.nodes; i++ {
+			initialNodeNames = append(initialNodeNames, "node-"+strconv.Itoa(i))
+		}
+		ch.AddNodes(initialNodeNames)
+	}
 	fmt.Println("Nodes added.")
 
 	fmt.Println("\n🗺️  Distributing initial records to nodes...")
-	for key, value := range users {
-		node, _ := ch.GetNode(key)
-		ch.nodes[node][key] = value
+	for _, key := range keys {
+		ch.Set(key, users[key])
 	}
 	ch.printNodeStats()
 
-	ch.RemoveNode("node-4")
-	ch.printNodeStats()
+	switch cfg.op {
+	case "all":
+		if _, _, err := ch.RemoveNode("node-4"); err != nil {
+			fmt.Println(err)
+		}
+		ch.printNodeStats()
 
-	ch.AddNode("node-10")
-	ch.printNodeStats()
+		if _, err := ch.AddNode("node-10"); err != nil {
+			fmt.Println(err)
+		}
+		ch.printNodeStats()
+	case "add":
+		if _, err := ch.AddNode(cfg.node); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		ch.printNodeStats()
+	case "remove":
+		_, orphaned, err := ch.RemoveNode(cfg.node)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if orphaned != nil {
+			// The ring is now empty, so there is nothing left to verify.
+			return
+		}
+		ch.printNodeStats()
+	}
+
+	verifyKeys(ch, keys)
 
-	verifyKeys(ch, users)
-}
\ No newline at end of file
+	if cfg.serve != "" {
+		fmt.Printf("\n🌐 Serving the ring on %s (GET /ring, GET /node/{key})...\n", cfg.serve)
+		if err := http.ListenAndServe(cfg.serve, newRingServer(ch)); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+}
+
+// generateUsers creates n user records. With a zero seed the keys are
+// user_0..user_{n-1}; otherwise they are random, drawn from a source seeded
+// with seed, so the same seed always yields the same keys.
+func generateUsers(n int, seed int64) map[string]string {
+	users := make(map[string]string, n)
+	if seed == 0 {
+		for i := 0; i < n; i++ {
+			key := "user_" + strconv.Itoa(i)
+			users[key] = "data_for_" + key
+		}
+		return users
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+	for len(users) < n {
+		key := "user_" + strconv.FormatUint(rng.Uint64(), 36)
+		users[key] = "data_for_" + key
+	}
+	return users
+}
@@ -0,0 +1,892 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// smallHash squeezes crc32 into 1000 positions, so VNodes collide often.
+func smallHash(data []byte) uint64 {
+	return crc32Hash(data) % 1000
+}
+
+// testKeys returns n distinct keys.
+func testKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("user_%d", i)
+	}
+	return keys
+}
+
+// fill stores every key on the ring, with the key as value.
+func fill(t *testing.T, ch *ConsistentHashing[string], keys []string) {
+	t.Helper()
+	for _, key := range keys {
+		if _, err := ch.Set(key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSaveLoadRoundTripKeepsRouting(t *testing.T) {
+	ch := NewConsistentHashing[string](20, smallHash)
+	// Nodes added out of name order, and one removed, so that collisions were
+	// not resolved the way adding the nodes in name order would.
+	ch.AddNodes([]string{"node-c", "node-a", "node-d"})
+	if _, _, err := ch.RemoveNode("node-a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ch.AddNode("node-b"); err != nil {
+		t.Fatal(err)
+	}
+	if len(ch.salts) == 0 {
+		t.Fatal("no VNode collided; the test needs at least one salt")
+	}
+	keys := testKeys(1000)
+	fill(t, ch, keys)
+
+	var saved bytes.Buffer
+	if err := ch.Save(&saved); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewConsistentHashing[string](1, smallHash)
+	if err := loaded.Load(&saved); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range keys {
+		want, _ := ch.GetNode(key)
+		got, _ := loaded.GetNode(key)
+		if got != want {
+			t.Fatalf("key %s routes to %s after Load, want %s", key, got, want)
+		}
+	}
+	if misplaced := loaded.misplacedKeys(keys); len(misplaced) > 0 {
+		t.Fatalf("%d keys are not stored where the loaded ring routes them, e.g. %s", len(misplaced), misplaced[0])
+	}
+
+	// The restored salts must let the nodes be removed cleanly.
+	for _, node := range []string{"node-b", "node-c"} {
+		if _, _, err := loaded.RemoveNode(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(loaded.ring) != 20 || len(loaded.hashMap) != 20 {
+		t.Errorf("%d VNodes and %d hashes left for one node, want 20", len(loaded.ring), len(loaded.hashMap))
+	}
+}
+
+func TestLoadRejectsSnapshotFromAnotherHash(t *testing.T) {
+	ch := NewConsistentHashing[string](20, smallHash)
+	ch.AddNodes([]string{"node-a", "node-b", "node-c"})
+	var saved bytes.Buffer
+	if err := ch.Save(&saved); err != nil {
+		t.Fatal(err)
+	}
+
+	// With every VNode on the same position, the second one cannot be placed.
+	loaded := NewConsistentHashing[string](1, func([]byte) uint64 { return 7 })
+	loaded.AddNode("node-x")
+	if err := loaded.Load(&saved); err == nil {
+		t.Fatal("Load accepted VNodes that collide")
+	}
+	if _, exists := loaded.nodes["node-x"]; !exists || len(loaded.ring) != 1 {
+		t.Error("a failed Load changed the ring")
+	}
+}
+
+func TestBoundedLoadsOnlySteerNewKeys(t *testing.T) {
+	ch := NewConsistentHashing[string](5)
+	ch.AddNodes([]string{"node-a", "node-b", "node-c", "node-d"})
+	ch.SetLoadFactor(1)
+
+	keys := testKeys(4000)
+	fill(t, ch, keys)
+	// Without the bound, 5 VNodes per node split these keys very unevenly.
+	// Every node is now full, so the bound would send every key to node-e.
+	bound := 1000
+	for node, count := range ch.Stats() {
+		if count > bound {
+			t.Errorf("%s holds %d keys, over the bound of %d", node, count, bound)
+		}
+	}
+
+	report, err := ch.AddNode("node-e")
+	if err != nil {
+		t.Fatalf("AddNode under bounded loads: %v", err)
+	}
+	// Without the bound, GetNode returns the node whose arc a key falls in.
+	ch.SetLoadFactor(0)
+	moved := 0
+	for _, key := range ch.nodes["node-e"].Keys() {
+		if owner, _ := ch.GetNode(key); owner != "node-e" {
+			t.Fatalf("key %s was moved to node-e, but its arc is on %s", key, owner)
+		}
+		moved++
+	}
+	if moved != report.KeysMoved {
+		t.Errorf("node-e holds %d keys, but the report says %d moved", moved, report.KeysMoved)
+	}
+	if moved == 0 {
+		t.Error("no key moved to node-e")
+	}
+}
+
+// tableHash returns a hash function placing each listed string at the given
+// position and everything else at 0.
+func tableHash(positions map[string]uint64) func([]byte) uint64 {
+	return func(data []byte) uint64 { return positions[string(data)] }
+}
+
+func TestGetNodesWrapsAroundTheRing(t *testing.T) {
+	ch := NewConsistentHashing[string](1, tableHash(map[string]uint64{
+		"node-a#0": 100,
+		"node-b#0": 200,
+		"node-c#0": 300,
+		"key":      250,
+	}))
+	ch.AddNodes([]string{"node-a", "node-b", "node-c"})
+
+	got, err := ch.GetNodes("key", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != "[node-c node-a]" {
+		t.Errorf("GetNodes(key, 2) = %v, want [node-c node-a]", got)
+	}
+}
+
+func TestGetNodesReplicaCount(t *testing.T) {
+	ch := NewConsistentHashing[string](50)
+	ch.AddNodes([]string{"node-a", "node-b", "node-c"})
+
+	for _, key := range testKeys(100) {
+		got, err := ch.GetNodes(key, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		distinct := map[string]bool{}
+		for _, node := range got {
+			distinct[node] = true
+		}
+		if len(got) != 3 || len(distinct) != 3 {
+			t.Fatalf("GetNodes(%s, 3) = %v, want all 3 nodes once", key, got)
+		}
+		if owner, _ := ch.GetNode(key); got[0] != owner {
+			t.Fatalf("GetNodes(%s, 3) starts with %s, want the owner %s", key, got[0], owner)
+		}
+	}
+
+	for _, replicas := range []int{-1, 0, 4} {
+		if got, err := ch.GetNodes("key", replicas); err == nil {
+			t.Errorf("GetNodes(key, %d) = %v, want an error", replicas, got)
+		}
+	}
+}
+
+func TestConcurrentAccessDuringTopologyChanges(t *testing.T) {
+	ch := NewConsistentHashing[string](20)
+	ch.AddNodes([]string{"node-0", "node-1", "node-2"})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 100; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				key := fmt.Sprintf("user_%d_%d", g, i)
+				if _, err := ch.Set(key, key); err != nil {
+					t.Error(err)
+					return
+				}
+				ch.GetNode(key)
+				if i%10 == 0 {
+					ch.Locations()
+					ch.Stats()
+				}
+			}
+		}(g)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 3; i < 6; i++ {
+			ch.AddNode(fmt.Sprintf("node-%d", i))
+			ch.RemoveNode(fmt.Sprintf("node-%d", i-3))
+		}
+	}()
+	wg.Wait()
+
+	locations := ch.Locations()
+	if len(locations) != 100*20 {
+		t.Fatalf("%d keys stored, want %d", len(locations), 100*20)
+	}
+	keys := make([]string, 0, len(locations))
+	for key := range locations {
+		keys = append(keys, key)
+	}
+	if misplaced := ch.misplacedKeys(keys); len(misplaced) > 0 {
+		t.Errorf("%d keys are misplaced, e.g. %s", len(misplaced), misplaced[0])
+	}
+}
+
+// newTestRing returns a ring with the given VNodes per node and nodes, holding
+// n keys.
+func newTestRing(t *testing.T, vnodes, n int, nodes ...string) *ConsistentHashing[string] {
+	t.Helper()
+	ch := NewConsistentHashing[string](vnodes)
+	ch.AddNodes(nodes)
+	fill(t, ch, testKeys(n))
+	return ch
+}
+
+func TestApplyPlanMovesNoMoreThanSequentialChanges(t *testing.T) {
+	nodes := []string{"node-0", "node-1", "node-2", "node-3"}
+	planned := newTestRing(t, 100, 10000, nodes...)
+	sequential := newTestRing(t, 100, 10000, nodes...)
+
+	migrations := planned.ApplyPlan([]string{"node-4", "node-5"}, []string{"node-1"})
+
+	moved := 0
+	for _, node := range []string{"node-4", "node-5"} {
+		report, err := sequential.AddNode(node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		moved += report.KeysMoved
+	}
+	report, _, err := sequential.RemoveNode("node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved += report.KeysMoved
+
+	if len(migrations) > moved {
+		t.Errorf("ApplyPlan moved %d keys, sequential changes %d", len(migrations), moved)
+	}
+	if fmt.Sprint(planned.Locations()) != fmt.Sprint(sequential.Locations()) {
+		t.Error("ApplyPlan and sequential changes left the keys in different places")
+	}
+}
+
+func TestStructValuesSurviveRedistribution(t *testing.T) {
+	type profile struct {
+		Name  string
+		Age   int
+		Roles []string
+	}
+	ch := NewConsistentHashing[profile](50)
+	ch.AddNodes([]string{"node-0", "node-1", "node-2"})
+	want := make(map[string]profile)
+	for i, key := range testKeys(1000) {
+		want[key] = profile{Name: "name-" + key, Age: i, Roles: []string{"user", fmt.Sprint(i % 3)}}
+		if _, err := ch.Set(key, want[key]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := ch.AddNode("node-3"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ch.RemoveNode("node-0"); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, node := range ch.Locations() {
+		got, _ := ch.nodes[node].Get(key)
+		if fmt.Sprint(got) != fmt.Sprint(want[key]) {
+			t.Fatalf("%s is %+v after redistribution, want %+v", key, got, want[key])
+		}
+	}
+	if n := len(ch.Locations()); n != len(want) {
+		t.Errorf("%d keys after redistribution, want %d", n, len(want))
+	}
+}
+
+func TestLoadImbalanceOfSkewedRing(t *testing.T) {
+	positions := map[string]uint64{"node-a#0": 100, "node-b#0": 200}
+	// Keys at 50 and past 200 belong to node-a, keys at 150 to node-b.
+	for i, key := range testKeys(10) {
+		switch {
+		case i < 6:
+			positions[key] = 50
+		case i < 9:
+			positions[key] = 250
+		default:
+			positions[key] = 150
+		}
+	}
+	ch := NewConsistentHashing[string](1, tableHash(positions))
+	ch.AddNodes([]string{"node-a", "node-b"})
+	fill(t, ch, testKeys(10))
+
+	// node-a holds 9 keys, node-b 1: the max is 9 against a mean of 5.
+	if stats := ch.Stats(); stats["node-a"] != 9 || stats["node-b"] != 1 {
+		t.Fatalf("stats %v, want node-a 9 and node-b 1", stats)
+	}
+	if got := ch.LoadImbalance(); got != 1.8 {
+		t.Errorf("LoadImbalance() = %v, want 9/5 = 1.8", got)
+	}
+}
+
+func TestPlacementWithInjectedHash(t *testing.T) {
+	ch := NewConsistentHashing[string](2, tableHash(map[string]uint64{
+		"node-a#0": 100, "node-b#0": 200, "node-a#1": 300, "node-b#1": 400,
+		"k50": 50, "k100": 100, "k150": 150, "k250": 250, "k350": 350, "k450": 450,
+	}))
+	ch.AddNodes([]string{"node-a", "node-b"})
+
+	want := map[string]string{
+		"k50":  "node-a", // Before the first VNode
+		"k100": "node-a", // On a VNode
+		"k150": "node-b",
+		"k250": "node-a",
+		"k350": "node-b",
+		"k450": "node-a", // Past the last VNode, wraps around
+	}
+	for key, node := range want {
+		if got, _ := ch.GetNode(key); got != node {
+			t.Errorf("GetNode(%s) = %s, want %s", key, got, node)
+		}
+	}
+}
+
+func TestRingLayoutIsSortedWithEveryVNode(t *testing.T) {
+	ch := NewConsistentHashing[string](100)
+	nodes := []string{"node-0", "node-1", "node-2", "node-3"}
+	ch.AddNodes(nodes)
+
+	layout := ch.RingLayout()
+	if len(layout) != len(nodes)*100 {
+		t.Fatalf("%d VNodes listed, want %d", len(layout), len(nodes)*100)
+	}
+	perNode := make(map[string]int)
+	for i, vnode := range layout {
+		if i > 0 && layout[i-1].Hash >= vnode.Hash {
+			t.Fatalf("VNode %d at %d follows %d: not sorted", i, vnode.Hash, layout[i-1].Hash)
+		}
+		perNode[vnode.Node]++
+	}
+	for _, node := range nodes {
+		if perNode[node] != 100 {
+			t.Errorf("%s has %d VNodes listed, want 100", node, perNode[node])
+		}
+	}
+}
+
+func TestGetNodesForKeysMatchesGetNode(t *testing.T) {
+	ch := NewConsistentHashing[string](50)
+	ch.AddNodes([]string{"node-0", "node-1", "node-2"})
+	keys := testKeys(5000)
+
+	grouped := ch.GetNodesForKeys(keys)
+	routed := 0
+	for node, nodeKeys := range grouped {
+		for _, key := range nodeKeys {
+			if want, _ := ch.GetNode(key); node != want {
+				t.Fatalf("batch lookup put %s on %s, GetNode on %s", key, node, want)
+			}
+			routed++
+		}
+	}
+	if routed != len(keys) {
+		t.Errorf("batch lookup routed %d keys, want %d", routed, len(keys))
+	}
+}
+
+// benchmarkAddNodes adds 100 nodes of 100 VNodes one at a time with add.
+func benchmarkAddNodes(b *testing.B, add func(ch *ConsistentHashing[string], node string)) {
+	for i := 0; i < b.N; i++ {
+		ch := NewConsistentHashing[string](100)
+		for n := 0; n < 100; n++ {
+			node := fmt.Sprintf("node-%d", n)
+			ch.nodes[node] = ch.newStore(node)
+			add(ch, node)
+		}
+	}
+}
+
+func BenchmarkAddNodesMerge(b *testing.B) {
+	benchmarkAddNodes(b, func(ch *ConsistentHashing[string], node string) {
+		ch.addVNodes(node)
+	})
+}
+
+func BenchmarkAddNodesFullSort(b *testing.B) {
+	benchmarkAddNodes(b, func(ch *ConsistentHashing[string], node string) {
+		ch.appendVNodes(node)
+		ch.sortRing()
+	})
+}
+
+func TestVNodeCollisionKeepsBothNodes(t *testing.T) {
+	ch := NewConsistentHashing[string](1, tableHash(map[string]uint64{
+		"node-a#0":   100,
+		"node-b#0":   100, // Collides with node-a
+		"node-b#0#1": 150, // node-b's VNode re-hashed with salt 1
+	}))
+	ch.AddNodes([]string{"node-a", "node-b"})
+
+	layout := ch.RingLayout()
+	if fmt.Sprint(layout) != "[{100 node-a} {150 node-b}]" {
+		t.Fatalf("ring %v, want node-a at 100 and node-b at 150", layout)
+	}
+	if _, _, err := ch.RemoveNode("node-a"); err != nil {
+		t.Fatal(err)
+	}
+	if layout := ch.RingLayout(); fmt.Sprint(layout) != "[{150 node-b}]" {
+		t.Errorf("ring %v after removing node-a, want node-b's VNode left", layout)
+	}
+}
+
+func TestVNodeCollisionsKeepVNodeCounts(t *testing.T) {
+	ch := NewConsistentHashing[string](50, smallHash)
+	nodes := []string{"node-0", "node-1", "node-2", "node-3"}
+	ch.AddNodes(nodes)
+	if len(ch.salts) == 0 {
+		t.Fatal("no VNode collided; the test needs at least one collision")
+	}
+
+	perNode := make(map[string]int)
+	for _, vnode := range ch.RingLayout() {
+		perNode[vnode.Node]++
+	}
+	for _, node := range nodes {
+		if perNode[node] != 50 {
+			t.Errorf("%s has %d VNodes, want 50", node, perNode[node])
+		}
+	}
+}
+
+func TestPlanRemoveNodeMatchesRemoveNode(t *testing.T) {
+	ch := newTestRing(t, 100, 10000, "node-0", "node-1", "node-2", "node-3")
+	before := ch.Stats()
+
+	plan, err := ch.PlanRemoveNode("node-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(ch.Stats()) != fmt.Sprint(before) {
+		t.Fatal("PlanRemoveNode moved data")
+	}
+	planned := 0
+	for _, count := range plan {
+		planned += count
+	}
+
+	report, _, err := ch.RemoveNode("node-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if planned != report.KeysMoved || planned != before["node-2"] {
+		t.Errorf("plan moves %d keys, RemoveNode moved %d of the %d on node-2", planned, report.KeysMoved, before["node-2"])
+	}
+	after := ch.Stats()
+	for node, count := range plan {
+		if gained := after[node] - before[node]; gained != count {
+			t.Errorf("plan sends %d keys to %s, it gained %d", count, node, gained)
+		}
+	}
+	if _, err := ch.PlanRemoveNode("node-2"); err == nil {
+		t.Error("planning the removal of a removed node succeeded")
+	}
+}
+
+// seededStats places the users generated from seed on a fresh ring, in sorted
+// key order like the seeded demo, and returns the encoded Stats().
+func seededStats(t *testing.T, seed int64) []byte {
+	t.Helper()
+	users := generateUsers(5000, seed)
+	ch := NewConsistentHashing[string](50)
+	ch.AddNodes([]string{"node-0", "node-1", "node-2", "node-3"})
+	for _, key := range sortedKeys(users) {
+		if _, err := ch.Set(key, users[key]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats, err := json.Marshal(ch.Stats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stats
+}
+
+func TestSameSeedGivesIdenticalStats(t *testing.T) {
+	first, second := seededStats(t, 42), seededStats(t, 42)
+	if !bytes.Equal(first, second) {
+		t.Errorf("two runs with seed 42 differ:\n%s\n%s", first, second)
+	}
+	if other := seededStats(t, 43); bytes.Equal(first, other) {
+		t.Errorf("seeds 42 and 43 give the same stats %s; the seed is not used", first)
+	}
+}
+
+func TestRemovingEveryNodeReportsOrphanedKeys(t *testing.T) {
+	nodes := []string{"node-0", "node-1", "node-2"}
+	ch := newTestRing(t, 20, 300, nodes...)
+
+	for i, node := range nodes {
+		_, orphaned, err := ch.RemoveNode(node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if last := i == len(nodes)-1; !last && orphaned != nil {
+			t.Fatalf("removing %s with nodes left orphaned %d keys", node, len(orphaned))
+		} else if last && len(orphaned) != 300 {
+			t.Fatalf("removing the last node orphaned %d keys, want all 300", len(orphaned))
+		}
+	}
+	for _, key := range testKeys(300) {
+		if _, err := ch.GetNode(key); err == nil {
+			t.Fatal("GetNode succeeded on an empty ring")
+		}
+	}
+	if _, _, err := ch.RemoveNode("node-0"); err == nil {
+		t.Error("removing a node from an empty ring succeeded")
+	}
+}
+
+// recordingStore is a Store that logs every Set and Delete as
+// "set node key" or "delete node key".
+type recordingStore struct {
+	Store[string]
+	node string
+	log  *[]string
+}
+
+func (s recordingStore) Set(key, value string) {
+	*s.log = append(*s.log, "set "+s.node+" "+key)
+	s.Store.Set(key, value)
+}
+
+func (s recordingStore) Delete(key string) {
+	*s.log = append(*s.log, "delete "+s.node+" "+key)
+	s.Store.Delete(key)
+}
+
+func TestRebalanceMovesDataThroughStores(t *testing.T) {
+	var log []string
+	ch := NewConsistentHashing[string](50)
+	ch.SetStoreFactory(func(node string) Store[string] {
+		return recordingStore{Store: newMemoryStore[string](node), node: node, log: &log}
+	})
+	ch.AddNodes([]string{"node-0", "node-1"})
+	fill(t, ch, testKeys(1000))
+	before := ch.Locations()
+	log = nil
+
+	report, err := ch.AddNode("node-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for key, node := range ch.Locations() {
+		if node != before[key] {
+			want = append(want, "set "+node+" "+key, "delete "+before[key]+" "+key)
+		}
+	}
+	if len(want) != 2*report.KeysMoved || report.KeysMoved == 0 {
+		t.Fatalf("%d keys changed node, the report says %d moved", len(want)/2, report.KeysMoved)
+	}
+	sort.Strings(log)
+	sort.Strings(want)
+	if fmt.Sprint(log) != fmt.Sprint(want) {
+		t.Errorf("AddNode made %d store calls, want a set on node-2 and a delete on the source for each of the %d moved keys", len(log), report.KeysMoved)
+	}
+
+	onNode2 := ch.nodes["node-2"].Keys()
+	log = nil
+	if _, _, err := ch.RemoveNode("node-2"); err != nil {
+		t.Fatal(err)
+	}
+	want = nil
+	for _, key := range onNode2 {
+		want = append(want, "set "+before[key]+" "+key, "delete node-2 "+key)
+	}
+	sort.Strings(log)
+	sort.Strings(want)
+	if fmt.Sprint(log) != fmt.Sprint(want) {
+		t.Errorf("RemoveNode made %d store calls, want a set on the original node and a delete on node-2 for each of its %d keys", len(log), len(onNode2))
+	}
+}
+
+// lossyStore is a Store that silently drops every write.
+type lossyStore struct {
+	Store[string]
+}
+
+func (lossyStore) Set(key, value string) {}
+
+func TestAddNodeReportsAFaultyMove(t *testing.T) {
+	ch := NewConsistentHashing[string](50)
+	ch.SetStoreFactory(func(node string) Store[string] {
+		if node == "node-2" {
+			return lossyStore{newMemoryStore[string](node)}
+		}
+		return newMemoryStore[string](node)
+	})
+	ch.AddNodes([]string{"node-0", "node-1"})
+	fill(t, ch, testKeys(1000))
+
+	report, err := ch.AddNode("node-2")
+	if err == nil {
+		t.Fatal("AddNode lost the moved keys without an error")
+	}
+	if report.KeysMoved == 0 {
+		t.Error("the report of the faulty move says no key moved")
+	}
+}
+
+// getJSON sends a GET for path to handler and decodes the JSON response into v.
+func getJSON(t *testing.T, handler http.Handler, path string, v any) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: got %d %s", path, rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("GET %s: Content-Type %q, want application/json", path, ct)
+	}
+	if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+}
+
+func TestRingServer(t *testing.T) {
+	ch := NewConsistentHashing[string](1, tableHash(map[string]uint64{
+		"node-a#0": 100, "node-b#0": 200, "user_1": 150,
+	}))
+	ch.AddNodes([]string{"node-a", "node-b"})
+	handler := newRingServer(ch)
+
+	var ring []map[string]any
+	getJSON(t, handler, "/ring", &ring)
+	want := []map[string]any{{"hash": 100.0, "node": "node-a"}, {"hash": 200.0, "node": "node-b"}}
+	if fmt.Sprint(ring) != fmt.Sprint(want) {
+		t.Errorf("GET /ring = %v, want %v", ring, want)
+	}
+
+	var node map[string]any
+	getJSON(t, handler, "/node/user_1", &node)
+	if want := map[string]any{"key": "user_1", "node": "node-b"}; fmt.Sprint(node) != fmt.Sprint(want) {
+		t.Errorf("GET /node/user_1 = %v, want %v", node, want)
+	}
+
+	empty := newRingServer(NewConsistentHashing[string](1))
+	rec := httptest.NewRecorder()
+	empty.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/node/user_1", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /node/user_1 on an empty ring: got %d, want 503", rec.Code)
+	}
+}
+
+func TestSetVNodesLowersLoadImbalance(t *testing.T) {
+	ch := newTestRing(t, 5, 20000, "node-0", "node-1", "node-2", "node-3")
+	keys := testKeys(20000)
+	before := ch.LoadImbalance()
+
+	moved, err := ch.SetVNodes(500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after := ch.LoadImbalance(); after >= before {
+		t.Errorf("LoadImbalance() went from %.3f to %.3f when raising the VNodes from 5 to 500", before, after)
+	}
+	if moved == 0 {
+		t.Error("no key moved when raising the VNodes")
+	}
+	if misplaced := ch.misplacedKeys(keys); len(misplaced) > 0 {
+		t.Fatalf("%d keys are misplaced after SetVNodes, e.g. %s", len(misplaced), misplaced[0])
+	}
+	if n := len(ch.RingLayout()); n != 4*500 {
+		t.Errorf("%d VNodes on the ring, want %d", n, 4*500)
+	}
+
+	if _, err := ch.SetVNodes(0); err == nil {
+		t.Error("SetVNodes(0) succeeded")
+	}
+}
+
+func TestVNodeSpreadLowersGapCV(t *testing.T) {
+	nodes := make([]string, 10)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("node-%d", i)
+	}
+	gapCV := func(spread bool) float64 {
+		ch := NewConsistentHashing[string](10)
+		ch.SetVNodeSpread(spread)
+		ch.AddNodes(nodes)
+		return ch.GapCV()
+	}
+
+	plain, spread := gapCV(false), gapCV(true)
+	t.Logf("gap CV: %.3f plain, %.3f spread", plain, spread)
+	if spread >= plain {
+		t.Errorf("gap CV is %.3f with spread placement, %.3f without; want lower", spread, plain)
+	}
+}
+
+func TestAddingEleventhNodeMovesAboutOneEleventh(t *testing.T) {
+	if testing.Short() {
+		t.Skip("places 100k keys on a ring of 10,000 VNodes")
+	}
+	nodes := make([]string, 10)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("node-%d", i)
+	}
+	// Without spread, crc32 clumps node-10's VNode keys and it takes about 13%
+	// of the keys instead of 9%.
+	ch := NewConsistentHashing[string](1000)
+	ch.SetVNodeSpread(true)
+	ch.AddNodes(nodes)
+	fill(t, ch, testKeys(100000))
+
+	report, err := ch.AddNode("node-10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("moved %d of %d keys (%.4f, expected %.4f)", report.KeysMoved, report.TotalKeys, report.FractionMoved, report.ExpectedFraction)
+	if report.TotalKeys != 100000 || report.ExpectedFraction != 1.0/11 {
+		t.Fatalf("report counts %d keys with an expected fraction of %v, want 100000 and 1/11", report.TotalKeys, report.ExpectedFraction)
+	}
+	// With 1000 VNodes, the new node's share stays within about 10% of 1/11.
+	if diff := math.Abs(report.FractionMoved - report.ExpectedFraction); diff > 0.1*report.ExpectedFraction {
+		t.Errorf("moved %.4f of the keys, want within 10%% of 1/11 = %.4f", report.FractionMoved, report.ExpectedFraction)
+	}
+}
+
+func TestDistributionUniformity(t *testing.T) {
+	nodes := make([]string, 10)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("node-%d", i)
+	}
+	chiSquare := func(vnodes int, spread bool) (float64, int) {
+		ch := NewConsistentHashing[string](vnodes)
+		ch.SetVNodeSpread(spread)
+		ch.AddNodes(nodes)
+		fill(t, ch, testKeys(10000))
+		return ch.DistributionUniformity()
+	}
+
+	// The critical value of the chi-squared distribution with 9 degrees of
+	// freedom at the 5% level.
+	const critical = 16.92
+	skewed, df := chiSquare(1, false)
+	if df != 9 {
+		t.Fatalf("df = %d for 10 nodes, want 9", df)
+	}
+	if skewed < 100*critical {
+		t.Errorf("a ring with 1 VNode per node has a statistic of %.1f, want far above %.2f", skewed, critical)
+	}
+	if balanced, _ := chiSquare(1000, true); balanced > critical {
+		t.Errorf("a ring with 1000 spread VNodes per node has a statistic of %.1f, want below %.2f", balanced, critical)
+	}
+
+	if chi, df := NewConsistentHashing[string](10).DistributionUniformity(); chi != 0 || df != 0 {
+		t.Errorf("an empty ring returns (%v, %d), want (0, 0)", chi, df)
+	}
+}
+
+// writeFile writes content to a file in a temporary directory and returns its path.
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "nodes.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadNodesSetsNodesAndWeights(t *testing.T) {
+	ch := NewConsistentHashing[string](10)
+	path := writeFile(t, `[{"name": "node-a"}, {"name": "node-b", "weight": 3}, {"name": "node-c", "weight": 1}]`)
+	if err := ch.LoadNodes(path); err != nil {
+		t.Fatal(err)
+	}
+
+	perNode := make(map[string]int)
+	for _, vnode := range ch.RingLayout() {
+		perNode[vnode.Node]++
+	}
+	if want := map[string]int{"node-a": 10, "node-b": 30, "node-c": 10}; fmt.Sprint(perNode) != fmt.Sprint(want) {
+		t.Errorf("VNodes per node %v, want %v", perNode, want)
+	}
+
+	for _, list := range []string{
+		`[{"name": "node-d"}, {"name": "node-d", "weight": 2}]`,
+		`[{"name": "node-d"}, {"name": "node-a"}]`,
+		`[{"name": "node-d"}, {"weight": 2}]`,
+		`[{"name": "node-d", "weight": -1}]`,
+		`[{"name": "node-d", "capacity": 2}]`,
+	} {
+		if err := ch.LoadNodes(writeFile(t, list)); err == nil {
+			t.Errorf("LoadNodes accepted %s", list)
+		}
+	}
+	if n := len(ch.Stats()); n != 3 {
+		t.Errorf("%d nodes after the rejected lists, want 3", n)
+	}
+}
+
+func TestLoadNodesIsAtomicWithConcurrentAddNode(t *testing.T) {
+	path := writeFile(t, `[{"name": "node-a"}, {"name": "node-b"}]`)
+	for i := 0; i < 50; i++ {
+		ch := NewConsistentHashing[string](10)
+		added := make(chan struct{})
+		go func() {
+			defer close(added)
+			ch.AddNode("node-b")
+		}()
+		err := ch.LoadNodes(path)
+		<-added
+
+		// Either the list went in first and AddNode was a no-op, or node-b
+		// was already there and none of the list was added.
+		want := map[string]int{"node-a": 0, "node-b": 0}
+		if err != nil {
+			want = map[string]int{"node-b": 0}
+		}
+		if got := ch.Stats(); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("nodes %v after LoadNodes returned %v, want %v", got, err, want)
+		}
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	cfg, err := parseFlags([]string{"-users", "500", "-nodes", "4", "-vnodes", "50", "-op", "remove", "-node", "node-2", "-seed", "7", "-spread", "-serve", ":8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := demoConfig{users: 500, nodes: 4, vnodes: 50, op: "remove", node: "node-2", seed: 7, serve: ":8080", spread: true}
+	if cfg != want {
+		t.Errorf("parseFlags = %+v, want %+v", cfg, want)
+	}
+
+	defaults, err := parseFlags(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (demoConfig{users: 1000000, nodes: 10, vnodes: 1000, op: "all"}); defaults != want {
+		t.Errorf("parseFlags without flags = %+v, want %+v", defaults, want)
+	}
+
+	if cfg, _ := parseFlags([]string{"-nodes", "4", "-op", "add"}); cfg.node != "node-4" {
+		t.Errorf("-op add defaults to adding %q, want node-4", cfg.node)
+	}
+	for _, args := range [][]string{{"-op", "resize"}, {"-vnodes", "0"}, {"-users", "-1"}} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("parseFlags(%q) succeeded", args)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// defaultConnectAttempts is used when SHARD_CONNECT_ATTEMPTS is not set.
+	defaultConnectAttempts = 5
+	// connectTimeout bounds a single connect+ping attempt.
+	connectTimeout = 10 * time.Second
+	// retryMaxDelay caps the exponential backoff.
+	retryMaxDelay = 10 * time.Second
+)
+
+// retryBaseDelay is the wait after the first failed attempt; it doubles on
+// every retry. It is a variable so tests can shorten it.
+var retryBaseDelay = 500 * time.Millisecond
+
+// ShardConnector opens and verifies the connection to a single shard.
+// The MongoDB implementation is mongoConnector; tests can plug in a fake.
+type ShardConnector interface {
+	Connect(ctx context.Context, uri string) (ShardClient, error)
+}
+
+// mongoConnector connects to real MongoDB shards.
+type mongoConnector struct {
+	maxPoolSize uint64 // 0 keeps the driver default
+	minPoolSize uint64
+}
+
+// newMongoConnectorFromEnv reads the pool sizes from MONGO_MAX_POOL_SIZE and MONGO_MIN_POOL_SIZE.
+func newMongoConnectorFromEnv() (*mongoConnector, error) {
+	maxPoolSize, err := uintFromEnv("MONGO_MAX_POOL_SIZE")
+	if err != nil {
+		return nil, err
+	}
+	minPoolSize, err := uintFromEnv("MONGO_MIN_POOL_SIZE")
+	if err != nil {
+		return nil, err
+	}
+	if maxPoolSize > 0 && minPoolSize > maxPoolSize {
+		return nil, fmt.Errorf("MONGO_MIN_POOL_SIZE (%d) is greater than MONGO_MAX_POOL_SIZE (%d)", minPoolSize, maxPoolSize)
+	}
+	return &mongoConnector{maxPoolSize: maxPoolSize, minPoolSize: minPoolSize}, nil
+}
+
+func (c *mongoConnector) Connect(ctx context.Context, uri string) (ShardClient, error) {
+	clientOptions := options.Client().ApplyURI(uri).SetMinPoolSize(c.minPoolSize)
+	if c.maxPoolSize > 0 {
+		clientOptions.SetMaxPoolSize(c.maxPoolSize)
+	}
+
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting: %w", err)
+	}
+
+	// Test the connection
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(context.Background())
+		return nil, fmt.Errorf("ping failed: %w", err)
+	}
+
+	return mongoShardClient{client}, nil
+}
+
+// mongoShardClient adapts *mongo.Client to the ShardClient interface.
+type mongoShardClient struct {
+	*mongo.Client
+}
+
+func (c mongoShardClient) Collection(database, collection string, opts ...*options.CollectionOptions) ShardCollection {
+	return c.Database(database).Collection(collection, opts...)
+}
+
+// connectWithRetry connects to a shard, retrying with exponential backoff so
+// the service can start even if a shard is not up yet.
+func connectWithRetry(connector ShardConnector, uri string, maxAttempts int) (ShardClient, error) {
+	delay := retryBaseDelay
+	var lastErr error
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+		client, err := connector.Connect(ctx, uri)
+		cancel()
+		if err == nil {
+			return client, nil
+		}
+		lastErr = err
+
+		if attempt < maxAttempts {
+			log.Printf("Attempt %d/%d to connect to %s failed: %v. Retrying in %v...", attempt, maxAttempts, uri, err, delay)
+			time.Sleep(delay)
+			delay = min(delay*2, retryMaxDelay)
+		}
+	}
+
+	return nil, fmt.Errorf("giving up on %s after %d attempts: %w", uri, maxAttempts, lastErr)
+}
+
+// connectAttemptsFromEnv reads the maximum connection attempts per shard from SHARD_CONNECT_ATTEMPTS.
+func connectAttemptsFromEnv() (int, error) {
+	value := os.Getenv("SHARD_CONNECT_ATTEMPTS")
+	if value == "" {
+		return defaultConnectAttempts, nil
+	}
+	attempts, err := strconv.Atoi(value)
+	if err != nil || attempts < 1 {
+		return 0, fmt.Errorf("invalid SHARD_CONNECT_ATTEMPTS %q: must be a positive integer", value)
+	}
+	return attempts, nil
+}
+
+// uintFromEnv parses an optional unsigned integer environment variable; unset means 0.
+func uintFromEnv(name string) (uint64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, value, err)
+	}
+	return n, nil
+}
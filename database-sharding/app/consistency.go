@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// collectionOptionsFromEnv builds the options of the two handles opened on
+// every shard's collection. Both carry the write concern. Only the scatter
+// handle, used by the scatter-gather queries, carries the read preference:
+// single-document reads stay on the primary, so a user is found right after
+// it is written. scatter is nil when no read preference is set.
+//
+//   - MONGO_WRITE_CONCERN: "majority" or a number of members (0 for
+//     unacknowledged writes); unset keeps the server default.
+//   - MONGO_WRITE_JOURNAL: "true" to wait for the journal before acknowledging.
+//   - MONGO_READ_PREFERENCE: primary, primaryPreferred, secondary,
+//     secondaryPreferred or nearest; unset reads from the primary.
+//   - MONGO_MAX_STALENESS: how far behind a secondary may be (e.g. "90s").
+//
+// Combinations the server would reject, like w=0 with the journal or a max
+// staleness with primary reads, are reported as errors here instead.
+func collectionOptionsFromEnv() (primary, scatter *options.CollectionOptions, err error) {
+	primary = options.Collection()
+
+	writeConcern, err := writeConcernFromEnv()
+	if err != nil {
+		return nil, nil, err
+	}
+	if writeConcern != nil {
+		primary.SetWriteConcern(writeConcern)
+	}
+
+	readPreference, err := readPreferenceFromEnv()
+	if err != nil {
+		return nil, nil, err
+	}
+	if readPreference != nil {
+		scatter = options.Collection().SetReadPreference(readPreference)
+		if writeConcern != nil {
+			scatter.SetWriteConcern(writeConcern)
+		}
+	}
+
+	return primary, scatter, nil
+}
+
+// writeConcernFromEnv reads MONGO_WRITE_CONCERN and MONGO_WRITE_JOURNAL.
+// It returns nil when neither is set.
+func writeConcernFromEnv() (*writeconcern.WriteConcern, error) {
+	w, journal := os.Getenv("MONGO_WRITE_CONCERN"), os.Getenv("MONGO_WRITE_JOURNAL")
+	if w == "" && journal == "" {
+		return nil, nil
+	}
+
+	writeConcern := &writeconcern.WriteConcern{}
+	switch w {
+	case "":
+	case "majority":
+		writeConcern.W = "majority"
+	default:
+		members, err := strconv.Atoi(w)
+		if err != nil || members < 0 {
+			return nil, fmt.Errorf("invalid MONGO_WRITE_CONCERN %q: must be \"majority\" or a non-negative integer", w)
+		}
+		writeConcern.W = members
+	}
+
+	if journal != "" {
+		j, err := strconv.ParseBool(journal)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MONGO_WRITE_JOURNAL %q: %w", journal, err)
+		}
+		writeConcern.Journal = &j
+	}
+
+	if !writeConcern.IsValid() {
+		return nil, fmt.Errorf("MONGO_WRITE_CONCERN=0 (unacknowledged) cannot be combined with MONGO_WRITE_JOURNAL=true")
+	}
+	return writeConcern, nil
+}
+
+// readPreferenceFromEnv reads MONGO_READ_PREFERENCE and MONGO_MAX_STALENESS.
+// It returns nil when neither is set.
+func readPreferenceFromEnv() (*readpref.ReadPref, error) {
+	value, staleness := os.Getenv("MONGO_READ_PREFERENCE"), os.Getenv("MONGO_MAX_STALENESS")
+	if value == "" && staleness == "" {
+		return nil, nil
+	}
+
+	mode := readpref.PrimaryMode
+	if value != "" {
+		var err error
+		if mode, err = readpref.ModeFromString(value); err != nil {
+			return nil, fmt.Errorf("invalid MONGO_READ_PREFERENCE %q: %w", value, err)
+		}
+	}
+
+	var readOptions []readpref.Option
+	if staleness != "" {
+		maxStaleness, err := time.ParseDuration(staleness)
+		if err != nil || maxStaleness <= 0 {
+			return nil, fmt.Errorf("invalid MONGO_MAX_STALENESS %q: must be a positive duration", staleness)
+		}
+		if mode == readpref.PrimaryMode {
+			return nil, fmt.Errorf("MONGO_MAX_STALENESS only applies to secondary reads, but MONGO_READ_PREFERENCE is primary")
+		}
+		readOptions = append(readOptions, readpref.WithMaxStaleness(maxStaleness))
+	}
+
+	return readpref.New(mode, readOptions...)
+}
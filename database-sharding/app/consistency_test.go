@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+func TestCollectionsUseConfiguredWriteConcernAndReadPreference(t *testing.T) {
+	t.Setenv("NUM_SHARDS", "2")
+	t.Setenv("MONGO_WRITE_CONCERN", "majority")
+	t.Setenv("MONGO_WRITE_JOURNAL", "true")
+	t.Setenv("MONGO_READ_PREFERENCE", "secondaryPreferred")
+	t.Setenv("MONGO_MAX_STALENESS", "90s")
+
+	connector := newFakeConnector()
+	sm, err := NewShardManagerForNamespace(connector, "userdb", "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sm.Close()
+
+	for i := 0; i < 2; i++ {
+		handles := connector.clients[shardURI(i)].collectionOpts["userdb.users"]
+		if len(handles) != 2 {
+			t.Fatalf("shard %d: %d collection handles opened, want one for the primary and one for scatter-gather", i, len(handles))
+		}
+		for _, opts := range handles {
+			if wc := opts.WriteConcern; wc == nil || wc.W != "majority" || wc.Journal == nil || !*wc.Journal {
+				t.Errorf("shard %d: write concern %+v, want majority with the journal", i, wc)
+			}
+		}
+		if rp := handles[0].ReadPreference; rp != nil {
+			t.Errorf("shard %d: the main handle reads with %v, want the primary", i, rp.Mode())
+		}
+		rp := handles[1].ReadPreference
+		if rp == nil {
+			t.Fatalf("shard %d: the scatter-gather handle has no read preference", i)
+		}
+		if staleness, set := rp.MaxStaleness(); rp.Mode() != readpref.SecondaryPreferredMode || !set || staleness != 90*time.Second {
+			t.Errorf("shard %d: read preference %v with max staleness %v, want secondaryPreferred with 90s", i, rp.Mode(), staleness)
+		}
+	}
+}
+
+func TestOnlyScatterGatherQueriesUseTheReadPreference(t *testing.T) {
+	t.Setenv("MONGO_READ_PREFERENCE", "secondaryPreferred")
+	sm, _ := newTestManager(t, 2, "", "")
+	handler := newRouter(&APIHandler{ShardManager: sm})
+	secondaryReads := func() int64 {
+		var total int64
+		for _, shard := range sm.GetAllScatterShards() {
+			total += shard.(*readPrefCollection).reads.Load()
+		}
+		return total
+	}
+
+	user := createUser(t, handler, "alice", "payload")
+	getUser(t, handler, user)
+	doRequest(handler, http.MethodPut, "/users/"+user.ID.String(), `{"data": "updated"}`)
+	if n := secondaryReads(); n != 0 {
+		t.Fatalf("%d reads by ID went through the read preference, want them on the primary", n)
+	}
+
+	for _, path := range []string{"/users/name/alice", "/users/name/alice/count", "/users"} {
+		before := secondaryReads()
+		if rec := doRequest(handler, http.MethodGet, path, ""); rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: got %d", path, rec.Code)
+		}
+		if n := secondaryReads() - before; n != 2 {
+			t.Errorf("GET %s made %d reads through the read preference, want one per shard", path, n)
+		}
+	}
+}
+
+func TestConflictingConsistencySettingsAreRejected(t *testing.T) {
+	for _, env := range []map[string]string{
+		{"MONGO_WRITE_CONCERN": "0", "MONGO_WRITE_JOURNAL": "true"},
+		{"MONGO_READ_PREFERENCE": "primary", "MONGO_MAX_STALENESS": "90s"},
+		{"MONGO_WRITE_CONCERN": "most"},
+		{"MONGO_READ_PREFERENCE": "anywhere"},
+	} {
+		for _, name := range []string{"MONGO_WRITE_CONCERN", "MONGO_WRITE_JOURNAL", "MONGO_READ_PREFERENCE", "MONGO_MAX_STALENESS"} {
+			t.Setenv(name, env[name])
+		}
+		if _, err := NewShardManagerForNamespace(newFakeConnector(), "userdb", "users"); err == nil {
+			t.Errorf("%v was accepted", env)
+		}
+	}
+}
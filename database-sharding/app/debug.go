@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// ShardDebugResponse is the body returned by DebugShard.
+type ShardDebugResponse struct {
+	ID        uuid.UUID `json:"id"`
+	Shard     int       `json:"shard"`
+	ShardKey  string    `json:"shard_key"`
+	Algorithm string    `json:"algorithm"`
+}
+
+// DebugShard tells which shard an ID maps to, without querying any shard.
+// When SHARD_KEY is "name" users are placed by name instead, so the answer
+// is only where the ID would go, not where the user lives.
+func (h *APIHandler) DebugShard(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := uuid.Parse(vars["id"])
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ShardDebugResponse{
+		ID:        id,
+		Shard:     h.ShardManager.ShardIndexForID(id),
+		ShardKey:  h.ShardManager.shardKey,
+		Algorithm: h.ShardManager.algorithm,
+	})
+}
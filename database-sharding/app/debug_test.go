@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestDebugShardReturnsTheShardIndex(t *testing.T) {
+	id := uuid.MustParse("6ba7b810-9dad-41d1-80b4-00c04fd430c8")
+	for _, algorithm := range []string{algorithmRing, algorithmJump, algorithmModulo} {
+		sm, _ := newTestManager(t, 5, "", algorithm)
+		handler := newRouter(&APIHandler{ShardManager: sm})
+
+		rec := doRequest(handler, http.MethodGet, "/debug/shard/"+id.String(), "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: got %d %s", algorithm, rec.Code, rec.Body)
+		}
+		var got ShardDebugResponse
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		want := ShardDebugResponse{ID: id, Shard: sm.getShardIndex(id), ShardKey: shardKeyID, Algorithm: algorithm}
+		if got != want {
+			t.Errorf("%s: got %+v, want %+v", algorithm, got, want)
+		}
+	}
+
+	sm, _ := newTestManager(t, 5, "", "")
+	if rec := doRequest(newRouter(&APIHandler{ShardManager: sm}), http.MethodGet, "/debug/shard/42", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("malformed ID: got %d, want 400", rec.Code)
+	}
+}
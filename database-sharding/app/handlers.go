@@ -1,34 +1,117 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type APIHandler struct {
 	ShardManager *ShardManager
 }
 
+// operationTimeout bounds every shard operation made on behalf of a request.
+const operationTimeout = 5 * time.Second
+
+// operationContext derives the context for shard operations from the request,
+// so a client disconnect cancels them and a slow shard cannot hang forever.
+func operationContext(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), operationTimeout)
+}
+
+// Limits on what CreateUser, CreateUsersBulk and UpdateUser accept.
+const (
+	maxCreateBodyBytes = 64 << 10
+	maxBulkBodyBytes   = 8 << 20
+	maxNameLength      = 200      // In bytes
+	maxDataLength      = 32 << 10 // In bytes
+)
+
+// validateUser checks the client-supplied fields of a new user.
+func validateUser(user User) error {
+	if err := validateName(user.Name); err != nil {
+		return err
+	}
+	return validateData(user.Data)
+}
+
+// validateName checks a user name: not blank and at most maxNameLength bytes.
+func validateName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("name is required")
+	}
+	if len(name) > maxNameLength {
+		return fmt.Errorf("name is %d bytes long, the maximum is %d", len(name), maxNameLength)
+	}
+	return nil
+}
+
+// validateData checks that user data is at most maxDataLength bytes.
+func validateData(data string) error {
+	if len(data) > maxDataLength {
+		return fmt.Errorf("data is %d bytes long, the maximum is %d", len(data), maxDataLength)
+	}
+	return nil
+}
+
+// readBody reads a request body of at most limit bytes. If it fails, the
+// error has been answered and ok is false.
+func readBody(w http.ResponseWriter, r *http.Request, limit int) (body []byte, ok bool) {
+	// Read one byte past the limit to tell a body at the limit from a bigger one.
+	body, err := io.ReadAll(io.LimitReader(r.Body, int64(limit)+1))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "error reading request body")
+		return nil, false
+	}
+	if len(body) > limit {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", limit))
+		return nil, false
+	}
+	return body, true
+}
+
+// CreateUser validates and inserts a single user. Invalid input is answered
+// with a 400 and a JSON body like {"error": "name is required"}.
 func (h *APIHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	body, ok := readBody(w, r, maxCreateBodyBytes)
+	if !ok {
+		return
+	}
+
 	var user User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := json.Unmarshal(body, &user); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if err := validateUser(user); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	user.ID = uuid.New()
+	h.ShardManager.recordID(user.ID)
 
-	shard := h.ShardManager.GetShardForID(user.ID)
-	_, err := shard.InsertOne(context.Background(), user)
-	if err != nil {
+	ctx, cancel := operationContext(r)
+	defer cancel()
+
+	shard := h.ShardManager.GetShardForUser(user)
+	if _, err := shard.InsertOne(ctx, user); err != nil {
 		http.Error(w, "Error creating user", http.StatusInternalServerError)
 		log.Printf("Error in InsertOne: %v", err)
 		return
@@ -39,6 +122,111 @@ func (h *APIHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(user)
 }
 
+// BulkInsertResult summarizes a bulk insert: how many users went to each shard.
+type BulkInsertResult struct {
+	Inserted int         `json:"inserted"`
+	PerShard map[int]int `json:"per_shard"`
+}
+
+// CreateUsersBulk inserts many users at once. The users are grouped by target
+// shard and each group is written with a single InsertMany, all shards in parallel.
+// Every user is validated like in CreateUser first: one invalid user rejects
+// the whole request with a 400 naming its index, and nothing is inserted.
+func (h *APIHandler) CreateUsersBulk(w http.ResponseWriter, r *http.Request) {
+	body, ok := readBody(w, r, maxBulkBodyBytes)
+	if !ok {
+		return
+	}
+
+	var users []User
+	if err := json.Unmarshal(body, &users); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	for i, user := range users {
+		if err := validateUser(user); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("user %d: %v", i, err))
+			return
+		}
+	}
+
+	groups := make(map[int][]interface{})
+	for i := range users {
+		users[i].ID = uuid.New()
+		h.ShardManager.recordID(users[i].ID)
+		index := h.ShardManager.shardIndexForUser(users[i])
+		groups[index] = append(groups[index], users[i])
+	}
+	// Shards are only ever appended, so every computed index is valid here.
+	allShards := h.ShardManager.GetAllShards()
+
+	ctx, cancel := operationContext(r)
+	defer cancel()
+
+	result := BulkInsertResult{PerShard: make(map[int]int)}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := false
+
+	for index, docs := range groups {
+		wg.Add(1)
+		go func(index int, docs []interface{}) {
+			defer wg.Done()
+			res, err := allShards[index].InsertMany(ctx, docs)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if res != nil {
+				result.PerShard[index] += len(res.InsertedIDs)
+				result.Inserted += len(res.InsertedIDs)
+			}
+			if err != nil {
+				log.Printf("Error in InsertMany on shard %d: %v", index, err)
+				failed = true
+			}
+		}(index, docs)
+	}
+
+	wg.Wait()
+
+	w.Header().Set("Content-Type", "application/json")
+	if failed {
+		w.WriteHeader(http.StatusInternalServerError)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// healthCheckTimeout bounds how long GET /health waits for the shards to answer.
+const healthCheckTimeout = 2 * time.Second
+
+// HealthResponse reports the connectivity of every shard.
+type HealthResponse struct {
+	Healthy bool          `json:"healthy"`
+	Shards  []ShardStatus `json:"shards"`
+}
+
+// Health pings every shard and answers 503 if any of them is down.
+func (h *APIHandler) Health(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+
+	response := HealthResponse{Healthy: true, Shards: h.ShardManager.PingAll(ctx)}
+	for _, status := range response.Shards {
+		if !status.OK {
+			response.Healthy = false
+			log.Printf("Health check: shard %d is down: %s", status.Index, status.Error)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !response.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
 func (h *APIHandler) GetUserByID(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := uuid.Parse(vars["id"])
@@ -47,63 +235,225 @@ func (h *APIHandler) GetUserByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	shard := h.ShardManager.GetShardForID(id)
+	// The ID filter rules out IDs that were never created without a query.
+	if !h.ShardManager.MayContainID(id) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := operationContext(r)
+	defer cancel()
+
+	// One shard when sharding by ID; when sharding by name, try each in turn.
 	var user User
-	err = shard.FindOne(context.Background(), bson.M{"_id": id}).Decode(&user)
-	if err != nil {
+	err = mongo.ErrNoDocuments
+	for _, shard := range h.ShardManager.ShardsForID(id) {
+		err = shard.FindOne(ctx, bson.M{"_id": id}).Decode(&user)
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			break
+		}
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		http.Error(w, "Error fetching user", http.StatusInternalServerError)
+		log.Printf("Error in FindOne: %v", err)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
 }
 
+// partialResultsHeader is set to "true" when a scatter-gather query answers
+// without the results of some shards.
+const partialResultsHeader = "X-Partial-Results"
+
+// parsePagination reads the optional 'offset' and 'limit' query parameters.
+// A limit of 0 means no pagination was requested.
+func parsePagination(r *http.Request) (offset, limit int64, err error) {
+	query := r.URL.Query()
+	if v := query.Get("offset"); v != "" {
+		if offset, err = strconv.ParseInt(v, 10, 64); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("invalid offset %q", v)
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.ParseInt(v, 10, 64); err != nil || limit <= 0 {
+			return 0, 0, fmt.Errorf("invalid limit %q", v)
+		}
+	}
+	return offset, limit, nil
+}
+
 // GetUserByName is a costly operation in a system with ID-based sharding.
-// It needs to query ALL shards.
+// It needs to query ALL shards, unless the users are sharded by name.
+//
+// With 'limit' (and optionally 'offset'), each shard returns at most
+// offset+limit users sorted by _id; the partial results are then merged in
+// _id order and the global page is cut from the merged list.
+//
+// This is a best-effort query: it answers with whatever the shards returned
+// before the deadline and sets the partialResultsHeader when any shard failed
+// or did not answer in time.
 func (h *APIHandler) GetUserByName(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name := vars["name"]
 
-	var users []User
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-	allShards := h.ShardManager.GetAllShards()
-	wg.Add(len(allShards))
+	offset, limit, err := parsePagination(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	// Launch goroutines to query all shards in parallel.
-	for _, shard := range allShards {
-		go func(s *mongo.Collection) {
-			defer wg.Done()
-			cursor, err := s.Find(context.Background(), bson.M{"name": name})
-			if err != nil {
-				log.Printf("Error querying shard: %v", err)
-				return
-			}
-			defer cursor.Close(context.Background())
+	findOptions := options.Find().SetSort(bson.M{"_id": 1})
+	if limit > 0 {
+		findOptions.SetLimit(offset + limit)
+	}
+
+	ctx, cancel := operationContext(r)
+	defer cancel()
 
-			var shardUsers []User
-			if err = cursor.All(context.Background(), &shardUsers); err != nil {
-				log.Printf("Error decoding shard results: %v", err)
-				return
+	// Query all shards in parallel. A shard that fails or misses the deadline
+	// only leaves its users out of the response.
+	allShards := h.ShardManager.ScatterShardsForName(name)
+	users, err := ScatterGather(allShards, func(s ShardCollection) ([]User, error) {
+		cursor, err := s.Find(ctx, bson.M{"name": name}, findOptions)
+		if err != nil {
+			return nil, fmt.Errorf("error querying shard: %w", err)
+		}
+		defer cursor.Close(context.Background())
+
+		var shardUsers []User
+		if err = cursor.All(ctx, &shardUsers); err != nil {
+			return nil, fmt.Errorf("error decoding shard results: %w", err)
+		}
+		return shardUsers, nil
+	})
+
+	partial := false
+	var scatterErr *ScatterGatherError
+	if errors.As(err, &scatterErr) {
+		log.Printf("GetUserByName: %v", scatterErr)
+		if scatterErr.Failed == scatterErr.Shards {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				http.Error(w, "No shard answered in time", http.StatusGatewayTimeout)
+			} else {
+				http.Error(w, "No shard is available", http.StatusServiceUnavailable)
 			}
+			return
+		}
+		partial = true
+		w.Header().Set(partialResultsHeader, "true")
+	}
+	// With missing shards, an empty result does not mean the name is unknown.
+	if len(users) == 0 && !partial {
+		http.Error(w, "No user found with that name", http.StatusNotFound)
+		return
+	}
+	if users == nil {
+		users = []User{}
+	}
 
-			// Use a mutex to add the results to the final list in a safe way.
-			mu.Lock()
-			users = append(users, shardUsers...)
-			mu.Unlock()
-		}(shard)
+	if limit > 0 {
+		// Merge the per-shard results in a stable _id order before paging.
+		sort.SliceStable(users, func(i, j int) bool {
+			return bytes.Compare(users[i].ID[:], users[j].ID[:]) < 0
+		})
+		start := min(offset, int64(len(users)))
+		end := min(start+limit, int64(len(users)))
+		users = users[start:end]
 	}
 
-	wg.Wait()
+	writeUsers(w, r, users)
+}
 
-	if len(users) == 0 {
-		http.Error(w, "No user found with that name", http.StatusNotFound)
+// NameCountResponse is the body returned by CountUsersByName.
+type NameCountResponse struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// CountUsersByName counts the users with a given name on every shard in
+// parallel and returns the sum, without transferring any documents.
+func (h *APIHandler) CountUsersByName(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	ctx, cancel := operationContext(r)
+	defer cancel()
+
+	counts, err := ScatterGather(h.ShardManager.ScatterShardsForName(name), func(s ShardCollection) ([]int64, error) {
+		count, err := s.CountDocuments(ctx, bson.M{"name": name})
+		return []int64{count}, err
+	})
+	if err != nil {
+		http.Error(w, "Error counting users", http.StatusInternalServerError)
+		log.Printf("Error counting by name: %v", err)
 		return
 	}
 
+	var total int64
+	for _, count := range counts {
+		total += count
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(NameCountResponse{Name: name, Count: total})
+}
+
+// NameDeleteResponse is the body returned by DeleteUsersByName.
+type NameDeleteResponse struct {
+	Name    string `json:"name"`
+	Deleted int64  `json:"deleted"`
+}
+
+// DeleteUsersByName deletes every user with a given name, running DeleteMany
+// on all candidate shards in parallel, and returns the total deleted count.
+// Deleting nothing is not an error: the answer is then a count of 0.
+func (h *APIHandler) DeleteUsersByName(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	ctx, cancel := operationContext(r)
+	defer cancel()
+
+	deletedCounts, err := ScatterGather(h.ShardManager.ShardsForName(name), func(s ShardCollection) ([]int64, error) {
+		deleted, err := h.ShardManager.deleteByName(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+		return []int64{deleted}, nil
+	})
+	failed := err != nil
+	if failed {
+		log.Printf("Error deleting by name: %v", err)
+	}
+
+	var total int64
+	for _, deleted := range deletedCounts {
+		total += deleted
+	}
+
+	// Even on failure, report what the other shards did delete.
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(users)
+	if failed {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(NameDeleteResponse{Name: name, Deleted: total})
+}
+
+// updatableUserFields whitelists the fields UpdateUser may $set, with the
+// check each new value must pass: the same as on create, so an update cannot
+// store what CreateUser would have rejected.
+var updatableUserFields = []struct {
+	name     string
+	validate func(string) error
+}{
+	{"name", validateName},
+	{"data", validateData},
 }
 
 func (h *APIHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
@@ -114,23 +464,76 @@ func (h *APIHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var updates map[string]string
-	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	body, ok := readBody(w, r, maxCreateBodyBytes)
+	if !ok {
+		return
+	}
+
+	var updates map[string]interface{}
+	if err := json.Unmarshal(body, &updates); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	// Only the fields present in the body are updated, so a PUT with just
+	// 'name' does not wipe 'data' (and vice versa).
+	fields := bson.M{}
+	for _, field := range updatableUserFields {
+		value, present := updates[field.name]
+		if !present {
+			continue
+		}
+		str, ok := value.(string)
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, field.name+" must be a string")
+			return
+		}
+		if err := field.validate(str); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		fields[field.name] = str
+	}
+	if len(fields) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "no updatable fields in request body")
 		return
 	}
 
-	// Find the correct shard.
-	shard := h.ShardManager.GetShardForID(id)
-	updateData := bson.M{
-		"$set": bson.M{
-			"name": updates["name"],
-			"data": updates["data"],
-		},
+	ctx, cancel := operationContext(r)
+	defer cancel()
+
+	// When sharding by name, a new name can belong to another shard.
+	if _, renamed := fields["name"]; renamed && h.ShardManager.shardKey == shardKeyName {
+		found, err := h.ShardManager.updateAndMove(ctx, id, fields)
+		if err != nil {
+			http.Error(w, "Error updating user", http.StatusInternalServerError)
+			log.Printf("Error updating user %s: %v", id, err)
+			return
+		}
+		if !found {
+			http.Error(w, "User not found for update", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
 	}
 
-	result, err := shard.UpdateOne(context.Background(), bson.M{"_id": id}, updateData)
-	if err != nil || result.MatchedCount == 0 {
+	// Find the correct shard: the only candidate when sharding by ID.
+	updateData := bson.M{"$set": fields}
+
+	var matched int64
+	for _, shard := range h.ShardManager.ShardsForID(id) {
+		result, err := shard.UpdateOne(ctx, bson.M{"_id": id}, updateData)
+		if err != nil {
+			http.Error(w, "Error updating user", http.StatusInternalServerError)
+			log.Printf("Error in UpdateOne: %v", err)
+			return
+		}
+		if matched = result.MatchedCount; matched > 0 {
+			break
+		}
+	}
+	if matched == 0 {
 		http.Error(w, "User not found for update", http.StatusNotFound)
 		return
 	}
@@ -147,12 +550,26 @@ func (h *APIHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Find the correct shard and delete the user.
-	shard := h.ShardManager.GetShardForID(id)
-	result, err := shard.DeleteOne(context.Background(), bson.M{"_id": id})
-	if err != nil || result.DeletedCount == 0 {
+	ctx, cancel := operationContext(r)
+	defer cancel()
+
+	var deleted int64
+	for _, shard := range h.ShardManager.ShardsForID(id) {
+		result, err := shard.DeleteOne(ctx, bson.M{"_id": id})
+		if err != nil {
+			http.Error(w, "Error deleting user", http.StatusInternalServerError)
+			log.Printf("Error in DeleteOne: %v", err)
+			return
+		}
+		if deleted = result.DeletedCount; deleted > 0 {
+			break
+		}
+	}
+	if deleted == 0 {
 		http.Error(w, "User not found for deletion", http.StatusNotFound)
 		return
 	}
+	h.ShardManager.forgetID(id)
 
 	w.WriteHeader(http.StatusNoContent)
 }
\ No newline at end of file
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// doRequest sends a request with the given body through handler.
+func doRequest(handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rec
+}
+
+// createUser creates a user through the API and returns it.
+func createUser(t *testing.T, handler http.Handler, name, data string) User {
+	t.Helper()
+	rec := doRequest(handler, http.MethodPost, "/users", fmt.Sprintf(`{"name": %q, "data": %q}`, name, data))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create %s: got %d %s", name, rec.Code, rec.Body)
+	}
+	var user User
+	if err := json.NewDecoder(rec.Body).Decode(&user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// getUser fetches a user by ID through the API.
+func getUser(t *testing.T, handler http.Handler, user User) User {
+	t.Helper()
+	rec := doRequest(handler, http.MethodGet, "/users/"+user.ID.String(), "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("get %s: got %d", user.ID, rec.Code)
+	}
+	var got User
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestRenameWithNameShardingMovesUser(t *testing.T) {
+	sm, collections := newTestManager(t, 4, shardKeyName, "")
+	handler := newRouter(&APIHandler{ShardManager: sm})
+
+	// Find a new name that belongs to another shard than the old one
+	user := createUser(t, handler, "alice", "payload")
+	newName := ""
+	for i := 0; newName == ""; i++ {
+		candidate := fmt.Sprintf("alice-%d", i)
+		if sm.shardIndexForUser(User{Name: candidate}) != sm.shardIndexForUser(user) {
+			newName = candidate
+		}
+	}
+
+	rec := doRequest(handler, http.MethodPut, "/users/"+user.ID.String(), fmt.Sprintf(`{"name": %q}`, newName))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("rename: got %d %s", rec.Code, rec.Body)
+	}
+
+	rec = doRequest(handler, http.MethodGet, "/users/name/"+newName, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("lookup by the new name: got %d", rec.Code)
+	}
+	var found []User
+	json.NewDecoder(rec.Body).Decode(&found)
+	if len(found) != 1 || found[0].ID != user.ID || found[0].Data != "payload" {
+		t.Fatalf("lookup by the new name returned %+v", found)
+	}
+
+	total := 0
+	for _, c := range collections {
+		total += c.len()
+	}
+	if total != 1 {
+		t.Errorf("%d documents stored after the move, want 1", total)
+	}
+}
+
+// getByName looks up a name through the API with a request bounded by timeout.
+func getByName(handler http.Handler, name string, timeout time.Duration) *httptest.ResponseRecorder {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/name/"+name, nil).WithContext(ctx))
+	return rec
+}
+
+func TestGetUserByNameWithSlowShardReturnsPartialResults(t *testing.T) {
+	sm, collections := newTestManager(t, 3, "", "")
+	handler := newRouter(&APIHandler{ShardManager: sm})
+
+	// Create users until every shard holds at least one named carol.
+	for i := 0; ; i++ {
+		createUser(t, handler, "carol", fmt.Sprint(i))
+		holding := 0
+		for _, c := range collections {
+			if c.len() > 0 {
+				holding++
+			}
+		}
+		if holding == len(collections) {
+			break
+		}
+	}
+	total := 0
+	for _, c := range collections {
+		total += c.len()
+	}
+	slow := collections[0]
+	slow.delay = time.Second
+
+	rec := getByName(handler, "carol", 100*time.Millisecond)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d %s, want 200", rec.Code, rec.Body)
+	}
+	if rec.Header().Get(partialResultsHeader) != "true" {
+		t.Error("a response missing a shard is not marked partial")
+	}
+	var found []User
+	json.NewDecoder(rec.Body).Decode(&found)
+	if want := total - slow.len(); len(found) != want {
+		t.Errorf("got %d users, want the %d on the shards that answered", len(found), want)
+	}
+}
+
+func TestGetUserByNameWhenNoShardAnswers(t *testing.T) {
+	sm, collections := newTestManager(t, 3, "", "")
+	handler := newRouter(&APIHandler{ShardManager: sm})
+
+	for _, c := range collections {
+		c.delay = time.Second
+	}
+	if rec := getByName(handler, "carol", 50*time.Millisecond); rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("every shard timed out: got %d, want 504", rec.Code)
+	}
+
+	for _, c := range collections {
+		c.delay = 0
+		c.err = errors.New("connection refused")
+	}
+	if rec := getByName(handler, "carol", time.Second); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("every shard failed: got %d, want 503", rec.Code)
+	}
+}
+
+func TestCreateUsersBulk(t *testing.T) {
+	sm, collections := newTestManager(t, 3, "", "")
+	handler := newRouter(&APIHandler{ShardManager: sm})
+	stored := func() int {
+		total := 0
+		for _, c := range collections {
+			total += c.len()
+		}
+		return total
+	}
+
+	rec := doRequest(handler, http.MethodPost, "/users/bulk", `[{"name": "a"}, {"name": "b"}, {"name": "  ", "data": "x"}]`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "user 2") {
+		t.Errorf("blank name at index 2: got %d %s, want 400 naming user 2", rec.Code, rec.Body)
+	}
+	oversized := fmt.Sprintf(`[{"name": "a", "data": %q}]`, strings.Repeat("x", maxDataLength+1))
+	if rec := doRequest(handler, http.MethodPost, "/users/bulk", oversized); rec.Code != http.StatusBadRequest {
+		t.Errorf("oversized data: got %d, want 400", rec.Code)
+	}
+	if n := stored(); n != 0 {
+		t.Fatalf("%d users stored from rejected requests", n)
+	}
+
+	huge := `[{"name": "a", "data": "` + strings.Repeat("x", maxBulkBodyBytes) + `"}]`
+	if rec := doRequest(handler, http.MethodPost, "/users/bulk", huge); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("body over the limit: got %d, want 413", rec.Code)
+	}
+
+	rec = doRequest(handler, http.MethodPost, "/users/bulk", `[{"name": "a"}, {"name": "b", "data": "x"}, {"name": "c"}]`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("valid bulk: got %d %s", rec.Code, rec.Body)
+	}
+	var result BulkInsertResult
+	json.NewDecoder(rec.Body).Decode(&result)
+	if result.Inserted != 3 || stored() != 3 {
+		t.Errorf("reported %d inserted and %d stored, want 3", result.Inserted, stored())
+	}
+}
+
+func TestCreateThenGetUser(t *testing.T) {
+	sm, _ := newTestManager(t, 4, "", "")
+	handler := newRouter(&APIHandler{ShardManager: sm})
+
+	created := createUser(t, handler, "dave", "payload")
+	if created.ID == uuid.Nil || created.Name != "dave" || created.Data != "payload" {
+		t.Fatalf("create returned %+v", created)
+	}
+	if got := getUser(t, handler, created); got != created {
+		t.Errorf("get returned %+v, want %+v", got, created)
+	}
+
+	if rec := doRequest(handler, http.MethodGet, "/users/"+uuid.NewString(), ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown ID: got %d, want 404", rec.Code)
+	}
+	if rec := doRequest(handler, http.MethodGet, "/users/not-a-uuid", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("malformed ID: got %d, want 400", rec.Code)
+	}
+}
+
+func TestUpdateUserKeepsFieldsMissingFromTheBody(t *testing.T) {
+	sm, _ := newTestManager(t, 4, "", "")
+	handler := newRouter(&APIHandler{ShardManager: sm})
+
+	for _, tc := range []struct {
+		body string
+		want User
+	}{
+		{`{"name": "erin"}`, User{Name: "erin", Data: "old data"}},
+		{`{"data": "new data"}`, User{Name: "eve", Data: "new data"}},
+		{`{"name": "erin", "data": "new data"}`, User{Name: "erin", Data: "new data"}},
+	} {
+		user := createUser(t, handler, "eve", "old data")
+		rec := doRequest(handler, http.MethodPut, "/users/"+user.ID.String(), tc.body)
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("update with %s: got %d %s", tc.body, rec.Code, rec.Body)
+		}
+		tc.want.ID = user.ID
+		if got := getUser(t, handler, user); got != tc.want {
+			t.Errorf("after an update with %s: got %+v, want %+v", tc.body, got, tc.want)
+		}
+	}
+
+	user := createUser(t, handler, "eve", "old data")
+	for _, body := range []string{
+		`{}`,
+		`{"age": 3}`,
+		`{"name": 3}`,
+		`{"name": ""}`,
+		`{"name": "  ", "data": "x"}`,
+		fmt.Sprintf(`{"name": %q}`, strings.Repeat("n", maxNameLength+1)),
+		fmt.Sprintf(`{"data": %q}`, strings.Repeat("x", maxDataLength+1)),
+	} {
+		if rec := doRequest(handler, http.MethodPut, "/users/"+user.ID.String(), body); rec.Code != http.StatusBadRequest {
+			t.Errorf("update with %.40s: got %d, want 400", body, rec.Code)
+		}
+	}
+	huge := `{"data": "` + strings.Repeat("x", maxCreateBodyBytes) + `"}`
+	if rec := doRequest(handler, http.MethodPut, "/users/"+user.ID.String(), huge); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("body over the limit: got %d, want 413", rec.Code)
+	}
+	if got := getUser(t, handler, user); got != user {
+		t.Errorf("rejected updates changed the user to %+v", got)
+	}
+}
+
+func TestHealthReportsADownShard(t *testing.T) {
+	sm, _ := newTestManager(t, 3, "", "")
+	handler := newRouter(&APIHandler{ShardManager: sm})
+
+	if rec := doRequest(handler, http.MethodGet, "/health", ""); rec.Code != http.StatusOK {
+		t.Fatalf("all shards up: got %d %s", rec.Code, rec.Body)
+	}
+
+	sm.Clients[1].(*fakeClient).pingErr = errors.New("connection refused")
+	rec := doRequest(handler, http.MethodGet, "/health", "")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("shard 1 down: got %d, want 503", rec.Code)
+	}
+	var health HealthResponse
+	if err := json.NewDecoder(rec.Body).Decode(&health); err != nil {
+		t.Fatal(err)
+	}
+	want := []ShardStatus{{Index: 0, OK: true}, {Index: 1, Error: "connection refused"}, {Index: 2, OK: true}}
+	if health.Healthy || fmt.Sprint(health.Shards) != fmt.Sprint(want) {
+		t.Errorf("health %+v, want unhealthy with shards %+v", health, want)
+	}
+}
+
+func TestHandlersGiveUpOnABlockedShard(t *testing.T) {
+	sm, collections := newTestManager(t, 2, "", "")
+	handler := newRouter(&APIHandler{ShardManager: sm})
+	for _, c := range collections {
+		c.delay = time.Minute
+	}
+
+	for _, req := range []struct{ method, path, body string }{
+		{http.MethodGet, "/users/" + uuid.NewString(), ""},
+		{http.MethodPost, "/users", `{"name": "frank"}`},
+		{http.MethodDelete, "/users/" + uuid.NewString(), ""},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		handler.ServeHTTP(rec, httptest.NewRequest(req.method, req.path, strings.NewReader(req.body)).WithContext(ctx))
+		cancel()
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s %s took %v against a blocked shard, want it bounded by the request's 50ms deadline", req.method, req.path, elapsed)
+		}
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("%s %s: got %d, want 500", req.method, req.path, rec.Code)
+		}
+	}
+}
+
+func TestShardErrorsAreNotReportedAsNotFound(t *testing.T) {
+	sm, collections := newTestManager(t, 2, "", "")
+	handler := newRouter(&APIHandler{ShardManager: sm})
+	user := createUser(t, handler, "grace", "payload")
+	for _, c := range collections {
+		c.err = errors.New("connection reset by peer")
+	}
+
+	path := "/users/" + user.ID.String()
+	for _, req := range []struct{ method, body string }{
+		{http.MethodGet, ""},
+		{http.MethodPut, `{"data": "new"}`},
+		{http.MethodDelete, ""},
+	} {
+		if rec := doRequest(handler, req.method, path, req.body); rec.Code != http.StatusInternalServerError {
+			t.Errorf("%s with a failing shard: got %d, want 500", req.method, rec.Code)
+		}
+	}
+
+	for _, c := range collections {
+		c.err = nil
+	}
+	missing := "/users/" + uuid.NewString()
+	for _, req := range []struct{ method, body string }{
+		{http.MethodGet, ""},
+		{http.MethodPut, `{"data": "new"}`},
+		{http.MethodDelete, ""},
+	} {
+		if rec := doRequest(handler, req.method, missing, req.body); rec.Code != http.StatusNotFound {
+			t.Errorf("%s of an unknown ID: got %d, want 404", req.method, rec.Code)
+		}
+	}
+}
+
+func TestGetUserByNameNegotiatesTheFormat(t *testing.T) {
+	sm, _ := newTestManager(t, 3, "", "")
+	handler := newRouter(&APIHandler{ShardManager: sm})
+	ids := map[string]bool{}
+	for _, data := range []string{"first", "second, with a comma"} {
+		ids[createUser(t, handler, "ivan", data).ID.String()] = true
+	}
+	get := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/users/name/ivan", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("text/csv")
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Fatalf("Accept text/csv: Content-Type %q", ct)
+	}
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || fmt.Sprint(rows[0]) != "[id name data]" {
+		t.Fatalf("CSV rows %q, want a header and 2 users", rows)
+	}
+	for _, row := range rows[1:] {
+		if !ids[row[0]] || row[1] != "ivan" {
+			t.Errorf("unexpected CSV row %q", row)
+		}
+	}
+
+	for _, accept := range []string{"", "application/json", "text/csv;q=0.5, application/json"} {
+		rec := get(accept)
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Accept %q: Content-Type %q, want application/json", accept, ct)
+		}
+		var users []User
+		if err := json.NewDecoder(rec.Body).Decode(&users); err != nil || len(users) != 2 {
+			t.Errorf("Accept %q: got %d users (%v), want 2 as JSON", accept, len(users), err)
+		}
+	}
+}
+
+func TestCreateUserValidatesInput(t *testing.T) {
+	sm, collections := newTestManager(t, 3, "", "")
+	handler := newRouter(&APIHandler{ShardManager: sm})
+
+	for _, tc := range []struct {
+		name, body, wantError string
+		wantCode              int
+	}{
+		{"empty name", `{"name": "", "data": "x"}`, "name is required", http.StatusBadRequest},
+		{"blank name", `{"name": "   "}`, "name is required", http.StatusBadRequest},
+		{"long name", fmt.Sprintf(`{"name": %q}`, strings.Repeat("n", maxNameLength+1)), "name is", http.StatusBadRequest},
+		{"oversized data", fmt.Sprintf(`{"name": "judy", "data": %q}`, strings.Repeat("x", maxDataLength+1)), "data is", http.StatusBadRequest},
+		{"malformed JSON", `{"name": `, "invalid request body", http.StatusBadRequest},
+		{"huge body", `{"name": "judy", "data": "` + strings.Repeat("x", maxCreateBodyBytes) + `"}`, "request body exceeds", http.StatusRequestEntityTooLarge},
+	} {
+		rec := doRequest(handler, http.MethodPost, "/users", tc.body)
+		var body errorResponse
+		json.NewDecoder(rec.Body).Decode(&body)
+		if rec.Code != tc.wantCode || !strings.Contains(body.Error, tc.wantError) {
+			t.Errorf("%s: got %d %+v, want %d with an error containing %q", tc.name, rec.Code, body, tc.wantCode, tc.wantError)
+		}
+	}
+	if n := storedCount(collections); n != 0 {
+		t.Fatalf("%d users stored from invalid requests", n)
+	}
+
+	user := createUser(t, handler, "judy", strings.Repeat("x", maxDataLength))
+	if got := getUser(t, handler, user); got != user {
+		t.Errorf("stored %+v, want %+v", got, user)
+	}
+}
+
+// shardsHolding returns how many of collections hold a user named name.
+func shardsHolding(collections []*fakeCollection, name string) int {
+	holding := 0
+	for _, c := range collections {
+		if n, _ := c.CountDocuments(context.Background(), bson.M{"name": name}); n > 0 {
+			holding++
+		}
+	}
+	return holding
+}
+
+func TestCountUsersByNameSumsEveryShard(t *testing.T) {
+	sm, collections := newTestManager(t, 4, "", "")
+	handler := newRouter(&APIHandler{ShardManager: sm})
+
+	const n = 40
+	for i := 0; i < n; i++ {
+		createUser(t, handler, "grace", fmt.Sprint(i))
+		createUser(t, handler, "heidi", fmt.Sprint(i))
+	}
+	if shardsHolding(collections, "grace") < 2 {
+		t.Fatal("the users did not spread over several shards")
+	}
+
+	for _, tc := range []struct {
+		name string
+		want int64
+	}{
+		{"grace", n},
+		{"nobody", 0},
+	} {
+		rec := doRequest(handler, http.MethodGet, "/users/name/"+tc.name+"/count", "")
+		var got NameCountResponse
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("count %s: got %d (%v)", tc.name, rec.Code, err)
+		}
+		if got != (NameCountResponse{Name: tc.name, Count: tc.want}) {
+			t.Errorf("count %s: got %+v, want a count of %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDeleteUsersByNameReportsTheTotal(t *testing.T) {
+	sm, collections := newTestManager(t, 4, "", "")
+	handler := newRouter(&APIHandler{ShardManager: sm})
+
+	const n = 40
+	for i := 0; i < n; i++ {
+		createUser(t, handler, "ivan", fmt.Sprint(i))
+	}
+	kept := createUser(t, handler, "judy", "kept")
+	if shardsHolding(collections, "ivan") < 2 {
+		t.Fatal("the users did not spread over several shards")
+	}
+
+	for _, want := range []int64{n, 0} { // Deleting again matches nothing
+		rec := doRequest(handler, http.MethodDelete, "/users/name/ivan", "")
+		var got NameDeleteResponse
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("delete ivan: got %d (%v), want 200", rec.Code, err)
+		}
+		if got != (NameDeleteResponse{Name: "ivan", Deleted: want}) {
+			t.Errorf("delete ivan: got %+v, want %d deleted", got, want)
+		}
+	}
+	if n := storedCount(collections); n != 1 {
+		t.Errorf("%d users left, want only the one with another name", n)
+	}
+	getUser(t, handler, kept)
+}
+
+func TestRenameOverwritesAStaleCopyOnTheTargetShard(t *testing.T) {
+	sm, collections := newTestManager(t, 4, shardKeyName, "")
+	handler := newRouter(&APIHandler{ShardManager: sm})
+
+	// The user is on shard 0, so the lookup by ID finds it before the stale
+	// copy on the later target shard
+	var user User
+	for i := 0; user.Name == ""; i++ {
+		if name := fmt.Sprintf("bob-%d", i); sm.shardIndexForUser(User{Name: name}) == 0 {
+			user = createUser(t, handler, name, "current")
+		}
+	}
+	newName, target := "", 0
+	for i := 0; newName == ""; i++ {
+		candidate := fmt.Sprintf("robert-%d", i)
+		if target = sm.shardIndexForUser(User{Name: candidate}); target != 0 {
+			newName = candidate
+		}
+	}
+	stale := User{ID: user.ID, Name: newName, Data: "stale"}
+	if _, err := collections[target].InsertOne(context.Background(), stale); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := doRequest(handler, http.MethodPut, "/users/"+user.ID.String(), fmt.Sprintf(`{"name": %q}`, newName))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("rename: got %d %s", rec.Code, rec.Body)
+	}
+
+	if got := getUser(t, handler, user); got.Name != newName || got.Data != "current" {
+		t.Errorf("after the rename got %+v, want %s with the current data", got, newName)
+	}
+	if n := collections[0].len(); n != 0 {
+		t.Errorf("%d documents left on the source shard, want 0", n)
+	}
+	if n := collections[target].len(); n != 1 {
+		t.Errorf("%d documents on the target shard, want 1", n)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// defaultIDFilterCapacity is used when ID_FILTER_CAPACITY is not set. The
+	// filter is opt-in: it is only correct while this API is the only writer.
+	defaultIDFilterCapacity = 0
+	// idFilterFalsePositiveRate is the target rate at the configured capacity.
+	idFilterFalsePositiveRate = 0.01
+	// counterMax is where a counter saturates; saturated counters are never
+	// decremented again, since their true count is unknown.
+	counterMax = math.MaxUint8
+)
+
+// idFilter is a counting Bloom filter over user IDs. It answers "this ID
+// definitely does not exist" without touching Mongo, and supports removal
+// so deleted users do not pile up as false positives.
+type idFilter struct {
+	mu       sync.RWMutex
+	k        uint64
+	counters []uint8
+}
+
+// newIDFilter sizes a filter for n IDs at false-positive rate p, with the
+// usual m = -n ln(p) / (ln 2)^2 and k = (m/n) ln 2.
+func newIDFilter(n uint64, p float64) *idFilter {
+	m := uint64(math.Ceil(-(float64(n) * math.Log(p)) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+	return &idFilter{k: k, counters: make([]uint8, m)}
+}
+
+// positions derives the k counter indexes of an ID by double hashing.
+func (f *idFilter) positions(id uuid.UUID) []uint64 {
+	h1 := hashBytes(id[:])
+	h2 := mix64(h1^0x9e3779b97f4a7c15) | 1
+	m := uint64(len(f.counters))
+	positions := make([]uint64, f.k)
+	for i := range positions {
+		positions[i] = (h1 + uint64(i)*h2) % m
+	}
+	return positions
+}
+
+// Add records an ID.
+func (f *idFilter) Add(id uuid.UUID) {
+	positions := f.positions(id)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, pos := range positions {
+		if f.counters[pos] < counterMax {
+			f.counters[pos]++
+		}
+	}
+}
+
+// Remove forgets an ID. It must only be called for IDs that were added,
+// otherwise it would create false negatives for other IDs.
+func (f *idFilter) Remove(id uuid.UUID) {
+	positions := f.positions(id)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, pos := range positions {
+		if c := f.counters[pos]; c > 0 && c < counterMax {
+			f.counters[pos]--
+		}
+	}
+}
+
+// MayContain reports false only if the ID was definitely never added.
+func (f *idFilter) MayContain(id uuid.UUID) bool {
+	positions := f.positions(id)
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, pos := range positions {
+		if f.counters[pos] == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// idFilterCapacityFromEnv reads the number of IDs the filter is sized for
+// from ID_FILTER_CAPACITY. 0 disables the filter.
+func idFilterCapacityFromEnv() (uint64, error) {
+	value := os.Getenv("ID_FILTER_CAPACITY")
+	if value == "" {
+		return defaultIDFilterCapacity, nil
+	}
+	capacity, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ID_FILTER_CAPACITY %q: %w", value, err)
+	}
+	return capacity, nil
+}
+
+// loadIDFilter fills the ID filter with the _id of every document on every
+// shard. It runs before the server starts, so no request can miss an ID.
+func (sm *ShardManager) loadIDFilter(ctx context.Context) error {
+	projection := options.Find().SetProjection(bson.M{"_id": 1})
+	loaded := 0
+	for index, shard := range sm.GetAllShards() {
+		cursor, err := shard.Find(ctx, bson.M{}, projection)
+		if err != nil {
+			return fmt.Errorf("error scanning shard %d for the ID filter: %w", index, err)
+		}
+		for cursor.Next(ctx) {
+			id, err := documentID(cursor.Current)
+			if err != nil {
+				log.Printf("ID filter: skipping document on shard %d: %v", index, err)
+				continue
+			}
+			sm.ids.Add(id)
+			loaded++
+		}
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			return fmt.Errorf("error scanning shard %d for the ID filter: %w", index, err)
+		}
+	}
+	log.Printf("ID filter loaded with %d IDs (%d counters, k=%d)", loaded, len(sm.ids.counters), sm.ids.k)
+	return nil
+}
+
+// MayContainID reports whether a user with this ID may exist. false means it
+// definitely does not, so the shards need not be queried. It is always true
+// when the ID filter is disabled.
+func (sm *ShardManager) MayContainID(id uuid.UUID) bool {
+	return sm.ids == nil || sm.ids.MayContain(id)
+}
+
+// recordID adds a new user's ID to the ID filter. It is called before the
+// insert, so a concurrent lookup never misses a user that was just written.
+func (sm *ShardManager) recordID(id uuid.UUID) {
+	if sm.ids != nil {
+		sm.ids.Add(id)
+	}
+}
+
+// forgetID removes a deleted user's ID from the ID filter.
+func (sm *ShardManager) forgetID(id uuid.UUID) {
+	if sm.ids != nil {
+		sm.ids.Remove(id)
+	}
+}
+
+// deleteByName deletes the users with a given name from a shard and returns
+// how many were deleted. With the ID filter enabled, their IDs are read first
+// and only those users are deleted, so each can be forgotten. If fewer were
+// deleted than read, a user changed in between and the IDs are all kept:
+// a stale ID only costs a false positive, while forgetting a live one would
+// hide it.
+func (sm *ShardManager) deleteByName(ctx context.Context, shard ShardCollection, name string) (int64, error) {
+	if sm.ids == nil {
+		result, err := shard.DeleteMany(ctx, bson.M{"name": name})
+		if err != nil {
+			return 0, err
+		}
+		return result.DeletedCount, nil
+	}
+
+	cursor, err := shard.Find(ctx, bson.M{"name": name}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var ids []uuid.UUID
+	for cursor.Next(ctx) {
+		id, err := documentID(cursor.Current)
+		if err != nil {
+			log.Printf("Delete by name: skipping document: %v", err)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	err = cursor.Err()
+	cursor.Close(ctx)
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	result, err := shard.DeleteMany(ctx, bson.M{"name": name, "_id": bson.M{"$in": ids}})
+	if err != nil {
+		return 0, err
+	}
+	if result.DeletedCount == int64(len(ids)) {
+		for _, id := range ids {
+			sm.forgetID(id)
+		}
+	}
+	return result.DeletedCount, nil
+}
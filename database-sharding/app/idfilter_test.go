@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// findOneCalls sums the FindOne calls made on every shard.
+func findOneCalls(collections []*fakeCollection) int64 {
+	var calls int64
+	for _, c := range collections {
+		calls += c.findOneCalls.Load()
+	}
+	return calls
+}
+
+func TestIDFilterSkipsShardsForUnknownIDs(t *testing.T) {
+	t.Setenv("SHARD_KEY", "")
+	t.Setenv("SHARD_ALGORITHM", "")
+	t.Setenv("ID_FILTER_CAPACITY", "1000")
+	sm, collections := connectTestManager(t, 4)
+	handler := newRouter(&APIHandler{ShardManager: sm})
+
+	alice := createUser(t, handler, "alice", "a")
+	var bobs []User
+	for i := 0; i < 3; i++ {
+		bobs = append(bobs, createUser(t, handler, "bob", "b"))
+	}
+
+	if rec := doRequest(handler, http.MethodGet, "/users/"+uuid.New().String(), ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown ID: got %d, want 404", rec.Code)
+	}
+	if calls := findOneCalls(collections); calls != 0 {
+		t.Fatalf("an ID never inserted cost %d FindOne calls, want 0", calls)
+	}
+
+	if rec := doRequest(handler, http.MethodDelete, "/users/name/bob", ""); rec.Code != http.StatusOK {
+		t.Fatalf("delete by name: got %d %s", rec.Code, rec.Body)
+	}
+	for _, bob := range bobs {
+		if sm.MayContainID(bob.ID) {
+			t.Errorf("deleted user %s is still in the ID filter", bob.ID)
+		}
+		if rec := doRequest(handler, http.MethodGet, "/users/"+bob.ID.String(), ""); rec.Code != http.StatusNotFound {
+			t.Errorf("deleted user %s: got %d, want 404", bob.ID, rec.Code)
+		}
+	}
+	if calls := findOneCalls(collections); calls != 0 {
+		t.Errorf("users deleted by name cost %d FindOne calls, want 0", calls)
+	}
+
+	if got := getUser(t, handler, alice); got != alice {
+		t.Errorf("got %+v, want %+v", got, alice)
+	}
+}
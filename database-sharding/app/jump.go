@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Shard routing algorithms, selected with SHARD_ALGORITHM.
+const (
+	algorithmRing   = "ring"   // Default: consistent hash ring, see ring.go
+	algorithmJump   = "jump"   // Jump Consistent Hash
+	algorithmModulo = "modulo" // Plain hash % N, see modulo.go
+)
+
+// shardAlgorithmFromEnv reads the routing algorithm from SHARD_ALGORITHM.
+func shardAlgorithmFromEnv() (string, error) {
+	switch value := os.Getenv("SHARD_ALGORITHM"); value {
+	case "", algorithmRing:
+		return algorithmRing, nil
+	case algorithmJump, algorithmModulo:
+		return value, nil
+	default:
+		return "", fmt.Errorf("invalid SHARD_ALGORITHM %q: must be %q, %q or %q", value, algorithmRing, algorithmJump, algorithmModulo)
+	}
+}
+
+// JumpHash is Google's Jump Consistent Hash (Lamping & Veach, 2014). It maps
+// a key to a bucket in [0, numBuckets) with no memory at all, and when
+// numBuckets grows by one only 1/numBuckets of the keys move, all of them to
+// the new bucket.
+//
+// Buckets are numbered densely, so it only supports appending shards: removing
+// a shard other than the last one would renumber, and reshuffle, the rest.
+func JumpHash(key uint64, numBuckets int) int32 {
+	var b, j int64 = -1, 0
+	for j < int64(numBuckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int32(b)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestJumpHashReferenceVectors(t *testing.T) {
+	// Values published with other implementations of the algorithm, e.g.
+	// github.com/dgryski/go-jump.
+	vectors := []struct {
+		key        uint64
+		numBuckets int
+		want       int32
+	}{
+		{1, 1, 0},
+		{42, 57, 43},
+		{0xDEAD10CC, 1, 0},
+		{0xDEAD10CC, 666, 361},
+		{256, 1024, 520},
+	}
+	for _, v := range vectors {
+		if got := JumpHash(v.key, v.numBuckets); got != v.want {
+			t.Errorf("JumpHash(%#x, %d) = %d, want %d", v.key, v.numBuckets, got, v.want)
+		}
+	}
+}
+
+func TestJumpHashIsUniformOverUUIDs(t *testing.T) {
+	ids := testUUIDs(t, 100000)
+	for _, numShards := range []int{4, 5, 8, 16} {
+		counts := make([]int, numShards)
+		for _, id := range ids {
+			// The key getShardIndex uses, variant bits included
+			counts[JumpHash(binary.BigEndian.Uint64(id[8:]), numShards)]++
+		}
+		if stat, critical := chiSquared(counts, len(ids)), chiSquared95[numShards-1]; stat > critical {
+			t.Errorf("%d shards: chi-squared %.1f over the 95%% critical value %.3f, counts %v", numShards, stat, critical, counts)
+		}
+	}
+}
+
+func TestJumpHashOnlyMovesKeysToNewBucket(t *testing.T) {
+	for _, id := range testUUIDs(t, 10000) {
+		key := binary.BigEndian.Uint64(id[8:])
+		before := JumpHash(key, 4)
+		if after := JumpHash(key, 5); after != before && after != 4 {
+			t.Fatalf("key %#x moved from bucket %d to %d, not to the new bucket 4", key, before, after)
+		}
+	}
+}
@@ -1,12 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
+// defaultDrainTimeout is how long in-flight requests get to finish on shutdown.
+const defaultDrainTimeout = 10 * time.Second
+
 func main() {
 	shardManager, err := NewShardManager()
 	if err != nil {
@@ -18,16 +27,78 @@ func main() {
 		ShardManager: shardManager,
 	}
 
+	server := &http.Server{
+		Addr:    ":8080",
+		Handler: newRouter(handler),
+	}
+
+	// Stop on Ctrl-C or 'docker stop', letting in-flight Mongo operations finish.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Println("Server started on port 8080")
+	if err := runServer(ctx, server, drainTimeout()); err != nil {
+		log.Printf("Server error: %v", err)
+	}
+	// The deferred shardManager.Close() runs only after Shutdown has returned.
+}
+
+func newRouter(handler *APIHandler) *mux.Router {
 	r := mux.NewRouter()
 
+	r.HandleFunc("/health", handler.Health).Methods("GET")
 	r.HandleFunc("/users", handler.CreateUser).Methods("POST")
+	r.HandleFunc("/users", handler.StreamUsers).Methods("GET")
+	r.HandleFunc("/users/bulk", handler.CreateUsersBulk).Methods("POST")
 	r.HandleFunc("/users/{id}", handler.GetUserByID).Methods("GET")
 	r.HandleFunc("/users/name/{name}", handler.GetUserByName).Methods("GET")
+	r.HandleFunc("/users/name/{name}/count", handler.CountUsersByName).Methods("GET")
 	r.HandleFunc("/users/{id}", handler.UpdateUser).Methods("PUT")
 	r.HandleFunc("/users/{id}", handler.DeleteUser).Methods("DELETE")
+	r.HandleFunc("/users/name/{name}", handler.DeleteUsersByName).Methods("DELETE")
+	r.HandleFunc("/debug/shard/{id}", handler.DebugShard).Methods("GET")
 
-	log.Println("Server started on port 8080")
-	if err := http.ListenAndServe(":8080", r); err != nil {
-		log.Fatalf("Failed to start the server: %v", err)
+	return r
+}
+
+// runServer serves until ctx is cancelled, then stops accepting connections
+// and waits up to drainTimeout for in-flight requests to complete.
+func runServer(ctx context.Context, server *http.Server, drainTimeout time.Duration) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down, draining in-flight requests (timeout %v)...", drainTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	log.Println("Server stopped gracefully")
+	return nil
+}
+
+// drainTimeout reads the shutdown drain timeout from SHUTDOWN_TIMEOUT (e.g. "30s").
+func drainTimeout() time.Duration {
+	value := os.Getenv("SHUTDOWN_TIMEOUT")
+	if value == "" {
+		return defaultDrainTimeout
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		log.Printf("Invalid SHUTDOWN_TIMEOUT %q, using %v", value, defaultDrainTimeout)
+		return defaultDrainTimeout
 	}
-}
\ No newline at end of file
+	return timeout
+}
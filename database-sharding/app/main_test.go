@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// freeAddr returns a local address with a port that was free a moment ago.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func TestRunServerDrainsInFlightRequestsOnSignal(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	server := &http.Server{
+		Addr: freeAddr(t),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			io.WriteString(w, "done")
+		}),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	defer stop()
+	stopped := make(chan error, 1)
+	go func() { stopped <- runServer(ctx, server, 5*time.Second) }()
+
+	type result struct {
+		body string
+		err  error
+	}
+	response := make(chan result, 1)
+	go func() {
+		// The server may not be listening yet.
+		for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+			resp, err := http.Get("http://" + server.Addr)
+			if err != nil && time.Now().Before(deadline) {
+				continue
+			}
+			if err != nil {
+				response <- result{err: err}
+				return
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			response <- result{string(body), err}
+			return
+		}
+	}()
+
+	select {
+	case <-started:
+	case r := <-response:
+		t.Fatalf("request finished before reaching the handler: %v", r.err)
+	}
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	<-ctx.Done()
+
+	select {
+	case err := <-stopped:
+		t.Fatalf("runServer returned with a request in flight: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+
+	if r := <-response; r.err != nil || r.body != "done" {
+		t.Errorf("in-flight request got %q, %v; want it to complete", r.body, r.err)
+	}
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("runServer: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("runServer did not return after the request completed")
+	}
+}
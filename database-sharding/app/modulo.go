@@ -0,0 +1,27 @@
+package main
+
+import "hash/fnv"
+
+// fnv64a is the raw FNV-1a hash, without the finalizer hashBytes applies.
+func fnv64a(data []byte) uint64 {
+	hasher := fnv.New64a()
+	hasher.Write(data)
+	return hasher.Sum64()
+}
+
+// ModuloIndex maps a hash to a shard in [0, numShards) with a plain modulo.
+//
+// When numShards is a power of two, hash % numShards is just a mask over the
+// lowest bits, and FNV's low bits depend mostly on the last input bytes. To
+// let every bit of the hash take part, the high half is XOR-folded into the
+// low half before masking. Other shard counts use the full 64-bit modulo.
+//
+// Unlike the ring and JumpHash, adding a shard changes the result for almost
+// every key, so this mode is only meant for a fixed number of shards.
+func ModuloIndex(hash uint64, numShards int) int {
+	n := uint64(numShards)
+	if n&(n-1) == 0 {
+		return int((hash ^ hash>>32) & (n - 1))
+	}
+	return int(hash % n)
+}
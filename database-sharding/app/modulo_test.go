@@ -0,0 +1,52 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// testUUIDs returns n v4 UUIDs drawn from a fixed seed, so statistical tests
+// give the same result on every run.
+func testUUIDs(t *testing.T, n int) []uuid.UUID {
+	t.Helper()
+	rng := rand.New(rand.NewSource(1))
+	ids := make([]uuid.UUID, n)
+	for i := range ids {
+		id, err := uuid.NewRandomFromReader(rng)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = id
+	}
+	return ids
+}
+
+// chiSquared is Pearson's statistic of counts against an even split.
+func chiSquared(counts []int, total int) float64 {
+	expected := float64(total) / float64(len(counts))
+	stat := 0.0
+	for _, count := range counts {
+		diff := float64(count) - expected
+		stat += diff * diff / expected
+	}
+	return stat
+}
+
+// chiSquared95 is the 95% critical value of the chi-squared distribution by
+// degrees of freedom, for the shard counts the tests use.
+var chiSquared95 = map[int]float64{3: 7.815, 4: 9.488, 7: 14.067, 15: 24.996}
+
+func TestModuloIndexIsUniformOverUUIDs(t *testing.T) {
+	ids := testUUIDs(t, 100000)
+	for _, numShards := range []int{4, 5, 8, 16} {
+		counts := make([]int, numShards)
+		for _, id := range ids {
+			counts[ModuloIndex(fnv64a(id[:]), numShards)]++
+		}
+		if stat, critical := chiSquared(counts, len(ids)), chiSquared95[numShards-1]; stat > critical {
+			t.Errorf("%d shards: chi-squared %.1f over the 95%% critical value %.3f, counts %v", numShards, stat, critical, counts)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// rebalanceLogInterval is how many scanned documents pass between progress logs.
+const rebalanceLogInterval = 1000
+
+// Rebalance scans every shard and moves each document that is not on the
+// shard GetShardForUser expects (e.g. after AddShard) to the right one.
+//
+// A move is an insert on the target followed by a delete on the source, so it
+// is safe to re-run after a partial failure: a document that was already
+// inserted on the target is simply deleted from the source. Failures on single
+// documents are logged and skipped; an error is returned if any move failed.
+func (sm *ShardManager) Rebalance(ctx context.Context) error {
+	shards := sm.GetAllShards()
+	scanned, moved, failed := 0, 0, 0
+
+	for sourceIndex, source := range shards {
+		log.Printf("Rebalance: scanning shard %d...", sourceIndex)
+
+		cursor, err := source.Find(ctx, bson.M{})
+		if err != nil {
+			return fmt.Errorf("error scanning shard %d: %w", sourceIndex, err)
+		}
+
+		for cursor.Next(ctx) {
+			scanned++
+			if scanned%rebalanceLogInterval == 0 {
+				log.Printf("Rebalance: %d documents scanned, %d moved, %d failed", scanned, moved, failed)
+			}
+
+			doc := make(bson.Raw, len(cursor.Current))
+			copy(doc, cursor.Current)
+
+			id, err := documentID(doc)
+			if err != nil {
+				log.Printf("Rebalance: skipping document on shard %d: %v", sourceIndex, err)
+				failed++
+				continue
+			}
+
+			// The name is only needed when sharding by name
+			name, _ := doc.Lookup("name").StringValueOK()
+			targetIndex := sm.shardIndexForUser(User{ID: id, Name: name})
+			if targetIndex == sourceIndex {
+				continue
+			}
+
+			if err := moveDocument(ctx, id, doc, source, shards[targetIndex]); err != nil {
+				log.Printf("Rebalance: error moving %s from shard %d to %d: %v", id, sourceIndex, targetIndex, err)
+				failed++
+				continue
+			}
+			moved++
+		}
+
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			return fmt.Errorf("error iterating shard %d: %w", sourceIndex, err)
+		}
+	}
+
+	log.Printf("Rebalance complete: %d documents scanned, %d moved, %d failed", scanned, moved, failed)
+	if failed > 0 {
+		return fmt.Errorf("rebalance finished with %d failed documents; re-run to retry", failed)
+	}
+	return nil
+}
+
+// VerifyPlacement is the read-only companion to Rebalance: it scans every
+// shard and returns the IDs of the documents that are not on the shard
+// GetShardForUser expects, without moving anything. An empty result means a
+// Rebalance would have nothing to do.
+//
+// Documents whose _id cannot be read are logged and skipped; the misplaced IDs
+// found are still returned, along with an error counting the skipped ones.
+func (sm *ShardManager) VerifyPlacement(ctx context.Context) ([]uuid.UUID, error) {
+	shards := sm.GetAllShards()
+	// Placement only depends on the ID and, when sharding by name, the name
+	projection := options.Find().SetProjection(bson.M{"_id": 1, "name": 1})
+	var misplaced []uuid.UUID
+	scanned, skipped := 0, 0
+
+	for shardIndex, shard := range shards {
+		cursor, err := shard.Find(ctx, bson.M{}, projection)
+		if err != nil {
+			return misplaced, fmt.Errorf("error scanning shard %d: %w", shardIndex, err)
+		}
+
+		for cursor.Next(ctx) {
+			scanned++
+			id, err := documentID(cursor.Current)
+			if err != nil {
+				log.Printf("VerifyPlacement: skipping document on shard %d: %v", shardIndex, err)
+				skipped++
+				continue
+			}
+
+			name, _ := cursor.Current.Lookup("name").StringValueOK()
+			if sm.shardIndexForUser(User{ID: id, Name: name}) != shardIndex {
+				misplaced = append(misplaced, id)
+			}
+		}
+
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			return misplaced, fmt.Errorf("error iterating shard %d: %w", shardIndex, err)
+		}
+	}
+
+	log.Printf("VerifyPlacement complete: %d documents scanned, %d misplaced, %d skipped", scanned, len(misplaced), skipped)
+	if skipped > 0 {
+		return misplaced, fmt.Errorf("verification skipped %d documents without a readable _id", skipped)
+	}
+	return misplaced, nil
+}
+
+// documentID extracts the UUID stored in a raw document's _id field.
+func documentID(doc bson.Raw) (uuid.UUID, error) {
+	value, err := doc.LookupErr("_id")
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("document has no _id: %w", err)
+	}
+	_, data, ok := value.BinaryOK()
+	if !ok {
+		return uuid.Nil, fmt.Errorf("_id is not a binary UUID")
+	}
+	return uuid.FromBytes(data)
+}
+
+// updateAndMove applies fields to the user with the given ID and, if the
+// result belongs to another shard (e.g. after a rename when sharding by
+// name), moves it there, so lookups keep finding it. It reports false if no
+// shard holds the user.
+func (sm *ShardManager) updateAndMove(ctx context.Context, id uuid.UUID, fields bson.M) (bool, error) {
+	shards := sm.GetAllShards()
+	sourceIndex := -1
+	var user User
+	for i, shard := range shards {
+		err := shard.FindOne(ctx, bson.M{"_id": id}).Decode(&user)
+		if err == nil {
+			sourceIndex = i
+			break
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return false, fmt.Errorf("error looking up shard %d: %w", i, err)
+		}
+	}
+	if sourceIndex < 0 {
+		return false, nil
+	}
+
+	if name, ok := fields["name"].(string); ok {
+		user.Name = name
+	}
+	if data, ok := fields["data"].(string); ok {
+		user.Data = data
+	}
+	targetIndex := sm.shardIndexForUser(user)
+	if targetIndex == sourceIndex {
+		_, err := shards[sourceIndex].UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": fields})
+		return true, err
+	}
+
+	// Unlike in Rebalance, a copy already on the target predates this update,
+	// e.g. left by an interrupted move, so it is overwritten rather than kept.
+	_, err := shards[targetIndex].ReplaceOne(ctx, bson.M{"_id": id}, user, options.Replace().SetUpsert(true))
+	if err != nil {
+		return true, fmt.Errorf("error moving from shard %d to %d: replace on target: %w", sourceIndex, targetIndex, err)
+	}
+	if _, err := shards[sourceIndex].DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		return true, fmt.Errorf("error moving from shard %d to %d: delete on source: %w", sourceIndex, targetIndex, err)
+	}
+	return true, nil
+}
+
+// moveDocument copies a document to the target shard and removes it from the source.
+// A duplicate key on the target means a previous run already copied it.
+func moveDocument(ctx context.Context, id uuid.UUID, doc bson.Raw, source, target ShardCollection) error {
+	if _, err := target.InsertOne(ctx, doc); err != nil && !mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("insert on target: %w", err)
+	}
+	if _, err := source.DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		return fmt.Errorf("delete on source: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// acceptsCSV reports whether the request's Accept header prefers text/csv,
+// i.e. lists it with a higher quality than application/json.
+func acceptsCSV(r *http.Request) bool {
+	var csvQuality, jsonQuality float64
+	for _, mediaRange := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil {
+			quality = q
+		}
+		switch mediaType {
+		case "text/csv":
+			csvQuality = max(csvQuality, quality)
+		case "application/json":
+			jsonQuality = max(jsonQuality, quality)
+		}
+	}
+	return csvQuality > 0 && csvQuality > jsonQuality
+}
+
+// errorResponse is the JSON body of errors reported by writeJSONError.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// writeJSONError answers with the given status and {"error": message}.
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: message})
+}
+
+// writeUsers renders a list of users in the format the client asked for:
+// CSV rows with an id,name,data header for "Accept: text/csv", JSON otherwise.
+func writeUsers(w http.ResponseWriter, r *http.Request, users []User) {
+	if !acceptsCSV(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(users)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"id", "name", "data"})
+	for _, user := range users {
+		writer.Write([]string{user.ID.String(), user.Name, user.Data})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Printf("Error writing CSV: %v", err)
+	}
+}
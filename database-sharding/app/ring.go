@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// vnodesPerShard is how many points each shard gets on the hash ring.
+// More points give a more uniform distribution at the cost of memory.
+const vnodesPerShard = 200
+
+// hashRing is a routing-only version of the ring in consistent-hashing/:
+// it maps a 64-bit key hash to a shard index, and adding a shard only takes
+// over ~1/N of the key space instead of reshuffling everything.
+type hashRing struct {
+	ring   []uint64       // The sorted positions of all VNodes
+	owners map[uint64]int // Maps a VNode position to its shard index
+}
+
+func newHashRing() *hashRing {
+	return &hashRing{
+		ring:   make([]uint64, 0),
+		owners: make(map[uint64]int),
+	}
+}
+
+// hashBytes is the FNV-1a hash used for both keys and VNodes.
+// FNV's high bits barely change between similar inputs like "shard-0#1" and
+// "shard-0#2", which clumps VNodes together, so the result goes through a
+// finalizer (MurmurHash3's fmix64) to spread it over the whole ring.
+func hashBytes(data []byte) uint64 {
+	hasher := fnv.New64a()
+	hasher.Write(data)
+	return mix64(hasher.Sum64())
+}
+
+// mix64 is MurmurHash3's 64-bit finalizer.
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// add places the VNodes of a shard on the ring.
+func (r *hashRing) add(shardIndex int) {
+	for i := 0; i < vnodesPerShard; i++ {
+		hash := hashBytes([]byte(fmt.Sprintf("shard-%d#%d", shardIndex, i)))
+		if _, taken := r.owners[hash]; taken {
+			// A collision on a 64-bit ring is practically impossible; keep the first owner.
+			continue
+		}
+		r.owners[hash] = shardIndex
+		r.ring = append(r.ring, hash)
+	}
+	sort.Slice(r.ring, func(i, j int) bool { return r.ring[i] < r.ring[j] })
+}
+
+// get returns the shard index owning a key hash: the first VNode clockwise.
+func (r *hashRing) get(hash uint64) int {
+	idx := sort.Search(len(r.ring), func(i int) bool {
+		return r.ring[i] >= hash
+	})
+	// Past the last VNode, the key wraps around to the first one.
+	if idx == len(r.ring) {
+		idx = 0
+	}
+	return r.owners[r.ring[idx]]
+}
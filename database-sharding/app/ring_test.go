@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestAddShardMovesFewIDs(t *testing.T) {
+	sm, _ := newTestManager(t, 4, "", "")
+	ids := testUUIDs(t, 10000)
+	before := make([]int, len(ids))
+	for i, id := range ids {
+		before[i] = sm.ShardIndexForID(id)
+	}
+
+	if err := sm.AddShard(shardURI(4)); err != nil {
+		t.Fatal(err)
+	}
+
+	moved := 0
+	for i, id := range ids {
+		after := sm.ShardIndexForID(id)
+		if after == before[i] {
+			continue
+		}
+		if after != 4 {
+			t.Fatalf("%s moved from shard %d to %d, not to the new shard", id, before[i], after)
+		}
+		moved++
+	}
+	// Consistent hashing moves ~1/5 of the IDs; modulo would move ~4/5.
+	if fraction := float64(moved) / float64(len(ids)); fraction >= 0.3 || moved == 0 {
+		t.Errorf("%d of %d IDs (%.1f%%) changed shard, want some but under 30%%", moved, len(ids), 100*fraction)
+	}
+}
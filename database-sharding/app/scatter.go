@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ScatterGatherError reports that some shards of a scatter-gather failed.
+// It wraps the first error seen.
+type ScatterGatherError struct {
+	Failed int // Number of shards whose call failed
+	Shards int // Number of shards queried
+	Err    error
+}
+
+func (e *ScatterGatherError) Error() string {
+	return fmt.Sprintf("%d of %d shards failed: %v", e.Failed, e.Shards, e.Err)
+}
+
+func (e *ScatterGatherError) Unwrap() error {
+	return e.Err
+}
+
+// ScatterGather runs fn on every shard concurrently and concatenates the
+// results of the shards that succeeded. If any call failed, it also returns
+// a *ScatterGatherError wrapping the first error, so callers can still use
+// the partial results. fn is expected to honor the request's context, since
+// ScatterGather waits for every call to return.
+func ScatterGather[T any](shards []ShardCollection, fn func(ShardCollection) ([]T, error)) ([]T, error) {
+	var results []T
+	var firstErr error
+	failed := 0
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	wg.Add(len(shards))
+	for _, shard := range shards {
+		go func(s ShardCollection) {
+			defer wg.Done()
+			shardResults, err := fn(s)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed++
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			results = append(results, shardResults...)
+		}(shard)
+	}
+	wg.Wait()
+
+	if failed > 0 {
+		return results, &ScatterGatherError{Failed: failed, Shards: len(shards), Err: firstErr}
+	}
+	return results, nil
+}
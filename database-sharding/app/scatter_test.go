@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestScatterGatherMergesResults(t *testing.T) {
+	_, collections := newTestManager(t, 3, "", "")
+	shards := make([]ShardCollection, len(collections))
+	index := make(map[ShardCollection]int)
+	for i, c := range collections {
+		shards[i] = c
+		index[c] = i
+	}
+
+	results, err := ScatterGather(shards, func(s ShardCollection) ([]int, error) {
+		return []int{10 * index[s], 10*index[s] + 1}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Ints(results)
+	if want := []int{0, 1, 10, 11, 20, 21}; fmt.Sprint(results) != fmt.Sprint(want) {
+		t.Errorf("merged results %v, want %v", results, want)
+	}
+
+	failure := errors.New("shard 1 is down")
+	results, err = ScatterGather(shards, func(s ShardCollection) ([]int, error) {
+		if index[s] == 1 {
+			return []int{-1}, failure
+		}
+		return []int{index[s]}, nil
+	})
+	var sgErr *ScatterGatherError
+	if !errors.As(err, &sgErr) || !errors.Is(err, failure) {
+		t.Fatalf("got error %v, want a ScatterGatherError wrapping the shard's error", err)
+	}
+	if sgErr.Failed != 1 || sgErr.Shards != 3 {
+		t.Errorf("error reports %d of %d shards failed, want 1 of 3", sgErr.Failed, sgErr.Shards)
+	}
+	sort.Ints(results)
+	if fmt.Sprint(results) != "[0 2]" {
+		t.Errorf("partial results %v, want those of shards 0 and 2", results)
+	}
+}
@@ -2,82 +2,410 @@ package main
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
-	"hash/fnv"
 	"log"
-	"time"
+	"os"
+	"strconv"
+	"sync"
 
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
 const (
-	numShards = 4
+	// defaultNumShards is used when NUM_SHARDS is not set.
+	defaultNumShards = 4
+	// defaultDatabase and defaultCollection are used when MONGO_DATABASE and
+	// MONGO_COLLECTION are not set.
+	defaultDatabase   = "userdb"
+	defaultCollection = "users"
 )
 
+// Shard keys: the user field whose hash picks the shard.
+const (
+	shardKeyID   = "id"   // Default: lookups by ID hit one shard, by name all of them
+	shardKeyName = "name" // Lookups by name hit one shard, by ID all of them
+)
+
+// shardKeyFromEnv reads the shard key from SHARD_KEY.
+func shardKeyFromEnv() (string, error) {
+	switch value := os.Getenv("SHARD_KEY"); value {
+	case "", shardKeyID:
+		return shardKeyID, nil
+	case shardKeyName:
+		return shardKeyName, nil
+	default:
+		return "", fmt.Errorf("invalid SHARD_KEY %q: must be %q or %q", value, shardKeyID, shardKeyName)
+	}
+}
+
+// namespaceFromEnv reads the database and collection names from
+// MONGO_DATABASE and MONGO_COLLECTION, defaulting to "userdb" and "users".
+func namespaceFromEnv() (database, collection string) {
+	database, collection = os.Getenv("MONGO_DATABASE"), os.Getenv("MONGO_COLLECTION")
+	if database == "" {
+		database = defaultDatabase
+	}
+	if collection == "" {
+		collection = defaultCollection
+	}
+	return database, collection
+}
+
+// shardCountFromEnv reads the number of shards from NUM_SHARDS.
+func shardCountFromEnv() (int, error) {
+	value := os.Getenv("NUM_SHARDS")
+	if value == "" {
+		return defaultNumShards, nil
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid NUM_SHARDS %q: %w", value, err)
+	}
+	if count < 1 {
+		return 0, fmt.Errorf("NUM_SHARDS must be at least 1, got %d", count)
+	}
+	return count, nil
+}
+
+// ShardCollection is the subset of *mongo.Collection the API uses on a shard.
+// Depending on it instead of the concrete type lets handlers run against a
+// fake collection, without a live MongoDB.
+type ShardCollection interface {
+	InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+	InsertMany(ctx context.Context, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error)
+	FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
+	UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	ReplaceOne(ctx context.Context, filter interface{}, replacement interface{}, opts ...*options.ReplaceOptions) (*mongo.UpdateResult, error)
+	DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+	DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+	Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error)
+	CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error)
+}
+
+// ShardClient is a connection to a single shard.
+type ShardClient interface {
+	Ping(ctx context.Context, rp *readpref.ReadPref) error
+	Disconnect(ctx context.Context) error
+	Collection(database, collection string, opts ...*options.CollectionOptions) ShardCollection
+}
+
 // ShardManager manages the connections with all MongoDB shards
 type ShardManager struct {
-	Clients []*mongo.Client
-	Shards  []*mongo.Collection
+	mu      sync.RWMutex // Guards the fields below, since shards can be added at runtime
+	Clients []ShardClient
+	Shards  []ShardCollection
+	ring    *hashRing
+
+	// scatterShards are the handles of the scatter-gather queries, by shard
+	// index: Shards themselves unless a read preference is configured.
+	scatterShards []ShardCollection
+
+	database        string // Namespace of the sharded collection on every shard
+	collection      string
+	collectionOpts  *options.CollectionOptions // Write concern, for Shards
+	scatterOpts     *options.CollectionOptions // Adds the read preference, for scatterShards; nil when unset
+	shardKey        string
+	algorithm       string
+	ids             *idFilter // nil when the ID filter is disabled
+	connector       ShardConnector
+	connectAttempts int
 }
 
-// NewShardManager creates and tests the connections with all MongoDB shards
+// NewShardManager creates and tests the connections with all MongoDB shards.
+// The number of shards comes from the NUM_SHARDS environment variable, the
+// field users are sharded by from SHARD_KEY, the routing algorithm from
+// SHARD_ALGORITHM, the ID filter size from ID_FILTER_CAPACITY, the
+// connection attempts per shard from SHARD_CONNECT_ATTEMPTS, and the pool
+// sizes from MONGO_MAX_POOL_SIZE / MONGO_MIN_POOL_SIZE.
 func NewShardManager() (*ShardManager, error) {
+	connector, err := newMongoConnectorFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return NewShardManagerWithConnector(connector)
+}
+
+// NewShardManagerWithConnector is like NewShardManager, but opens the shard
+// connections through the given connector. The database and collection
+// names come from MONGO_DATABASE and MONGO_COLLECTION.
+func NewShardManagerWithConnector(connector ShardConnector) (*ShardManager, error) {
+	database, collection := namespaceFromEnv()
+	return NewShardManagerForNamespace(connector, database, collection)
+}
+
+// NewShardManagerForNamespace is like NewShardManagerWithConnector, but
+// shards the given collection of the given database, so several entity types
+// can each have their own manager. The write concern and read preference of
+// the collections come from the environment, see collectionOptionsFromEnv.
+func NewShardManagerForNamespace(connector ShardConnector, database, collection string) (*ShardManager, error) {
+	if database == "" || collection == "" {
+		return nil, fmt.Errorf("database and collection names must not be empty")
+	}
+	collectionOpts, scatterOpts, err := collectionOptionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	numShards, err := shardCountFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	connectAttempts, err := connectAttemptsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	shardKey, err := shardKeyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	algorithm, err := shardAlgorithmFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	idFilterCapacity, err := idFilterCapacityFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
 	manager := &ShardManager{
-		Clients: make([]*mongo.Client, numShards),
-		Shards:  make([]*mongo.Collection, numShards),
+		Clients:         make([]ShardClient, 0, numShards),
+		Shards:          make([]ShardCollection, 0, numShards),
+		ring:            newHashRing(),
+		database:        database,
+		collection:      collection,
+		collectionOpts:  collectionOpts,
+		scatterOpts:     scatterOpts,
+		shardKey:        shardKey,
+		algorithm:       algorithm,
+		connector:       connector,
+		connectAttempts: connectAttempts,
 	}
 
 	for i := 0; i < numShards; i++ {
 		// The service name in Docker Compose will be 'mongo-shard-0', 'mongo-shard-1', etc.
 		uri := fmt.Sprintf("mongodb://mongo-shard-%d:27017", i)
-		client, err := mongo.NewClient(options.Client().ApplyURI(uri))
-		if err != nil {
-			return nil, fmt.Errorf("error creating client for shard %d: %w", i, err)
+		if err := manager.AddShard(uri); err != nil {
+			manager.Close()
+			return nil, err
 		}
+	}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-
-		if err := client.Connect(ctx); err != nil {
-			return nil, fmt.Errorf("error connecting to shard %d: %w", i, err)
+	if idFilterCapacity > 0 {
+		manager.ids = newIDFilter(idFilterCapacity, idFilterFalsePositiveRate)
+		if err := manager.loadIDFilter(context.Background()); err != nil {
+			manager.Close()
+			return nil, err
 		}
+	}
 
-		// Test the connection
-		if err := client.Ping(ctx, nil); err != nil {
-			return nil, fmt.Errorf("ping failed for shard %d: %w", i, err)
-		}
+	return manager, nil
+}
 
-		log.Printf("Connected successfully to Shard %d", i)
-		manager.Clients[i] = client
-		manager.Shards[i] = client.Database("userdb").Collection("users")
+// AddShard connects a new shard and places it on the hash ring.
+// Thanks to consistent hashing, only ~1/N of the IDs are routed to the new
+// shard; documents already stored there are NOT migrated by this call.
+func (sm *ShardManager) AddShard(uri string) error {
+	// Connect before taking the lock, so retries don't block request routing.
+	client, err := connectWithRetry(sm.connector, uri, sm.connectAttempts)
+	if err != nil {
+		return err
 	}
 
-	return manager, nil
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	index := len(sm.Shards)
+	log.Printf("Connected successfully to Shard %d", index)
+	sm.Clients = append(sm.Clients, client)
+	shard := client.Collection(sm.database, sm.collection, sm.collectionOpts)
+	scatterShard := shard
+	if sm.scatterOpts != nil {
+		scatterShard = client.Collection(sm.database, sm.collection, sm.scatterOpts)
+	}
+	sm.Shards = append(sm.Shards, shard)
+	sm.scatterShards = append(sm.scatterShards, scatterShard)
+	sm.ring.add(index)
+	return nil
 }
 
 // getShardIndex calculates in which shard a given ID should be.
-func getShardIndex(id uuid.UUID) int {
-	// We use an FNV-1a hasher, which is fast and offers good distribution.
-	hasher := fnv.New64a()
-	hasher.Write(id[:])
-	hash := hasher.Sum64()
+// It is the shard of the ID's 16 raw bytes as a key, so IDs keep the shards
+// they had before arbitrary keys were supported.
+func (sm *ShardManager) getShardIndex(id uuid.UUID) int {
+	if sm.algorithm == algorithmJump {
+		// Bytes 8-15 of a v4 UUID are random except for the two variant bits
+		// at the top of byte 8, which are always 10. JumpHash's multiply
+		// carries the 62 random bits below them into the high bits it reads,
+		// so they are used as the key without hashing.
+		return int(JumpHash(binary.BigEndian.Uint64(id[8:]), len(sm.Shards)))
+	}
+	return sm.getShardIndexForKey(string(id[:]))
+}
+
+// getShardIndexForKey calculates in which shard an arbitrary string key should be.
+func (sm *ShardManager) getShardIndexForKey(key string) int {
+	if sm.algorithm == algorithmJump {
+		return int(JumpHash(hashBytes([]byte(key)), len(sm.Shards)))
+	}
+	if sm.algorithm == algorithmModulo {
+		return ModuloIndex(fnv64a([]byte(key)), len(sm.Shards))
+	}
+	// We use an FNV-1a hash, which is fast and offers good distribution,
+	// and look up the owner of that position on the consistent hash ring.
+	return sm.ring.get(hashBytes([]byte(key)))
+}
+
+// GetShardForKey returns the shard owning an arbitrary string key, for
+// entities that are not keyed by a UUID.
+func (sm *ShardManager) GetShardForKey(key string) ShardCollection {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
 
-	// The modulo operator gives us the shard index (0, 1, 2 or 3).
-	return int(hash % uint64(numShards))
+	return sm.Shards[sm.getShardIndexForKey(key)]
 }
 
-func (sm *ShardManager) GetShardForID(id uuid.UUID) *mongo.Collection {
-	index := getShardIndex(id)
+func (sm *ShardManager) GetShardForID(id uuid.UUID) ShardCollection {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	index := sm.getShardIndex(id)
 	return sm.Shards[index]
 }
 
-func (sm *ShardManager) GetAllShards() []*mongo.Collection {
-	return sm.Shards
+// ShardIndexForID returns the index of the shard GetShardForID picks for id.
+func (sm *ShardManager) ShardIndexForID(id uuid.UUID) int {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	return sm.getShardIndex(id)
+}
+
+// getShardIndexForName calculates in which shard the users with a given name
+// are when sharding by name.
+func (sm *ShardManager) getShardIndexForName(name string) int {
+	return sm.getShardIndexForKey(name)
+}
+
+// GetShardForName returns the shard owning a name on the ring. It is where
+// the users with that name live when SHARD_KEY is "name".
+func (sm *ShardManager) GetShardForName(name string) ShardCollection {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	return sm.Shards[sm.getShardIndexForName(name)]
+}
+
+// getShardIndexForUser returns the shard a user belongs to under the configured shard key.
+func (sm *ShardManager) getShardIndexForUser(user User) int {
+	if sm.shardKey == shardKeyName {
+		return sm.getShardIndexForName(user.Name)
+	}
+	return sm.getShardIndex(user.ID)
+}
+
+// shardIndexForUser is the locked version of getShardIndexForUser.
+func (sm *ShardManager) shardIndexForUser(user User) int {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.getShardIndexForUser(user)
+}
+
+// GetShardForUser returns the shard a new user must be written to.
+func (sm *ShardManager) GetShardForUser(user User) ShardCollection {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.Shards[sm.getShardIndexForUser(user)]
+}
+
+// ShardsForID returns the shards that may hold the user with a given ID:
+// just its shard when sharding by ID, every shard when sharding by name.
+func (sm *ShardManager) ShardsForID(id uuid.UUID) []ShardCollection {
+	if sm.shardKey == shardKeyName {
+		return sm.GetAllShards()
+	}
+	return []ShardCollection{sm.GetShardForID(id)}
+}
+
+// ShardsForName returns the shards that may hold users with a given name:
+// just its shard when sharding by name, every shard when sharding by ID.
+func (sm *ShardManager) ShardsForName(name string) []ShardCollection {
+	if sm.shardKey == shardKeyName {
+		return []ShardCollection{sm.GetShardForName(name)}
+	}
+	return sm.GetAllShards()
+}
+
+func (sm *ShardManager) GetAllShards() []ShardCollection {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	shards := make([]ShardCollection, len(sm.Shards))
+	copy(shards, sm.Shards)
+	return shards
+}
+
+// ScatterShardsForName is ShardsForName for the read-only queries by name,
+// through the handles carrying the configured read preference.
+func (sm *ShardManager) ScatterShardsForName(name string) []ShardCollection {
+	if sm.shardKey == shardKeyName {
+		sm.mu.RLock()
+		defer sm.mu.RUnlock()
+		return []ShardCollection{sm.scatterShards[sm.getShardIndexForName(name)]}
+	}
+	return sm.GetAllScatterShards()
+}
+
+// GetAllScatterShards is GetAllShards for read-only scatter-gather queries,
+// through the handles carrying the configured read preference.
+func (sm *ShardManager) GetAllScatterShards() []ShardCollection {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	shards := make([]ShardCollection, len(sm.scatterShards))
+	copy(shards, sm.scatterShards)
+	return shards
+}
+
+// ShardStatus is the result of pinging a single shard.
+type ShardStatus struct {
+	Index int    `json:"index"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// PingAll pings every shard in parallel and reports which ones answered.
+// The caller bounds the whole check through ctx.
+func (sm *ShardManager) PingAll(ctx context.Context) []ShardStatus {
+	sm.mu.RLock()
+	clients := make([]ShardClient, len(sm.Clients))
+	copy(clients, sm.Clients)
+	sm.mu.RUnlock()
+
+	statuses := make([]ShardStatus, len(clients))
+	var wg sync.WaitGroup
+	wg.Add(len(clients))
+	for i, client := range clients {
+		go func(i int, client ShardClient) {
+			defer wg.Done()
+			statuses[i] = ShardStatus{Index: i, OK: true}
+			if err := client.Ping(ctx, nil); err != nil {
+				statuses[i].OK = false
+				statuses[i].Error = err.Error()
+			}
+		}(i, client)
+	}
+	wg.Wait()
+
+	return statuses
 }
 
 func (sm *ShardManager) Close() {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
 	for i, client := range sm.Clients {
 		if client != nil {
 			if err := client.Disconnect(context.Background()); err != nil {
@@ -0,0 +1,576 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// fakeCollection is an in-memory ShardCollection. It understands the filters
+// the API uses: empty, or equality on top-level fields such as _id and name.
+type fakeCollection struct {
+	mu   sync.Mutex
+	docs []bson.Raw
+
+	err   error         // If set, every operation fails with it
+	delay time.Duration // If set, every operation waits this long or until ctx is done
+
+	findOneCalls atomic.Int64
+	findCalls    atomic.Int64
+}
+
+// wait applies the injected delay and error.
+func (c *fakeCollection) wait(ctx context.Context) error {
+	if c.delay > 0 {
+		select {
+		case <-time.After(c.delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return c.err
+}
+
+// matches reports whether doc has every field of filter with an equal value,
+// or one of the values of an {"$in": [...]} condition.
+func matches(doc bson.Raw, filter interface{}) bool {
+	fields, _ := filter.(bson.M)
+	for key, want := range fields {
+		got, err := doc.LookupErr(key)
+		if err != nil {
+			return false
+		}
+		condition, isCondition := want.(bson.M)
+		if !isCondition {
+			if !equalValue(got, want) {
+				return false
+			}
+			continue
+		}
+		in := reflect.ValueOf(condition["$in"])
+		found := false
+		for i := 0; in.Kind() == reflect.Slice && i < in.Len() && !found; i++ {
+			found = equalValue(got, in.Index(i).Interface())
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// equalValue reports whether got is want once encoded to BSON.
+func equalValue(got bson.RawValue, want interface{}) bool {
+	encoded, err := bson.Marshal(bson.M{"v": want})
+	if err != nil {
+		return false
+	}
+	wantValue := bson.Raw(encoded).Lookup("v")
+	return got.Type == wantValue.Type && bytes.Equal(got.Value, wantValue.Value)
+}
+
+// insert stores doc, rejecting a duplicate _id like a unique index would.
+// The caller holds the lock.
+func (c *fakeCollection) insert(document interface{}) (interface{}, error) {
+	doc, err := bson.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	id := bson.Raw(doc).Lookup("_id")
+	for _, existing := range c.docs {
+		if existingID := existing.Lookup("_id"); existingID.Type == id.Type && bytes.Equal(existingID.Value, id.Value) {
+			return nil, mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: "E11000 duplicate key error"}}}
+		}
+	}
+	c.docs = append(c.docs, doc)
+	return id, nil
+}
+
+func (c *fakeCollection) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id, err := c.insert(document)
+	if err != nil {
+		return nil, err
+	}
+	return &mongo.InsertOneResult{InsertedID: id}, nil
+}
+
+func (c *fakeCollection) InsertMany(ctx context.Context, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := &mongo.InsertManyResult{}
+	for _, document := range documents {
+		id, err := c.insert(document)
+		if err != nil {
+			return result, err
+		}
+		result.InsertedIDs = append(result.InsertedIDs, id)
+	}
+	return result, nil
+}
+
+func (c *fakeCollection) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	c.findOneCalls.Add(1)
+	if err := c.wait(ctx); err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, doc := range c.docs {
+		if matches(doc, filter) {
+			return mongo.NewSingleResultFromDocument(doc, nil, nil)
+		}
+	}
+	return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+}
+
+func (c *fakeCollection) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	set, _ := update.(bson.M)["$set"].(bson.M)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, doc := range c.docs {
+		if !matches(doc, filter) {
+			continue
+		}
+		var fields bson.M
+		if err := bson.Unmarshal(doc, &fields); err != nil {
+			return nil, err
+		}
+		for key, value := range set {
+			fields[key] = value
+		}
+		updated, err := bson.Marshal(fields)
+		if err != nil {
+			return nil, err
+		}
+		c.docs[i] = updated
+		return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+	}
+	return &mongo.UpdateResult{}, nil
+}
+
+func (c *fakeCollection) ReplaceOne(ctx context.Context, filter interface{}, replacement interface{}, opts ...*options.ReplaceOptions) (*mongo.UpdateResult, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	doc, err := bson.Marshal(replacement)
+	if err != nil {
+		return nil, err
+	}
+	upsert := false
+	for _, opt := range opts {
+		if opt != nil && opt.Upsert != nil {
+			upsert = *opt.Upsert
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, existing := range c.docs {
+		if matches(existing, filter) {
+			c.docs[i] = doc
+			return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+		}
+	}
+	if !upsert {
+		return &mongo.UpdateResult{}, nil
+	}
+	id, err := c.insert(replacement)
+	if err != nil {
+		return nil, err
+	}
+	return &mongo.UpdateResult{UpsertedCount: 1, UpsertedID: id}, nil
+}
+
+// remove deletes up to limit matching documents (all of them if limit < 0)
+// and returns how many it deleted.
+func (c *fakeCollection) remove(filter interface{}, limit int) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var deleted int64
+	kept := c.docs[:0]
+	for _, doc := range c.docs {
+		if (limit < 0 || deleted < int64(limit)) && matches(doc, filter) {
+			deleted++
+			continue
+		}
+		kept = append(kept, doc)
+	}
+	c.docs = kept
+	return deleted
+}
+
+func (c *fakeCollection) DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	return &mongo.DeleteResult{DeletedCount: c.remove(filter, 1)}, nil
+}
+
+func (c *fakeCollection) DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	return &mongo.DeleteResult{DeletedCount: c.remove(filter, -1)}, nil
+}
+
+func (c *fakeCollection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	c.findCalls.Add(1)
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	var limit int64
+	for _, opt := range opts {
+		if opt != nil && opt.Limit != nil {
+			limit = *opt.Limit
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var found []interface{}
+	for _, doc := range c.docs {
+		if limit > 0 && int64(len(found)) == limit {
+			break
+		}
+		if matches(doc, filter) {
+			found = append(found, doc)
+		}
+	}
+	return mongo.NewCursorFromDocuments(found, nil, nil)
+}
+
+func (c *fakeCollection) CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	if err := c.wait(ctx); err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var count int64
+	for _, doc := range c.docs {
+		if matches(doc, filter) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// len returns the number of stored documents.
+func (c *fakeCollection) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.docs)
+}
+
+// fakeClient is a ShardClient handing out one fakeCollection per namespace.
+// Handles opened with a read preference are readPrefCollections over it.
+type fakeClient struct {
+	pingErr error
+
+	mu             sync.Mutex
+	collections    map[string]*fakeCollection              // By "database.collection"
+	collectionOpts map[string][]*options.CollectionOptions // The options of every handle opened on each namespace
+	readPrefs      map[string]*readPrefCollection          // The latest handle with a read preference, by namespace
+}
+
+// readPrefCollection is a handle on a fakeCollection opened with a read
+// preference. It counts the reads made through it.
+type readPrefCollection struct {
+	*fakeCollection
+	reads atomic.Int64
+}
+
+func (c *readPrefCollection) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	c.reads.Add(1)
+	return c.fakeCollection.FindOne(ctx, filter, opts...)
+}
+
+func (c *readPrefCollection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	c.reads.Add(1)
+	return c.fakeCollection.Find(ctx, filter, opts...)
+}
+
+func (c *readPrefCollection) CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	c.reads.Add(1)
+	return c.fakeCollection.CountDocuments(ctx, filter, opts...)
+}
+
+func (c *fakeClient) Ping(ctx context.Context, rp *readpref.ReadPref) error { return c.pingErr }
+
+func (c *fakeClient) Disconnect(ctx context.Context) error { return nil }
+
+func (c *fakeClient) Collection(database, collection string, opts ...*options.CollectionOptions) ShardCollection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	namespace := database + "." + collection
+	if c.collections[namespace] == nil {
+		c.collections[namespace] = &fakeCollection{}
+	}
+	if len(opts) == 0 || opts[0] == nil {
+		return c.collections[namespace]
+	}
+	c.collectionOpts[namespace] = append(c.collectionOpts[namespace], opts[0])
+	if opts[0].ReadPreference == nil {
+		return c.collections[namespace]
+	}
+	c.readPrefs[namespace] = &readPrefCollection{fakeCollection: c.collections[namespace]}
+	return c.readPrefs[namespace]
+}
+
+// fakeConnector connects to fake shards, creating one per URI on first use.
+// Each URI fails its first failures[uri] attempts.
+type fakeConnector struct {
+	mu       sync.Mutex
+	clients  map[string]*fakeClient
+	failures map[string]int
+	attempts map[string]int
+}
+
+func newFakeConnector() *fakeConnector {
+	return &fakeConnector{
+		clients:  make(map[string]*fakeClient),
+		failures: make(map[string]int),
+		attempts: make(map[string]int),
+	}
+}
+
+func (c *fakeConnector) Connect(ctx context.Context, uri string) (ShardClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.attempts[uri]++
+	if c.attempts[uri] <= c.failures[uri] {
+		return nil, fmt.Errorf("connection to %s refused", uri)
+	}
+	if c.clients[uri] == nil {
+		c.clients[uri] = &fakeClient{
+			collections:    make(map[string]*fakeCollection),
+			collectionOpts: make(map[string][]*options.CollectionOptions),
+			readPrefs:      make(map[string]*readPrefCollection),
+		}
+	}
+	return c.clients[uri], nil
+}
+
+// shardURI is the URI NewShardManager uses for shard i.
+func shardURI(i int) string {
+	return fmt.Sprintf("mongodb://mongo-shard-%d:27017", i)
+}
+
+// newTestManager creates a manager over numShards fake shards with the given
+// shard key and algorithm and the ID filter disabled, and returns it with the
+// fake collection of every shard.
+func newTestManager(t *testing.T, numShards int, shardKey, algorithm string) (*ShardManager, []*fakeCollection) {
+	t.Helper()
+	t.Setenv("SHARD_KEY", shardKey)
+	t.Setenv("SHARD_ALGORITHM", algorithm)
+	t.Setenv("ID_FILTER_CAPACITY", "0")
+	return connectTestManager(t, numShards)
+}
+
+// connectTestManager creates a manager over numShards fake shards, configured
+// by the other environment variables, and returns it with the fake
+// collection of every shard.
+func connectTestManager(t *testing.T, numShards int) (*ShardManager, []*fakeCollection) {
+	t.Helper()
+	t.Setenv("NUM_SHARDS", fmt.Sprint(numShards))
+
+	connector := newFakeConnector()
+	sm, err := NewShardManagerWithConnector(connector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sm.Close)
+
+	collections := make([]*fakeCollection, numShards)
+	for i := range collections {
+		collections[i] = sm.Shards[i].(*fakeCollection)
+	}
+	return sm, collections
+}
+
+func TestShardKeyNamePutsSameNameOnOneShard(t *testing.T) {
+	sm, collections := newTestManager(t, 4, shardKeyName, "")
+	handler := newRouter(&APIHandler{ShardManager: sm})
+
+	for i := 0; i < 20; i++ {
+		for _, name := range []string{"alice", "bob", "carol"} {
+			if rec := doRequest(handler, "POST", "/users", fmt.Sprintf(`{"name": %q, "data": "%d"}`, name, i)); rec.Code != 201 {
+				t.Fatalf("create %s: got %d", name, rec.Code)
+			}
+		}
+	}
+
+	for _, name := range []string{"alice", "bob", "carol"} {
+		holding := 0
+		for _, c := range collections {
+			if count, _ := c.CountDocuments(context.Background(), bson.M{"name": name}); count > 0 {
+				holding++
+			}
+		}
+		if holding != 1 {
+			t.Errorf("users named %s are spread over %d shards, want 1", name, holding)
+		}
+	}
+
+	for _, c := range collections {
+		c.findCalls.Store(0)
+	}
+	if rec := doRequest(handler, "GET", "/users/name/alice", ""); rec.Code != 200 {
+		t.Fatalf("lookup by name: got %d", rec.Code)
+	}
+	queried := 0
+	for _, c := range collections {
+		queried += int(c.findCalls.Load())
+	}
+	if queried != 1 {
+		t.Errorf("a name lookup queried %d shards, want 1", queried)
+	}
+}
+
+func TestNumShardsSetsTheShardCount(t *testing.T) {
+	sm, _ := newTestManager(t, 2, "", "")
+	if len(sm.Shards) != 2 || len(sm.Clients) != 2 {
+		t.Errorf("NUM_SHARDS=2 created %d shards and %d clients, want 2", len(sm.Shards), len(sm.Clients))
+	}
+	for _, id := range testUUIDs(t, 1000) {
+		if index := sm.ShardIndexForID(id); index < 0 || index > 1 {
+			t.Fatalf("%s routed to shard %d of 2", id, index)
+		}
+	}
+
+	for _, value := range []string{"0", "-1", "four"} {
+		t.Setenv("NUM_SHARDS", value)
+		if _, err := NewShardManagerWithConnector(newFakeConnector()); err == nil {
+			t.Errorf("NUM_SHARDS=%s was accepted", value)
+		}
+	}
+}
+
+func TestNewShardManagerRetriesFailedConnections(t *testing.T) {
+	defer func(delay time.Duration) { retryBaseDelay = delay }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+	t.Setenv("NUM_SHARDS", "2")
+	t.Setenv("SHARD_CONNECT_ATTEMPTS", "3")
+
+	connector := newFakeConnector()
+	connector.failures[shardURI(1)] = 2
+	sm, err := NewShardManagerWithConnector(connector)
+	if err != nil {
+		t.Fatalf("a shard failing twice with 3 attempts: %v", err)
+	}
+	sm.Close()
+	if got := connector.attempts[shardURI(1)]; got != 3 {
+		t.Errorf("shard 1 was tried %d times, want 3", got)
+	}
+	if len(sm.Shards) != 2 {
+		t.Errorf("%d shards connected, want 2", len(sm.Shards))
+	}
+
+	connector = newFakeConnector()
+	connector.failures[shardURI(1)] = 3
+	if _, err := NewShardManagerWithConnector(connector); err == nil {
+		t.Error("a shard failing every one of the 3 attempts was accepted")
+	}
+}
+
+func TestManagersForDifferentNamespacesStayApart(t *testing.T) {
+	t.Setenv("NUM_SHARDS", "2")
+	connector := newFakeConnector()
+	users, err := NewShardManagerForNamespace(connector, "userdb", "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer users.Close()
+	orders, err := NewShardManagerForNamespace(connector, "shop", "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer orders.Close()
+
+	ctx := context.Background()
+	for _, key := range []string{"order-1", "order-2", "order-3"} {
+		if _, err := orders.GetShardForKey(key).InsertOne(ctx, bson.M{"_id": key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	createUser(t, newRouter(&APIHandler{ShardManager: users}), "heidi", "")
+
+	counts := make(map[string]int)
+	for i := 0; i < 2; i++ {
+		client := connector.clients[shardURI(i)]
+		if len(client.collections) != 2 {
+			t.Fatalf("shard %d has the namespaces %v, want userdb.users and shop.orders", i, client.collections)
+		}
+		if users.Shards[i] != client.collections["userdb.users"] || orders.Shards[i] != client.collections["shop.orders"] {
+			t.Fatalf("shard %d: a manager does not use its own namespace", i)
+		}
+		for namespace, c := range client.collections {
+			counts[namespace] += c.len()
+		}
+	}
+	if counts["userdb.users"] != 1 || counts["shop.orders"] != 3 {
+		t.Errorf("documents per namespace %v, want 1 user and 3 orders", counts)
+	}
+
+	if _, err := NewShardManagerForNamespace(connector, "shop", ""); err == nil {
+		t.Error("an empty collection name was accepted")
+	}
+}
+
+func TestStringKeysMapToStableEvenlySpreadShards(t *testing.T) {
+	const numShards, numKeys = 4, 40000
+	for _, algorithm := range []string{algorithmRing, algorithmJump, algorithmModulo} {
+		sm, collections := newTestManager(t, numShards, "", algorithm)
+		index := make(map[ShardCollection]int)
+		for i, c := range collections {
+			index[c] = i
+		}
+
+		counts := make([]int, numShards)
+		for i := 0; i < numKeys; i++ {
+			key := fmt.Sprintf("legacy-%d", i)
+			shard := sm.GetShardForKey(key)
+			if again := sm.GetShardForKey(key); again != shard {
+				t.Fatalf("%s: %s maps to shards %d and %d", algorithm, key, index[shard], index[again])
+			}
+			counts[index[shard]]++
+		}
+		if algorithm != algorithmJump {
+			// IDs are routed as the key of their raw bytes, as before keys existed.
+			for _, id := range testUUIDs(t, 100) {
+				if sm.GetShardForID(id) != sm.GetShardForKey(string(id[:])) {
+					t.Fatalf("%s: %s is routed apart from the key of its bytes", algorithm, id)
+				}
+			}
+		}
+		// The ring's arcs differ in size, so allow 10% around the mean
+		// rather than requiring a uniform split.
+		mean := numKeys / numShards
+		for i, count := range counts {
+			if count < mean*9/10 || count > mean*11/10 {
+				t.Errorf("%s: shard %d holds %d of %d keys, want within 10%% of %d (counts %v)", algorithm, i, count, numKeys, mean, counts)
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// streamFlushInterval is how many users StreamUsers writes between flushes.
+const streamFlushInterval = 100
+
+// StreamUsers writes every user of every shard as a single JSON array, for
+// exports. Each shard is read through a cursor sorted by _id and the cursors
+// are merged in _id order, so only one decoded user per shard is held in
+// memory, whatever the size of the dataset.
+//
+// The export is bounded by the client connection rather than operationTimeout.
+// Once the array has started, errors can no longer change the status code:
+// the response is cut short instead, leaving the array unterminated.
+func (h *APIHandler) StreamUsers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	findOptions := options.Find().SetSort(bson.M{"_id": 1})
+
+	shards := h.ShardManager.GetAllScatterShards()
+	cursors := make([]*mongo.Cursor, 0, len(shards))
+	defer func() {
+		for _, cursor := range cursors {
+			cursor.Close(ctx)
+		}
+	}()
+	for index, shard := range shards {
+		cursor, err := shard.Find(ctx, bson.M{}, findOptions)
+		if err != nil {
+			http.Error(w, "Error reading users", http.StatusInternalServerError)
+			log.Printf("StreamUsers: error opening a cursor on shard %d: %v", index, err)
+			return
+		}
+		cursors = append(cursors, cursor)
+	}
+
+	// heads holds the next user of each shard; nil once its cursor is exhausted.
+	heads := make([]*User, len(cursors))
+	advance := func(index int) error {
+		if !cursors[index].Next(ctx) {
+			heads[index] = nil
+			return cursors[index].Err()
+		}
+		var user User
+		if err := cursors[index].Decode(&user); err != nil {
+			return err
+		}
+		heads[index] = &user
+		return nil
+	}
+	for index := range cursors {
+		if err := advance(index); err != nil {
+			http.Error(w, "Error reading users", http.StatusInternalServerError)
+			log.Printf("StreamUsers: error reading shard %d: %v", index, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	w.Write([]byte("["))
+
+	written := 0
+	for {
+		next := -1
+		for index, user := range heads {
+			if user != nil && (next < 0 || bytes.Compare(user.ID[:], heads[next].ID[:]) < 0) {
+				next = index
+			}
+		}
+		if next < 0 {
+			break
+		}
+
+		if written > 0 {
+			w.Write([]byte(","))
+		}
+		if err := encoder.Encode(heads[next]); err != nil {
+			log.Printf("StreamUsers: client went away after %d users: %v", written, err)
+			return
+		}
+		written++
+		if flusher != nil && written%streamFlushInterval == 0 {
+			flusher.Flush()
+		}
+
+		if err := advance(next); err != nil {
+			log.Printf("StreamUsers: error reading shard %d after %d users, response truncated: %v", next, written, err)
+			return
+		}
+	}
+
+	w.Write([]byte("]\n"))
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestStreamUsersWritesEveryUserOnce(t *testing.T) {
+	sm, collections := newTestManager(t, 4, "", "")
+	handler := newRouter(&APIHandler{ShardManager: sm})
+
+	const n = 300 // Several flushes' worth
+	ids := insertUsers(t, sm, n)
+	for i, c := range collections {
+		if c.len() == 0 {
+			t.Fatalf("shard %d holds no user", i)
+		}
+	}
+
+	rec := doRequest(handler, http.MethodGet, "/users", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200", rec.Code)
+	}
+	var users []User
+	if err := json.NewDecoder(rec.Body).Decode(&users); err != nil {
+		t.Fatalf("the streamed array does not decode: %v", err)
+	}
+	if len(users) != n {
+		t.Fatalf("streamed %d users, want %d", len(users), n)
+	}
+	seen := make(map[uuid.UUID]bool, n)
+	for _, user := range users {
+		if seen[user.ID] {
+			t.Fatalf("user %s was streamed twice", user.ID)
+		}
+		seen[user.ID] = true
+	}
+	for _, id := range ids {
+		if !seen[id] {
+			t.Errorf("user %s is missing from the stream", id)
+		}
+	}
+}
+
+func TestStreamUsersWithNoUsers(t *testing.T) {
+	sm, _ := newTestManager(t, 3, "", "")
+	rec := doRequest(newRouter(&APIHandler{ShardManager: sm}), http.MethodGet, "/users", "")
+	if rec.Code != http.StatusOK || rec.Body.String() != "[]\n" {
+		t.Errorf("got %d %q, want 200 with an empty array", rec.Code, rec.Body)
+	}
+}
@@ -8,6 +8,10 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -24,11 +28,20 @@ type User struct {
 }
 
 const (
-	apiURL    = "http://localhost:8080"
-	numUsers  = 1000
-	numShards = 4
+	apiURL   = "http://localhost:8080"
+	numUsers = 1000
 )
 
+// numShards must match the API's NUM_SHARDS (default 4).
+var numShards = shardCountFromEnv()
+
+func shardCountFromEnv() int {
+	if count, err := strconv.Atoi(os.Getenv("NUM_SHARDS")); err == nil && count >= 1 {
+		return count
+	}
+	return 4
+}
+
 var (
 	green  = color.New(color.FgGreen).PrintlnFunc()
 	blue   = color.New(color.FgBlue).PrintlnFunc()
@@ -144,6 +157,19 @@ func testCRUD() {
 	resp.Body.Close()
 	green("Response after update:", string(bodyBytes))
 
+	// c2. Partial update: only 'name' is sent, so 'data' must be preserved
+	yellow("\n-> Testing partial PUT /users/{id} (name only)")
+	jsonData, _ = json.Marshal(map[string]string{"name": "Teste CRUD Parcial"})
+	req, _ = http.NewRequest(http.MethodPut, apiURL+"/users/"+testUser.ID.String(), bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	httpClient.Do(req)
+	resp, _ = httpClient.Get(apiURL + "/users/" + testUser.ID.String())
+	var partialUser User
+	json.NewDecoder(resp.Body).Decode(&partialUser)
+	resp.Body.Close()
+	fmt.Printf("-> Name updated and data preserved: name=%q data=%q ", partialUser.Name, partialUser.Data)
+	if partialUser.Name == "Teste CRUD Parcial" && partialUser.Data == "dados atualizados" { green("OK") } else { red("FALHOU") }
+
 	// d. Get by name (Scatter-Gather)
 	yellow("\n-> Testing GET /users/name/{name} (Scatter-Gather)")
 	resp, _ = httpClient.Get(apiURL + "/users/name/John%20Doe")
@@ -152,6 +178,17 @@ func testCRUD() {
 	resp.Body.Close()
 	green(fmt.Sprintf("Get by name 'John Doe' found %d users (expected > 1).", len(multiUserResponse)))
 
+	// d2. Count by name (Scatter-Gather without transferring documents)
+	yellow("\n-> Testing GET /users/name/{name}/count")
+	resp, _ = httpClient.Get(apiURL + "/users/name/John%20Doe/count")
+	var countResponse struct {
+		Count int `json:"count"`
+	}
+	json.NewDecoder(resp.Body).Decode(&countResponse)
+	resp.Body.Close()
+	fmt.Printf("-> Count by name 'John Doe' (expected %d): %d ", len(multiUserResponse), countResponse.Count)
+	if countResponse.Count == len(multiUserResponse) { green("OK") } else { red("FALHOU") }
+
 	// e. Delete
 	yellow("\n-> Testing DELETE /users/{id}")
 	req, _ = http.NewRequest(http.MethodDelete, apiURL+"/users/"+testUser.ID.String(), nil)
@@ -171,7 +208,7 @@ func testFailures() {
 	if resp.StatusCode == http.StatusNotFound { green("OK") } else { red("FALHOU") }
 
 	// PUT
-	req, _ := http.NewRequest(http.MethodPut, apiURL+"/users/"+nonExistentID.String(), bytes.NewBuffer([]byte("{}")))
+	req, _ := http.NewRequest(http.MethodPut, apiURL+"/users/"+nonExistentID.String(), bytes.NewBuffer([]byte(`{"name":"Nobody"}`)))
 	req.Header.Set("Content-Type", "application/json")
 	resp, _ = httpClient.Do(req)
 	fmt.Printf("-> Testing PUT of non-existent ID (expected 404): %d ", resp.StatusCode)
@@ -182,6 +219,238 @@ func testFailures() {
 	resp, _ = httpClient.Do(req)
 	fmt.Printf("-> Testing DELETE of non-existent ID (expected 404): %d ", resp.StatusCode)
 	if resp.StatusCode == http.StatusNotFound { green("OK") } else { red("FALHOU") }
+
+	// POST with invalid users
+	invalidBodies := map[string]string{
+		"empty name":     `{"name":"","data":"x"}`,
+		"oversized data": `{"name":"Big","data":"` + strings.Repeat("x", 40<<10) + `"}`,
+	}
+	for label, body := range invalidBodies {
+		resp, err := httpClient.Post(apiURL+"/users", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			red("Error calling create:", err)
+			continue
+		}
+		resp.Body.Close()
+		fmt.Printf("-> Testing POST with %s (expected 400): %d ", label, resp.StatusCode)
+		if resp.StatusCode == http.StatusBadRequest { green("OK") } else { red("FALHOU") }
+	}
+}
+
+// --- 5. Testing Pagination of the Scatter-Gather Lookup ---
+func testPagination() {
+	blue("\n--- 5. Testing pagination of GET /users/name/{name} ---")
+
+	const total = 50
+	const pageSize = 10
+	name := fmt.Sprintf("Paging User %d", time.Now().UnixNano())
+
+	for i := 0; i < total; i++ {
+		payload := map[string]string{"name": name, "data": fmt.Sprintf("page data %d", i)}
+		jsonData, _ := json.Marshal(payload)
+		resp, err := httpClient.Post(apiURL+"/users", "application/json", bytes.NewBuffer(jsonData))
+		if err != nil {
+			red("Error inserting paging user", i, ":", err)
+			return
+		}
+		resp.Body.Close()
+	}
+	yellow(fmt.Sprintf("Inserted %d users named '%s'.", total, name))
+
+	seen := make(map[uuid.UUID]bool)
+	var lastID uuid.UUID
+	ordered := true
+	for offset := 0; offset < total; offset += pageSize {
+		pageURL := fmt.Sprintf("%s/users/name/%s?offset=%d&limit=%d", apiURL, url.PathEscape(name), offset, pageSize)
+		resp, err := httpClient.Get(pageURL)
+		if err != nil {
+			red("Error fetching page at offset", offset, ":", err)
+			return
+		}
+		var page []User
+		json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+
+		fmt.Printf("-> Page at offset %d returned %d users (expected %d) ", offset, len(page), pageSize)
+		if len(page) == pageSize { green("OK") } else { red("FALHOU") }
+
+		for _, user := range page {
+			if bytes.Compare(user.ID[:], lastID[:]) <= 0 {
+				ordered = false
+			}
+			lastID = user.ID
+			seen[user.ID] = true
+		}
+	}
+
+	fmt.Printf("-> Distinct users across all pages (expected %d): %d ", total, len(seen))
+	if len(seen) == total { green("OK") } else { red("FALHOU") }
+	fmt.Printf("-> Pages are in stable _id order: %v ", ordered)
+	if ordered { green("OK") } else { red("FALHOU") }
+}
+
+// --- 6. Testing the Bulk Insert Endpoint ---
+func testBulkInsert() {
+	blue("\n--- 6. Testing POST /users/bulk ---")
+
+	const total = 200
+	users := make([]map[string]string, 0, total)
+	for i := 0; i < total; i++ {
+		name := fmt.Sprintf("Bulk User %d", i)
+		users = append(users, map[string]string{"name": name, "data": "Random data for " + name})
+	}
+	jsonData, _ := json.Marshal(users)
+
+	resp, err := httpClient.Post(apiURL+"/users/bulk", "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		red("Error calling bulk insert:", err)
+		return
+	}
+	var result struct {
+		Inserted int            `json:"inserted"`
+		PerShard map[string]int `json:"per_shard"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+
+	sum := 0
+	for shard, count := range result.PerShard {
+		yellow(fmt.Sprintf("Shard %s: %d users", shard, count))
+		sum += count
+	}
+	fmt.Printf("-> Bulk insert status (expected 201): %d ", resp.StatusCode)
+	if resp.StatusCode == http.StatusCreated { green("OK") } else { red("FALHOU") }
+	fmt.Printf("-> Inserted %d, per-shard sum %d (expected %d) ", result.Inserted, sum, total)
+	if result.Inserted == total && sum == total { green("OK") } else { red("FALHOU") }
+}
+
+// --- 7. Testing Sharding by Name ---
+// Only meaningful when the API runs with SHARD_KEY=name: every user with the
+// same name must then be stored on a single shard.
+func testNameSharding() {
+	if os.Getenv("SHARD_KEY") != "name" {
+		return
+	}
+	blue("\n--- 7. Testing SHARD_KEY=name ---")
+
+	const total = 30
+	name := fmt.Sprintf("Name Shard User %d", time.Now().UnixNano())
+	users := make([]map[string]string, 0, total)
+	for i := 0; i < total; i++ {
+		users = append(users, map[string]string{"name": name, "data": fmt.Sprintf("name shard data %d", i)})
+	}
+	jsonData, _ := json.Marshal(users)
+	resp, err := httpClient.Post(apiURL+"/users/bulk", "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		red("Error calling bulk insert:", err)
+		return
+	}
+	resp.Body.Close()
+
+	shardsWithName := 0
+	for i := 0; i < numShards; i++ {
+		uri := fmt.Sprintf("mongodb://localhost:%d", 27017+i)
+		client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(uri))
+		if err != nil {
+			red("Error connecting to shard", i, ":", err)
+			return
+		}
+		count, err := client.Database("userdb").Collection("users").CountDocuments(context.Background(), map[string]interface{}{"name": name})
+		client.Disconnect(context.Background())
+		if err != nil {
+			red("Error counting documents in shard", i, ":", err)
+			return
+		}
+		if count > 0 {
+			yellow(fmt.Sprintf("Shard %d: %d users named '%s'", i, count, name))
+			shardsWithName++
+		}
+	}
+	fmt.Printf("-> Shards holding the name (expected 1): %d ", shardsWithName)
+	if shardsWithName == 1 { green("OK") } else { red("FALHOU") }
+}
+
+// --- 8. Testing Delete by Name ---
+func testDeleteByName() {
+	blue("\n--- 8. Testing DELETE /users/name/{name} ---")
+
+	const total = 40
+	name := fmt.Sprintf("Delete Me %d", time.Now().UnixNano())
+	users := make([]map[string]string, 0, total)
+	for i := 0; i < total; i++ {
+		users = append(users, map[string]string{"name": name, "data": fmt.Sprintf("delete data %d", i)})
+	}
+	jsonData, _ := json.Marshal(users)
+	resp, err := httpClient.Post(apiURL+"/users/bulk", "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		red("Error calling bulk insert:", err)
+		return
+	}
+	resp.Body.Close()
+
+	deleteByName := func() (int, int64) {
+		req, _ := http.NewRequest(http.MethodDelete, apiURL+"/users/name/"+url.PathEscape(name), nil)
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			red("Error calling delete by name:", err)
+			return 0, -1
+		}
+		defer resp.Body.Close()
+		var result struct {
+			Deleted int64 `json:"deleted"`
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result.Deleted
+	}
+
+	status, deleted := deleteByName()
+	fmt.Printf("-> Deleted %d users (expected %d), status %d ", deleted, total, status)
+	if status == http.StatusOK && deleted == total { green("OK") } else { red("FALHOU") }
+
+	status, deleted = deleteByName()
+	fmt.Printf("-> Deleting again: %d users (expected 0), status %d (expected 200) ", deleted, status)
+	if status == http.StatusOK && deleted == 0 { green("OK") } else { red("FALHOU") }
+}
+
+// --- 9. Testing the User Export Stream ---
+func testStreamUsers() {
+	blue("\n--- 9. Testing GET /users ---")
+
+	expected := int64(0)
+	for i := 0; i < numShards; i++ {
+		uri := fmt.Sprintf("mongodb://localhost:%d", 27017+i)
+		client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(uri))
+		if err != nil {
+			red("Error connecting to shard", i, ":", err)
+			return
+		}
+		count, err := client.Database("userdb").Collection("users").CountDocuments(context.Background(), map[string]interface{}{})
+		client.Disconnect(context.Background())
+		if err != nil {
+			red("Error counting documents in shard", i, ":", err)
+			return
+		}
+		expected += count
+	}
+
+	resp, err := httpClient.Get(apiURL + "/users")
+	if err != nil {
+		red("Error calling user stream:", err)
+		return
+	}
+	defer resp.Body.Close()
+	var users []User
+	if err := json.NewDecoder(resp.Body).Decode(&users); err != nil {
+		red("Error decoding user stream:", err)
+		return
+	}
+
+	seen := make(map[uuid.UUID]bool, len(users))
+	for _, user := range users {
+		seen[user.ID] = true
+	}
+	fmt.Printf("-> Streamed %d users, %d distinct (expected %d) ", len(users), len(seen), expected)
+	if int64(len(users)) == expected && len(seen) == len(users) { green("OK") } else { red("FALHOU") }
 }
 
 func main() {
@@ -189,5 +458,10 @@ func main() {
 	countShards()
 	testCRUD()
 	testFailures()
+	testPagination()
+	testBulkInsert()
+	testNameSharding()
+	testDeleteByName()
+	testStreamUsers()
 	green("\n--- All tests completed! ---")
 }
\ No newline at end of file
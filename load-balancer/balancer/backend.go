@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// healthCheckInterval is the time between two rounds of probes.
+	healthCheckInterval = 5 * time.Second
+	// healthCheckTimeout bounds a single probe. The repository sleeps up to 10s before answering.
+	healthCheckTimeout = 11 * time.Second
+)
+
+// Backend is a server the balancer forwards requests to.
+type Backend struct {
+	URL     *url.URL
+	Weight  int // Share of the traffic relative to the other backends, for weighted strategies
+	proxy   *httputil.ReverseProxy
+	healthy atomic.Bool
+	active  atomic.Int64 // Requests currently being proxied
+}
+
+// newBackend creates a healthy backend for rawURL with its own reverse proxy.
+// A request that fails to reach the backend marks it unhealthy until the
+// next successful health check, unless the client gave up first.
+func newBackend(rawURL string, weight int) (*Backend, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if weight < 1 {
+		return nil, fmt.Errorf("invalid weight %d for backend %s: must be positive", weight, rawURL)
+	}
+	b := &Backend{URL: u, Weight: weight, proxy: httputil.NewSingleHostReverseProxy(u)}
+	b.healthy.Store(true)
+
+	b.proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		if r.Context().Err() != nil {
+			// The client went away, e.g. it timed out while the repository
+			// slept: that says nothing about the backend, and nobody reads a 502.
+			log.Printf("Request to backend %s cancelled by the client: %v", b.URL, err)
+			return
+		}
+		log.Printf("Backend %s failed: %v", b.URL, err)
+		b.SetHealthy(false)
+		http.Error(w, "Bad gateway", http.StatusBadGateway)
+	}
+	return b, nil
+}
+
+// Healthy reports whether the backend is currently marked up.
+func (b *Backend) Healthy() bool {
+	return b.healthy.Load()
+}
+
+// ActiveConnections returns the number of requests currently being proxied to the backend.
+func (b *Backend) ActiveConnections() int64 {
+	return b.active.Load()
+}
+
+// SetHealthy marks the backend up or down, logging transitions.
+func (b *Backend) SetHealthy(healthy bool) {
+	if b.healthy.Swap(healthy) != healthy {
+		state := "DOWN"
+		if healthy {
+			state = "UP"
+		}
+		log.Printf("Backend %s is now %s", b.URL, state)
+	}
+}
+
+// startHealthChecks probes every backend at healthPath now and then every
+// interval, until ctx is cancelled. Transport errors and 5xx mark a backend down.
+func startHealthChecks(ctx context.Context, backends []*Backend, healthPath string, interval time.Duration) {
+	client := &http.Client{Timeout: healthCheckTimeout}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			var wg sync.WaitGroup
+			for _, b := range backends {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					b.SetHealthy(probe(ctx, client, b.URL.JoinPath(healthPath).String()))
+				}()
+			}
+			wg.Wait()
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// probe reports whether url answers without a transport error or 5xx.
+func probe(ctx context.Context, client *http.Client, url string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode < http.StatusInternalServerError
+}
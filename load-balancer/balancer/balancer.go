@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Strategy picks the backend for a request among the healthy ones.
+type Strategy interface {
+	Next(backends []*Backend, r *http.Request) *Backend
+}
+
+// Balancer is a reverse proxy spreading requests over a set of backends.
+type Balancer struct {
+	backends     []*Backend
+	strategy     Strategy
+	stickyCookie string // Name of the session cookie; "" disables sticky sessions
+}
+
+// NewBalancer creates a balancer over the given backend URLs using strategy.
+// weights gives the weight of each backend, in the same order as urls; nil
+// means every backend has weight 1.
+func NewBalancer(urls []string, weights []int, strategy Strategy) (*Balancer, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("at least one backend URL is required")
+	}
+	if weights != nil && len(weights) != len(urls) {
+		return nil, fmt.Errorf("got %d weights for %d backends", len(weights), len(urls))
+	}
+	lb := &Balancer{strategy: strategy}
+	for i, rawURL := range urls {
+		weight := 1
+		if weights != nil {
+			weight = weights[i]
+		}
+		b, err := newBackend(rawURL, weight)
+		if err != nil {
+			return nil, err
+		}
+		lb.backends = append(lb.backends, b)
+	}
+	return lb, nil
+}
+
+// Backends returns the balancer's backends.
+func (lb *Balancer) Backends() []*Backend {
+	return lb.backends
+}
+
+// SetStickyCookie enables sticky sessions: the backend serving a client's
+// first request is stored in a cookie with the given name, and the client's
+// next requests go to that backend as long as it is healthy.
+func (lb *Balancer) SetStickyCookie(name string) {
+	lb.stickyCookie = name
+}
+
+// pinnedBackend returns the healthy backend whose URL is in the request's
+// session cookie, or nil if there is no cookie or its backend is not healthy.
+// The full URL tells apart backends sharing a host, e.g. behind one proxy.
+func (lb *Balancer) pinnedBackend(healthy []*Backend, r *http.Request) *Backend {
+	cookie, err := r.Cookie(lb.stickyCookie)
+	if err != nil {
+		return nil
+	}
+	for _, b := range healthy {
+		if b.URL.String() == cookie.Value {
+			return b
+		}
+	}
+	return nil
+}
+
+// ServeHTTP forwards the request to the backend chosen by the strategy, or to
+// the one pinned by the session cookie when sticky sessions are enabled. The
+// X-Backend response header identifies it.
+func (lb *Balancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	healthy := make([]*Backend, 0, len(lb.backends))
+	for _, b := range lb.backends {
+		if b.Healthy() {
+			healthy = append(healthy, b)
+		}
+	}
+
+	var b *Backend
+	if lb.stickyCookie != "" {
+		b = lb.pinnedBackend(healthy, r)
+	}
+	if b == nil {
+		b = lb.strategy.Next(healthy, r)
+		if b == nil {
+			http.Error(w, "No healthy backend available", http.StatusServiceUnavailable)
+			return
+		}
+		// Pin the client to the new backend, replacing a cookie naming one that is down
+		if lb.stickyCookie != "" {
+			http.SetCookie(w, &http.Cookie{Name: lb.stickyCookie, Value: b.URL.String(), Path: "/", HttpOnly: true})
+		}
+	}
+
+	b.active.Add(1)
+	defer b.active.Add(-1)
+
+	w.Header().Set("X-Backend", b.URL.Host)
+	b.proxy.ServeHTTP(w, r)
+}
+
+// RoundRobin sends requests to the healthy backends in turn.
+type RoundRobin struct {
+	next atomic.Uint64
+}
+
+func (rr *RoundRobin) Next(backends []*Backend, r *http.Request) *Backend {
+	if len(backends) == 0 {
+		return nil
+	}
+	return backends[(rr.next.Add(1)-1)%uint64(len(backends))]
+}
+
+// LeastConnections sends each request to the healthy backend with the fewest
+// requests in flight, so slow backends get less traffic. Ties go round-robin.
+type LeastConnections struct {
+	next atomic.Uint64
+}
+
+func (lc *LeastConnections) Next(backends []*Backend, r *http.Request) *Backend {
+	if len(backends) == 0 {
+		return nil
+	}
+	start := int((lc.next.Add(1) - 1) % uint64(len(backends)))
+	best := backends[start]
+	for i := 1; i < len(backends); i++ {
+		b := backends[(start+i)%len(backends)]
+		if b.ActiveConnections() < best.ActiveConnections() {
+			best = b
+		}
+	}
+	return best
+}
+
+// SmoothWeightedRoundRobin sends each backend a share of the requests
+// proportional to its weight, interleaving them instead of sending bursts to
+// the same backend (the nginx algorithm): weights {5, 1, 1} give the order
+// a a b a c a a rather than a a a a a b c.
+type SmoothWeightedRoundRobin struct {
+	mu      sync.Mutex
+	current map[*Backend]int
+}
+
+func (s *SmoothWeightedRoundRobin) Next(backends []*Backend, r *http.Request) *Backend {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current == nil {
+		s.current = make(map[*Backend]int)
+	}
+
+	// Every backend gains its weight; the one with the highest current weight
+	// is picked and pays back the total.
+	var best *Backend
+	total := 0
+	for _, b := range backends {
+		s.current[b] += b.Weight
+		total += b.Weight
+		if best == nil || s.current[b] > s.current[best] {
+			best = b
+		}
+	}
+	if best != nil {
+		s.current[best] -= total
+	}
+	return best
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServers starts n backends running handler, or answering an empty
+// 200 if it is nil, and returns their URLs.
+func newTestServers(t *testing.T, n int, handler http.HandlerFunc) []string {
+	t.Helper()
+	urls := make([]string, n)
+	for i := range urls {
+		h := handler
+		if h == nil {
+			h = func(w http.ResponseWriter, r *http.Request) {}
+		}
+		server := httptest.NewServer(h)
+		t.Cleanup(server.Close)
+		urls[i] = server.URL
+	}
+	return urls
+}
+
+// serve sends a GET for path through lb and returns the response.
+func serve(lb http.Handler, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	lb.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func TestRoundRobinAlternatesBetweenBackends(t *testing.T) {
+	urls := newTestServers(t, 2, nil)
+	lb, err := NewBalancer(urls, nil, &RoundRobin{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hosts := lb.Backends()
+	for i := 0; i < 6; i++ {
+		rec := serve(lb, "/data")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: got status %d", i, rec.Code)
+		}
+		if got, want := rec.Header().Get("X-Backend"), hosts[i%2].URL.Host; got != want {
+			t.Fatalf("request %d went to %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestCancelledRequestKeepsBackendHealthy(t *testing.T) {
+	urls := newTestServers(t, 1, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(5 * time.Second):
+		case <-r.Context().Done():
+		}
+	})
+	lb, err := NewBalancer(urls, nil, &RoundRobin{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	rec := httptest.NewRecorder()
+	lb.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/data", nil).WithContext(ctx))
+
+	if !lb.Backends()[0].Healthy() {
+		t.Error("a client timeout marked the backend unhealthy")
+	}
+	if rec.Code == http.StatusBadGateway {
+		t.Error("wrote a 502 to a client that went away")
+	}
+}
+
+func TestLeastConnectionsAvoidsABackendWithARequestHeldOpen(t *testing.T) {
+	release := make(chan struct{})
+	urls := newTestServers(t, 2, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("hold") {
+			<-release
+		}
+	})
+	lb, err := NewBalancer(urls, nil, &LeastConnections{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	held, free := lb.Backends()[0], lb.Backends()[1]
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serve(lb, "/data?hold")
+	}()
+	defer func() {
+		close(release)
+		<-done
+	}()
+	deadline := time.Now().Add(time.Second)
+	for held.ActiveConnections() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("the held request never reached the first backend")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := 0; i < 4; i++ {
+		if got := serve(lb, "/data").Header().Get("X-Backend"); got != free.URL.Host {
+			t.Fatalf("request %d went to %s, want the idle %s", i, got, free.URL.Host)
+		}
+	}
+}
+
+func TestSmoothWeightedRoundRobinInterleavesByWeight(t *testing.T) {
+	urls := newTestServers(t, 3, nil)
+	lb, err := NewBalancer(urls, []int{3, 2, 1}, &SmoothWeightedRoundRobin{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]string{}
+	for i, b := range lb.Backends() {
+		names[b.URL.Host] = string(rune('a' + i))
+	}
+
+	var order strings.Builder
+	counts := map[string]int{}
+	for i := 0; i < 600; i++ {
+		name := names[serve(lb, "/data").Header().Get("X-Backend")]
+		order.WriteString(name)
+		counts[name]++
+	}
+
+	if counts["a"] != 300 || counts["b"] != 200 || counts["c"] != 100 {
+		t.Errorf("got counts %v, want a:300 b:200 c:100", counts)
+	}
+	// Weights {3, 2, 1} repeat a b a c b a, never aaabbc: no backend gets
+	// more than two requests in a row
+	got := order.String()
+	if got != strings.Repeat("abacba", 100) {
+		t.Errorf("got the order %s..., want abacba repeated", got[:24])
+	}
+	for _, run := range []string{"aaa", "bb", "cc"} {
+		if strings.Contains(got, run) {
+			t.Errorf("the order has the run %s", run)
+		}
+	}
+}
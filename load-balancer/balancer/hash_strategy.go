@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// vnodesPerBackend is how many points each backend gets on the hash ring.
+// More points give a more uniform distribution at the cost of memory.
+const vnodesPerBackend = 200
+
+// KeyFunc extracts the routing key of a request for HashStrategy.
+type KeyFunc func(r *http.Request) string
+
+// HeaderKey routes by the value of a request header, e.g. a user ID.
+func HeaderKey(name string) KeyFunc {
+	return func(r *http.Request) string { return r.Header.Get(name) }
+}
+
+// PathKey routes by the request path.
+func PathKey(r *http.Request) string {
+	return r.URL.Path
+}
+
+// HashStrategy sends requests with the same key to the same backend, for
+// cache affinity. It is a routing-only version of the ring in
+// consistent-hashing/: backends are placed on a hash ring, and a request goes
+// to the first healthy backend clockwise from its key. When a backend goes
+// down, only its share of the keys moves to the next backends on the ring.
+//
+// That ring cannot be imported: consistent-hashing/ is a standalone package
+// main without a go.mod, and it also moves stored data, which a balancer has
+// none of. Only the lookup is repeated here.
+type HashStrategy struct {
+	key    KeyFunc
+	mu     sync.Mutex
+	ring   []uint64            // The sorted positions of all VNodes
+	owners map[uint64]*Backend // Maps a VNode position to its backend
+	placed map[*Backend]bool
+}
+
+// NewHashStrategy creates a consistent-hashing strategy that routes by key.
+func NewHashStrategy(key KeyFunc) *HashStrategy {
+	return &HashStrategy{
+		key:    key,
+		owners: make(map[uint64]*Backend),
+		placed: make(map[*Backend]bool),
+	}
+}
+
+func (h *HashStrategy) Next(backends []*Backend, r *http.Request) *Backend {
+	if len(backends) == 0 {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Backends are placed on the ring the first time they are seen healthy and
+	// stay there, so going down and up again does not reshuffle other keys.
+	available := make(map[*Backend]bool, len(backends))
+	for _, b := range backends {
+		available[b] = true
+		if !h.placed[b] {
+			h.place(b)
+		}
+	}
+
+	hash := hashBytes([]byte(h.key(r)))
+	start := sort.Search(len(h.ring), func(i int) bool {
+		return h.ring[i] >= hash
+	})
+	// Walk clockwise, wrapping around, to the first healthy backend
+	for i := 0; i < len(h.ring); i++ {
+		if b := h.owners[h.ring[(start+i)%len(h.ring)]]; available[b] {
+			return b
+		}
+	}
+	return nil
+}
+
+// place adds the VNodes of a backend to the ring; the caller must hold the lock.
+func (h *HashStrategy) place(b *Backend) {
+	for i := 0; i < vnodesPerBackend; i++ {
+		hash := hashBytes([]byte(fmt.Sprintf("%s#%d", b.URL, i)))
+		if _, taken := h.owners[hash]; taken {
+			// A collision on a 64-bit ring is practically impossible; keep the first owner.
+			continue
+		}
+		h.owners[hash] = b
+		h.ring = append(h.ring, hash)
+	}
+	sort.Slice(h.ring, func(i, j int) bool { return h.ring[i] < h.ring[j] })
+	h.placed[b] = true
+}
+
+// hashBytes is the FNV-1a hash used for both keys and VNodes, followed by
+// MurmurHash3's fmix64 finalizer to spread similar inputs over the whole ring.
+func hashBytes(data []byte) uint64 {
+	hasher := fnv.New64a()
+	hasher.Write(data)
+	h := hasher.Sum64()
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
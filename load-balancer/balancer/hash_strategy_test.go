@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestBackends returns n backends that are never contacted.
+func newTestBackends(t *testing.T, n int) []*Backend {
+	t.Helper()
+	backends := make([]*Backend, n)
+	for i := range backends {
+		b, err := newBackend(fmt.Sprintf("http://backend-%d:8080", i), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		backends[i] = b
+	}
+	return backends
+}
+
+// userRequest is a request for user i, routed by its X-User-ID header.
+func userRequest(i int) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/data", nil)
+	r.Header.Set("X-User-ID", fmt.Sprintf("user-%d", i))
+	return r
+}
+
+func TestHashStrategyKeepsKeysOnTheirBackend(t *testing.T) {
+	backends := newTestBackends(t, 3)
+	first := NewHashStrategy(HeaderKey("X-User-ID"))
+	second := NewHashStrategy(HeaderKey("X-User-ID"))
+
+	used := make(map[*Backend]bool)
+	for i := 0; i < 1000; i++ {
+		b := first.Next(backends, userRequest(i))
+		used[b] = true
+		if again := first.Next(backends, userRequest(i)); again != b {
+			t.Fatalf("user-%d went to %s, then to %s", i, b.URL, again.URL)
+		}
+		if other := second.Next(backends, userRequest(i)); other != b {
+			t.Fatalf("user-%d goes to %s or %s depending on the strategy instance", i, b.URL, other.URL)
+		}
+	}
+	if len(used) != len(backends) {
+		t.Errorf("1000 keys used %d of %d backends", len(used), len(backends))
+	}
+}
+
+func TestHashStrategyOnlyReroutesKeysOfRemovedBackend(t *testing.T) {
+	backends := newTestBackends(t, 3)
+	strategy := NewHashStrategy(HeaderKey("X-User-ID"))
+
+	before := make([]*Backend, 1000)
+	for i := range before {
+		before[i] = strategy.Next(backends, userRequest(i))
+	}
+
+	down := backends[1]
+	remaining := []*Backend{backends[0], backends[2]}
+	rerouted := 0
+	for i, was := range before {
+		now := strategy.Next(remaining, userRequest(i))
+		switch {
+		case was == down:
+			rerouted++
+			if now == down {
+				t.Fatalf("user-%d still goes to the removed backend", i)
+			}
+		case now != was:
+			t.Fatalf("user-%d moved from %s to %s, but its backend is still up", i, was.URL, now.URL)
+		}
+	}
+	if rerouted == 0 {
+		t.Error("no key was on the removed backend")
+	}
+
+	// Once the backend is back, its keys return to it.
+	for i, was := range before {
+		if now := strategy.Next(backends, userRequest(i)); now != was {
+			t.Fatalf("user-%d went to %s after the backend came back, want %s", i, now.URL, was.URL)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	urls := strings.Split(os.Getenv("BACKEND_URLS"), ",")
+	if len(urls) == 1 && urls[0] == "" {
+		log.Fatal("BACKEND_URLS is not defined")
+	}
+
+	strategy, err := strategyFromEnv(urls)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	weights, err := weightsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	lb, err := NewBalancer(urls, weights, strategy)
+	if err != nil {
+		log.Fatalf("Failed to create the balancer: %v", err)
+	}
+
+	// Sticky sessions are off unless STICKY_COOKIE names the session cookie
+	if cookie := os.Getenv("STICKY_COOKIE"); cookie != "" {
+		lb.SetStickyCookie(cookie)
+	}
+
+	healthPath := os.Getenv("HEALTH_CHECK_PATH")
+	if healthPath == "" {
+		healthPath = "/data"
+	}
+	startHealthChecks(context.Background(), lb.Backends(), healthPath, healthCheckInterval)
+
+	log.Printf("Balancer listening on port 8082, forwarding to %d backends...", len(urls))
+	log.Fatal(http.ListenAndServe(":8082", lb))
+}
+
+// strategyFromEnv selects the balancing strategy from STRATEGY for the
+// backends with the given URLs.
+func strategyFromEnv(urls []string) (Strategy, error) {
+	switch name := os.Getenv("STRATEGY"); name {
+	case "", "round_robin":
+		return &RoundRobin{}, nil
+	case "least_connections":
+		return &LeastConnections{}, nil
+	case "weighted_round_robin":
+		return &SmoothWeightedRoundRobin{}, nil
+	case "weighted_random":
+		return NewWeightedRandom(urls)
+	case "hash":
+		// Route by HASH_HEADER (e.g. "X-User-ID") if set, otherwise by path
+		if header := os.Getenv("HASH_HEADER"); header != "" {
+			return NewHashStrategy(HeaderKey(header)), nil
+		}
+		return NewHashStrategy(PathKey), nil
+	default:
+		return nil, fmt.Errorf("unknown STRATEGY %q", name)
+	}
+}
+
+// weightsFromEnv reads the comma-separated backend weights from
+// BACKEND_WEIGHTS, in the same order as BACKEND_URLS. Unset means all 1.
+func weightsFromEnv() ([]int, error) {
+	value := os.Getenv("BACKEND_WEIGHTS")
+	if value == "" {
+		return nil, nil
+	}
+	var weights []int
+	for _, field := range strings.Split(value, ",") {
+		weight, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, fmt.Errorf("invalid BACKEND_WEIGHTS %q: %w", value, err)
+		}
+		weights = append(weights, weight)
+	}
+	return weights, nil
+}
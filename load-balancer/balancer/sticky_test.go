@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// serveWithCookie sends a GET for /data through lb carrying cookie, if any.
+func serveWithCookie(lb http.Handler, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/data", nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	lb.ServeHTTP(rec, req)
+	return rec
+}
+
+// stickyCookie returns the cookie named name set by rec, or nil.
+func stickyCookie(rec *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestStickySessionsPinClientsToTheirBackend(t *testing.T) {
+	urls := newTestServers(t, 3, nil)
+	lb, err := NewBalancer(urls, nil, &RoundRobin{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb.SetStickyCookie("lb")
+
+	rec := serveWithCookie(lb, nil)
+	cookie := stickyCookie(rec, "lb")
+	if cookie == nil {
+		t.Fatal("the first response did not set the sticky cookie")
+	}
+	pinned := rec.Header().Get("X-Backend")
+	if cookie.Value != urls[0] {
+		t.Errorf("cookie value = %q, want the backend URL %q", cookie.Value, urls[0])
+	}
+
+	for i := 0; i < 5; i++ {
+		rec := serveWithCookie(lb, cookie)
+		if got := rec.Header().Get("X-Backend"); got != pinned {
+			t.Fatalf("request %d went to %s, want the pinned %s", i, got, pinned)
+		}
+		if stickyCookie(rec, "lb") != nil {
+			t.Errorf("request %d re-set the cookie of a healthy backend", i)
+		}
+	}
+
+	// Once the pinned backend is down, the client moves and is pinned again
+	lb.Backends()[0].SetHealthy(false)
+	rec = serveWithCookie(lb, cookie)
+	if got := rec.Header().Get("X-Backend"); got == pinned || rec.Code != http.StatusOK {
+		t.Fatalf("with the pinned backend down: got %d from %s", rec.Code, got)
+	}
+	moved := stickyCookie(rec, "lb")
+	if moved == nil || moved.Value == cookie.Value {
+		t.Fatalf("the client was not pinned to its new backend: %v", moved)
+	}
+	if got := serveWithCookie(lb, moved).Header().Get("X-Backend"); got != rec.Header().Get("X-Backend") {
+		t.Errorf("after the fallback the client went to %s, want its new backend", got)
+	}
+}
+
+func TestStickyCookieTellsApartBackendsOnTheSameHost(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(server.Close)
+	lb, err := NewBalancer([]string{server.URL + "/a", server.URL + "/b"}, nil, &RoundRobin{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb.SetStickyCookie("lb")
+
+	first, second := stickyCookie(serveWithCookie(lb, nil), "lb"), stickyCookie(serveWithCookie(lb, nil), "lb")
+	if first == nil || second == nil || first.Value == second.Value {
+		t.Fatalf("backends on one host got the cookies %v and %v, want distinct ones", first, second)
+	}
+	// A client pinned to the second backend stays there, not on the first
+	// backend sharing its host
+	for i := 0; i < 3; i++ {
+		rec := serveWithCookie(lb, second)
+		if stickyCookie(rec, "lb") != nil {
+			t.Fatalf("request %d was not recognized as pinned to %s", i, second.Value)
+		}
+	}
+}
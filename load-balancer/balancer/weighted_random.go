@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// WeightedRandom picks each backend with a probability proportional to its
+// weight, independently for every request. Unlike SmoothWeightedRoundRobin
+// there is no interleaving: weights {95, 5} send each request to the second
+// backend with a 5% chance, which suits canary releases.
+//
+// Weights start as the backends' Weight and can be changed at runtime with
+// SetWeight, e.g. to ramp a canary up, or to 0 to drain a backend.
+type WeightedRandom struct {
+	mu      sync.RWMutex
+	known   map[string]bool // URLs of the backends, as parsed by the balancer
+	weights map[string]int  // Backend URL -> weight overriding Backend.Weight
+}
+
+// NewWeightedRandom returns a WeightedRandom over the backends with the given
+// URLs, the ones SetWeight accepts.
+func NewWeightedRandom(backendURLs []string) (*WeightedRandom, error) {
+	wr := &WeightedRandom{known: make(map[string]bool), weights: make(map[string]int)}
+	for _, rawURL := range backendURLs {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		wr.known[u.String()] = true
+	}
+	return wr, nil
+}
+
+// SetWeight changes the weight of the backend with the given URL, as written
+// in BACKEND_URLS. It applies from the next request on; a backend with weight
+// 0 gets no requests.
+func (wr *WeightedRandom) SetWeight(backendURL string, weight int) error {
+	if weight < 0 {
+		return fmt.Errorf("invalid weight %d for backend %s: must not be negative", weight, backendURL)
+	}
+	u, err := url.Parse(backendURL)
+	if err != nil {
+		return err
+	}
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	if !wr.known[u.String()] {
+		return fmt.Errorf("unknown backend %s", backendURL)
+	}
+	wr.weights[u.String()] = weight
+	return nil
+}
+
+// weight returns the current weight of b. The caller holds the read lock.
+func (wr *WeightedRandom) weight(b *Backend) int {
+	if weight, ok := wr.weights[b.URL.String()]; ok {
+		return weight
+	}
+	return b.Weight
+}
+
+func (wr *WeightedRandom) Next(backends []*Backend, r *http.Request) *Backend {
+	wr.mu.RLock()
+	defer wr.mu.RUnlock()
+
+	total := 0
+	for _, b := range backends {
+		total += wr.weight(b)
+	}
+	if total == 0 {
+		return nil // No backends, or all of them drained
+	}
+	// Walk the backends until the cumulative weight passes a random point;
+	// a zero-weight backend never does
+	point := rand.Intn(total)
+	for _, b := range backends {
+		point -= wr.weight(b)
+		if point < 0 {
+			return b
+		}
+	}
+	return nil
+}
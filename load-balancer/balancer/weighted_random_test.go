@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func newTestWeightedRandom(t *testing.T, weights []int) (*Balancer, *WeightedRandom, []string) {
+	t.Helper()
+	urls := newTestServers(t, len(weights), nil)
+	wr, err := NewWeightedRandom(urls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb, err := NewBalancer(urls, weights, wr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lb, wr, urls
+}
+
+func TestWeightedRandomFollowsTheWeights(t *testing.T) {
+	lb, wr, _ := newTestWeightedRandom(t, []int{95, 5})
+	backends := lb.Backends()
+
+	const n = 10000
+	canary := 0
+	for i := 0; i < n; i++ {
+		if wr.Next(backends, nil) == backends[1] {
+			canary++
+		}
+	}
+	// 5% of 10000 is 500, with a standard deviation of about 22
+	if canary < 400 || canary > 600 {
+		t.Errorf("the canary got %d of %d requests, want about 500", canary, n)
+	}
+}
+
+func TestWeightedRandomSkipsDrainedBackends(t *testing.T) {
+	lb, wr, urls := newTestWeightedRandom(t, []int{1, 1})
+	backends := lb.Backends()
+
+	if err := wr.SetWeight(urls[1], 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if b := wr.Next(backends, nil); b != backends[0] {
+			t.Fatalf("request %d went to %v, want only the backend with a weight", i, b.URL)
+		}
+	}
+
+	if err := wr.SetWeight(urls[0], 0); err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve(lb, "/data"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("with every backend drained: got %d, want 503", rec.Code)
+	}
+}
+
+func TestWeightedRandomSetWeightRejectsBadInput(t *testing.T) {
+	_, wr, urls := newTestWeightedRandom(t, []int{1, 1})
+
+	if err := wr.SetWeight(urls[0], -1); err == nil {
+		t.Error("SetWeight accepted a negative weight")
+	}
+	if err := wr.SetWeight("http://unknown:8001", 3); err == nil {
+		t.Error("SetWeight accepted a URL that is not a backend")
+	}
+	if err := wr.SetWeight(urls[0], 3); err != nil {
+		t.Errorf("SetWeight(%s, 3): %v", urls[0], err)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultBreakerThreshold is used when BREAKER_FAILURE_THRESHOLD is not set.
+	defaultBreakerThreshold = 5
+	// defaultBreakerCooldown is used when BREAKER_COOLDOWN is not set.
+	defaultBreakerCooldown = 30 * time.Second
+)
+
+// circuitState is the state of a CircuitBreaker.
+type circuitState int
+
+const (
+	circuitClosed   circuitState = iota // Calls go through
+	circuitOpen                         // Calls are rejected until the cooldown ends
+	circuitHalfOpen                     // A single probe call is let through
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// BreakerConfig configures the circuit breaker of every upstream.
+type BreakerConfig struct {
+	FailureThreshold int           // Consecutive failures that open the circuit
+	Cooldown         time.Duration // How long the circuit stays open before a probe
+}
+
+// breakerConfigFromEnv reads BREAKER_FAILURE_THRESHOLD and BREAKER_COOLDOWN (e.g. "30s").
+func breakerConfigFromEnv() BreakerConfig {
+	config := BreakerConfig{FailureThreshold: defaultBreakerThreshold, Cooldown: defaultBreakerCooldown}
+
+	if value := os.Getenv("BREAKER_FAILURE_THRESHOLD"); value != "" {
+		threshold, err := strconv.Atoi(value)
+		if err != nil || threshold < 1 {
+			log.Printf("Invalid BREAKER_FAILURE_THRESHOLD %q, using %d", value, defaultBreakerThreshold)
+		} else {
+			config.FailureThreshold = threshold
+		}
+	}
+	if value := os.Getenv("BREAKER_COOLDOWN"); value != "" {
+		cooldown, err := time.ParseDuration(value)
+		if err != nil || cooldown <= 0 {
+			log.Printf("Invalid BREAKER_COOLDOWN %q, using %v", value, defaultBreakerCooldown)
+		} else {
+			config.Cooldown = cooldown
+		}
+	}
+	return config
+}
+
+// CircuitBreaker stops calls to an upstream that keeps failing. It opens
+// after FailureThreshold consecutive failures, rejects calls while open, and
+// after Cooldown lets a single probe through (half-open): a success closes
+// the circuit again, a failure reopens it for another cooldown.
+type CircuitBreaker struct {
+	mu       sync.Mutex
+	config   BreakerConfig
+	state    circuitState
+	failures int
+	openedAt time.Time
+}
+
+// NewCircuitBreaker creates a closed circuit breaker.
+func NewCircuitBreaker(config BreakerConfig) *CircuitBreaker {
+	return &CircuitBreaker{config: config}
+}
+
+// Allow reports whether a call may go through now. In the half-open state
+// only the first caller gets to probe.
+func (cb *CircuitBreaker) Allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case circuitOpen:
+		if time.Since(cb.openedAt) < cb.config.Cooldown {
+			return false
+		}
+		cb.state = circuitHalfOpen
+		return true
+	case circuitHalfOpen:
+		return false
+	default:
+		return true
+	}
+}
+
+// Record updates the breaker with the outcome of a call it allowed.
+func (cb *CircuitBreaker) Record(failed bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if !failed {
+		cb.state = circuitClosed
+		cb.failures = 0
+		return
+	}
+
+	cb.failures++
+	if cb.state == circuitHalfOpen || cb.failures >= cb.config.FailureThreshold {
+		cb.state = circuitOpen
+		cb.openedAt = time.Now()
+	}
+}
+
+// Abandon reports that a call the breaker allowed ended without an outcome,
+// e.g. because the client cancelled it. A half-open probe goes back to open
+// without restarting the cooldown, so the next caller probes instead; leaving
+// it half-open would reject every call from then on.
+func (cb *CircuitBreaker) Abandon() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == circuitHalfOpen {
+		cb.state = circuitOpen
+	}
+}
+
+// State returns the current state as shown by /upstreams.
+func (cb *CircuitBreaker) State() string {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state.String()
+}
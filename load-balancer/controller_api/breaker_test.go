@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyUpstream serves 500s while failing is set and counts the calls it gets.
+type flakyUpstream struct {
+	failing atomic.Bool
+	delay   atomic.Int64 // Nanoseconds to wait before answering
+	calls   atomic.Int64
+}
+
+func (u *flakyUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.calls.Add(1)
+	select {
+	case <-time.After(time.Duration(u.delay.Load())):
+	case <-r.Context().Done():
+		return
+	}
+	if u.failing.Load() {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+func TestCircuitBreakerOpensFailsFastAndCloses(t *testing.T) {
+	backend := &flakyUpstream{}
+	backend.failing.Store(true)
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	cooldown := 100 * time.Millisecond
+	pool := NewUpstreamPool([]string{server.URL}, time.Second, BreakerConfig{FailureThreshold: 3, Cooldown: cooldown}, nil)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		resp, err := fetchWithRetry(ctx, pool, 0, nil)
+		if err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusInternalServerError {
+			t.Fatalf("call %d: got status %d, want 500", i, resp.StatusCode)
+		}
+	}
+	if state := pool.Status()[0].Circuit; state != "open" {
+		t.Fatalf("circuit is %s after 3 failures, want open", state)
+	}
+
+	start := time.Now()
+	if _, err := fetchWithRetry(ctx, pool, 0, nil); err != errNoHealthyUpstream {
+		t.Fatalf("got %v while open, want errNoHealthyUpstream", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+		t.Errorf("open circuit took %v to fail", elapsed)
+	}
+	if calls := backend.calls.Load(); calls != 3 {
+		t.Errorf("backend got %d calls, want 3: the open circuit must not reach it", calls)
+	}
+
+	backend.failing.Store(false)
+	time.Sleep(cooldown)
+	resp, err := fetchWithRetry(ctx, pool, 0, nil)
+	if err != nil {
+		t.Fatalf("probe after cooldown: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("probe got status %d, want 200", resp.StatusCode)
+	}
+	if state := pool.Status()[0].Circuit; state != "closed" {
+		t.Fatalf("circuit is %s after a successful probe, want closed", state)
+	}
+}
+
+func TestCircuitBreakerCancelledProbeAllowsAnotherProbe(t *testing.T) {
+	backend := &flakyUpstream{}
+	backend.delay.Store(int64(time.Second))
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	cooldown := 50 * time.Millisecond
+	pool := NewUpstreamPool([]string{server.URL}, 5*time.Second, BreakerConfig{FailureThreshold: 1, Cooldown: cooldown}, nil)
+	pool.Record(server.URL, true)
+	time.Sleep(cooldown)
+
+	// The client gives up while the half-open probe is in flight
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := fetchWithRetry(ctx, pool, 0, nil); err == nil {
+		t.Fatal("cancelled probe returned no error")
+	}
+	if state := pool.Status()[0].Circuit; state != "open" {
+		t.Fatalf("circuit is %s after a cancelled probe, want open", state)
+	}
+
+	backend.delay.Store(0)
+	resp, err := fetchWithRetry(context.Background(), pool, 0, nil)
+	if err != nil {
+		t.Fatalf("second probe: %v", err)
+	}
+	resp.Body.Close()
+	if state := pool.Status()[0].Circuit; state != "closed" {
+		t.Fatalf("circuit is %s after a successful probe, want closed", state)
+	}
+}
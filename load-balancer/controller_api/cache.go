@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultCacheMaxEntries is used when CACHE_MAX_ENTRIES is not set.
+	defaultCacheMaxEntries = 1000
+	// maxCachedBodyBytes is the largest repository response body that is cached.
+	// Larger ones are still streamed to the client, just not kept.
+	maxCachedBodyBytes = 1 << 20
+)
+
+// CacheConfig configures the controller's response cache.
+type CacheConfig struct {
+	TTL        time.Duration // How long a response is served from the cache; 0 disables it
+	MaxEntries int           // Beyond this, the least recently used response is evicted
+}
+
+// cacheConfigFromEnv reads CACHE_TTL (e.g. "2s") and CACHE_MAX_ENTRIES.
+// The cache is disabled unless CACHE_TTL is set.
+func cacheConfigFromEnv() CacheConfig {
+	config := CacheConfig{MaxEntries: defaultCacheMaxEntries}
+
+	if value := os.Getenv("CACHE_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl < 0 {
+			log.Printf("Invalid CACHE_TTL %q, disabling the response cache", value)
+		} else {
+			config.TTL = ttl
+		}
+	}
+	if value := os.Getenv("CACHE_MAX_ENTRIES"); value != "" {
+		maxEntries, err := strconv.Atoi(value)
+		if err != nil || maxEntries < 1 {
+			log.Printf("Invalid CACHE_MAX_ENTRIES %q, using %d", value, defaultCacheMaxEntries)
+		} else {
+			config.MaxEntries = maxEntries
+		}
+	}
+	return config
+}
+
+// CachedResponse is a repository response kept by ResponseCache.
+type CachedResponse struct {
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// cacheEntry is a cached response and the key it is stored under.
+type cacheEntry struct {
+	key      string
+	response CachedResponse
+	expires  time.Time
+}
+
+// ResponseCache keeps repository responses in memory for a fixed TTL, so
+// identical requests within that window skip the repository and its
+// artificial latency. It holds at most MaxEntries responses and evicts the
+// least recently used one beyond that.
+type ResponseCache struct {
+	mu      sync.Mutex
+	config  CacheConfig
+	entries map[string]*list.Element // Key -> element of lru holding a *cacheEntry
+	lru     *list.List               // Most recently used at the front
+	now     func() time.Time
+}
+
+// NewResponseCache creates an empty cache.
+func NewResponseCache(config CacheConfig) *ResponseCache {
+	return &ResponseCache{
+		config:  config,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		now:     time.Now,
+	}
+}
+
+// Get returns the response cached under key, if there is one that has not expired.
+func (c *ResponseCache) Get(key string) (CachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return CachedResponse{}, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.lru.Remove(element)
+		delete(c.entries, key)
+		return CachedResponse{}, false
+	}
+	c.lru.MoveToFront(element)
+	return entry.response, true
+}
+
+// Set caches response under key for the configured TTL, replacing any
+// previous response and evicting the least recently used one if the cache is full.
+func (c *ResponseCache) Set(key string, response CachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := c.now().Add(c.config.TTL)
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		entry.response, entry.expires = response, expires
+		c.lru.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, response: response, expires: expires})
+	if c.lru.Len() > c.config.MaxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// bodyRecorder keeps a copy of what is written through it, up to
+// maxCachedBodyBytes; beyond that it drops the copy and marks it truncated.
+type bodyRecorder struct {
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (r *bodyRecorder) Write(p []byte) (int, error) {
+	if r.truncated {
+		return len(p), nil
+	}
+	if r.buf.Len()+len(p) > maxCachedBodyBytes {
+		r.truncated = true
+		r.buf = bytes.Buffer{}
+		return len(p), nil
+	}
+	return r.buf.Write(p)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingBackend answers every call with status and a body holding the
+// number of calls so far, so a cached response is told apart from a new one.
+func countingBackend(status int) (http.Handler, *atomic.Int64) {
+	var calls atomic.Int64
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "response %d", calls.Add(1))
+	}), &calls
+}
+
+// newCachedDataHandler returns the /data handler over backend with a
+// one-minute cache, and the cache.
+func newCachedDataHandler(t *testing.T, backend http.Handler) (http.Handler, *ResponseCache) {
+	t.Helper()
+	server := httptest.NewServer(backend)
+	t.Cleanup(server.Close)
+	cache := NewResponseCache(CacheConfig{TTL: time.Minute, MaxEntries: 10})
+	pool := NewUpstreamPool([]string{server.URL}, time.Second, testBreaker, nil)
+	return newDataHandler(pool, 0, cache), cache
+}
+
+func request(handler http.Handler, method string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(method, "/data", nil))
+	return rec
+}
+
+func TestCacheServesRepeatedGetsWithinTheTTL(t *testing.T) {
+	captureLog(t)
+	backend, calls := countingBackend(http.StatusOK)
+	handler, cache := newCachedDataHandler(t, backend)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	first, second := request(handler, http.MethodGet), request(handler, http.MethodGet)
+	if first.Body.String() != "response 1" || second.Body.String() != "response 1" {
+		t.Errorf("two GETs within the TTL got %q and %q, want the same response", first.Body, second.Body)
+	}
+	if first.Header().Get("X-Cache") != "MISS" || second.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("X-Cache = %q then %q, want MISS then HIT", first.Header().Get("X-Cache"), second.Header().Get("X-Cache"))
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("the repository got %d calls, want 1", n)
+	}
+
+	now = now.Add(time.Minute)
+	if got := request(handler, http.MethodGet).Body.String(); got != "response 2" {
+		t.Errorf("after the TTL got %q, want a new response", got)
+	}
+}
+
+func TestCacheIsBypassedByOtherMethods(t *testing.T) {
+	captureLog(t)
+	backend, calls := countingBackend(http.StatusOK)
+	handler, _ := newCachedDataHandler(t, backend)
+
+	for i := 1; i <= 2; i++ {
+		rec := request(handler, http.MethodPost)
+		if want := fmt.Sprintf("response %d", i); rec.Body.String() != want {
+			t.Errorf("POST %d got %q, want %q", i, rec.Body, want)
+		}
+		if got := rec.Header().Get("X-Cache"); got != "" {
+			t.Errorf("POST %d has X-Cache %q, want none", i, got)
+		}
+	}
+	// The POSTs did not fill the cache either
+	if rec := request(handler, http.MethodGet); rec.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("a GET after POSTs has X-Cache %q, want MISS", rec.Header().Get("X-Cache"))
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("the repository got %d calls, want 3", n)
+	}
+}
+
+func TestCacheSkipsErrorResponses(t *testing.T) {
+	captureLog(t)
+	backend, calls := countingBackend(http.StatusInternalServerError)
+	handler, _ := newCachedDataHandler(t, backend)
+
+	request(handler, http.MethodGet)
+	rec := request(handler, http.MethodGet)
+	if rec.Code != http.StatusInternalServerError || rec.Body.String() != "response 2" {
+		t.Errorf("the second GET got %d %q, want a new 500", rec.Code, rec.Body)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("the repository got %d calls, want 2", n)
+	}
+}
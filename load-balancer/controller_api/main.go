@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"net/http"
@@ -8,15 +11,73 @@ import (
 )
 
 func main() {
-	// Internal address of our load balancer (HAProxy)
-	repositoryServiceUrl := "http://haproxy:8081/data"
+	// Repository URLs, by default the internal address of our load balancer (HAProxy)
+	urls := upstreamURLsFromEnv()
+	useHTTPS, tlsConfig, err := upstreamTLSFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if useHTTPS {
+		urls = withHTTPS(urls)
+	}
+	pool := NewUpstreamPool(urls, upstreamTimeoutFromEnv(), breakerConfigFromEnv(), tlsConfig)
+	pool.StartHealthChecks(context.Background(), healthCheckInterval)
+	maxRetries := maxRetriesFromEnv()
 
-	http.HandleFunc("/data", func(w http.ResponseWriter, r *http.Request) {
+	// Optional cache of repository responses, keyed by path and query
+	var cache *ResponseCache
+	if cacheConfig := cacheConfigFromEnv(); cacheConfig.TTL > 0 {
+		cache = NewResponseCache(cacheConfig)
+		log.Printf("Caching repository responses for %v (at most %d)", cacheConfig.TTL, cacheConfig.MaxEntries)
+	}
+
+	http.Handle("/data", newDataHandler(pool, maxRetries, cache))
+
+	// Current health of the repository upstreams
+	http.HandleFunc("/upstreams", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pool.Status())
+	})
+
+	log.Println("Controller server listening on port 8000...")
+	log.Fatal(listenAndServe(":8000", nil))
+}
+
+// newDataHandler returns the /data handler: it fetches a message from the
+// repository upstreams in pool, retrying up to maxRetries times, and streams
+// it to the client. cache, if not nil, serves repeated GET and HEAD requests
+// without calling the repository; other methods always reach it.
+func newDataHandler(pool *UpstreamPool, maxRetries int, cache *ResponseCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		hostname, _ := os.Hostname()
-		log.Printf("Controller node '%s' received a request.", hostname)
+		requestID := r.Header.Get(requestIDHeader)
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+		log.Printf("[%s] Controller node '%s' received a request.", requestID, hostname)
+
+		cacheKey := r.URL.RequestURI()
+		useCache := cache != nil && (r.Method == http.MethodGet || r.Method == http.MethodHead)
+		if useCache {
+			if cached, ok := cache.Get(cacheKey); ok {
+				w.Header().Set("Content-Type", cached.ContentType)
+				w.Header().Set("X-Controller-Node-ID", hostname)
+				w.Header().Set("X-Cache", "HIT")
+				w.WriteHeader(cached.StatusCode)
+				w.Write(cached.Body)
+				return
+			}
+			w.Header().Set("X-Cache", "MISS")
+		}
 
-		// Call the repository service through HAProxy
-		resp, err := http.Get(repositoryServiceUrl)
+		// Call the repository service, retrying failed calls on other upstreams
+		header := http.Header{requestIDHeader: {requestID}}
+		resp, err := fetchWithRetry(r.Context(), pool, maxRetries, header)
+		if errors.Is(err, errNoHealthyUpstream) {
+			http.Error(w, "No healthy repository service available", http.StatusServiceUnavailable)
+			return
+		}
 		if err != nil {
 			http.Error(w, "Error calling repository service: "+err.Error(), http.StatusServiceUnavailable)
 			return
@@ -28,9 +89,24 @@ func main() {
 		// Add a header to know which controller responded
 		w.Header().Set("X-Controller-Node-ID", hostname)
 		w.WriteHeader(resp.StatusCode)
-		io.Copy(w, resp.Body)
-	})
 
-	log.Println("Controller server listening on port 8000...")
-	log.Fatal(http.ListenAndServe(":8000", nil))
-}
\ No newline at end of file
+		// Only complete, successful responses are cached
+		cacheable := useCache && resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices
+		body := io.Reader(resp.Body)
+		recorder := &bodyRecorder{}
+		if cacheable {
+			body = io.TeeReader(resp.Body, recorder)
+		}
+		if err := streamBody(w, body); err != nil {
+			log.Printf("[%s] Error streaming the repository response: %v", requestID, err)
+			return
+		}
+		if cacheable && !recorder.truncated {
+			cache.Set(cacheKey, CachedResponse{
+				StatusCode:  resp.StatusCode,
+				ContentType: resp.Header.Get("Content-Type"),
+				Body:        recorder.buf.Bytes(),
+			})
+		}
+	}
+}
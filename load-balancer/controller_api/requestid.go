@@ -0,0 +1,19 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// requestIDHeader carries the ID that ties together the log lines of the
+// controller and the repository for a single request.
+const requestIDHeader = "X-Request-ID"
+
+// newRequestID returns a random (version 4) UUID.
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40 // Version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
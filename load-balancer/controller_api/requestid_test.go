@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// captureLog returns a buffer receiving the standard logger's output for the
+// rest of the test.
+func captureLog(t *testing.T) *syncBuffer {
+	buf, previous := &syncBuffer{}, log.Writer()
+	log.SetOutput(buf)
+	t.Cleanup(func() { log.SetOutput(previous) })
+	return buf
+}
+
+// syncBuffer is a bytes.Buffer safe for the concurrent writes of handlers.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// newTestDataHandler returns the /data handler over a single upstream
+// running backend, with no retries and no cache.
+func newTestDataHandler(t *testing.T, backend http.Handler) http.Handler {
+	t.Helper()
+	server := httptest.NewServer(backend)
+	t.Cleanup(server.Close)
+	return newDataHandler(NewUpstreamPool([]string{server.URL}, time.Second, testBreaker, nil), 0, nil)
+}
+
+func TestRequestIDIsPropagatedAndLogged(t *testing.T) {
+	logs := captureLog(t)
+	var received []string
+	handler := newTestDataHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get(requestIDHeader))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/data", nil)
+	req.Header.Set(requestIDHeader, "client-chosen-id")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get(requestIDHeader); got != "client-chosen-id" {
+		t.Errorf("response %s = %q, want the client's", requestIDHeader, got)
+	}
+	if len(received) != 1 || received[0] != "client-chosen-id" {
+		t.Errorf("the repository received request IDs %q, want the client's", received)
+	}
+	if !strings.Contains(logs.String(), "[client-chosen-id] Controller node") {
+		t.Errorf("the log does not carry the request ID:\n%s", logs)
+	}
+
+	// Without one, the controller generates a UUID and passes it on.
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/data", nil))
+	generated := rec.Header().Get(requestIDHeader)
+	uuidV4 := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if !uuidV4.MatchString(generated) {
+		t.Errorf("generated request ID %q is not a version 4 UUID", generated)
+	}
+	if len(received) != 2 || received[1] != generated {
+		t.Errorf("the repository received request IDs %q, want the generated %q last", received, generated)
+	}
+}
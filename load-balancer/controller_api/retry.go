@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// defaultMaxRetries is used when MAX_RETRIES is not set.
+const defaultMaxRetries = 2
+
+// The backoff delays are variables so tests can shorten them.
+var (
+	// retryBaseDelay is the backoff before the first retry; it doubles on every retry.
+	retryBaseDelay = 100 * time.Millisecond
+	// retryMaxDelay caps the exponential backoff.
+	retryMaxDelay = 2 * time.Second
+)
+
+// errNoHealthyUpstream is returned when every upstream is marked down or has an open circuit.
+var errNoHealthyUpstream = errors.New("no healthy repository service available")
+
+// fetchWithRetry calls a repository upstream, retrying on connection errors
+// and 5xx responses up to maxRetries times. Every retry goes to a freshly
+// selected upstream after an exponential backoff with full jitter. It gives
+// up as soon as ctx is done, so the client's deadline bounds all attempts.
+// After the last attempt a 5xx response is returned as is. header is sent
+// with every attempt.
+func fetchWithRetry(ctx context.Context, pool *UpstreamPool, maxRetries int, header http.Header) (*http.Response, error) {
+	delay := retryBaseDelay
+	for attempt := 0; ; attempt++ {
+		url, ok := pool.Next()
+		if !ok {
+			return nil, errNoHealthyUpstream
+		}
+
+		resp, err := pool.Get(ctx, url, header)
+		failed := err != nil || resp.StatusCode >= http.StatusInternalServerError
+		if ctx.Err() == nil {
+			pool.Record(url, failed)
+		} else {
+			// A call cut short by the client is not the upstream's fault
+			pool.Abandon(url)
+		}
+		if !failed {
+			return resp, nil
+		}
+		if attempt == maxRetries || ctx.Err() != nil {
+			return resp, err
+		}
+
+		if err == nil {
+			err = fmt.Errorf("status %d", resp.StatusCode)
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		wait := rand.N(delay) + 1
+		log.Printf("[%s] Attempt %d/%d against %s failed: %v. Retrying in %v...", header.Get(requestIDHeader), attempt+1, maxRetries+1, url, err, wait)
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		delay = min(delay*2, retryMaxDelay)
+	}
+}
+
+// maxRetriesFromEnv reads the number of retries per request from MAX_RETRIES.
+func maxRetriesFromEnv() int {
+	value := os.Getenv("MAX_RETRIES")
+	if value == "" {
+		return defaultMaxRetries
+	}
+	retries, err := strconv.Atoi(value)
+	if err != nil || retries < 0 {
+		log.Printf("Invalid MAX_RETRIES %q, using %d", value, defaultMaxRetries)
+		return defaultMaxRetries
+	}
+	return retries
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// shortBackoff shortens the retry backoff for the rest of the test.
+func shortBackoff(t *testing.T) {
+	base, maxDelay := retryBaseDelay, retryMaxDelay
+	retryBaseDelay, retryMaxDelay = time.Millisecond, 2*time.Millisecond
+	t.Cleanup(func() { retryBaseDelay, retryMaxDelay = base, maxDelay })
+}
+
+func TestFetchWithRetryRecoversFromTransientFailures(t *testing.T) {
+	shortBackoff(t)
+	var attempts atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) <= 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	pool := NewUpstreamPool([]string{server.URL}, time.Second, testBreaker, nil)
+	resp, err := fetchWithRetry(context.Background(), pool, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Errorf("got %d %q, want 200 ok", resp.StatusCode, body)
+	}
+	if n := attempts.Load(); n != 3 {
+		t.Errorf("the backend got %d attempts, want 3", n)
+	}
+}
+
+func TestFetchWithRetryReturnsTheLastFailure(t *testing.T) {
+	shortBackoff(t)
+	backend := &flakyUpstream{}
+	backend.failing.Store(true)
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	pool := NewUpstreamPool([]string{server.URL}, time.Second, testBreaker, nil)
+	resp, err := fetchWithRetry(context.Background(), pool, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("got %d after the retries ran out, want the backend's 500", resp.StatusCode)
+	}
+	if n := backend.calls.Load(); n != 3 {
+		t.Errorf("the backend got %d attempts, want 1 plus 2 retries", n)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"io"
+	"net/http"
+)
+
+// streamBufferSize is the largest chunk read from the repository before it is flushed to the client.
+const streamBufferSize = 32 * 1024
+
+// streamBody copies body to w, flushing after every chunk read so a slow or
+// large repository response reaches the client incrementally instead of
+// sitting in the server's buffers. No Content-Length is set, so the response
+// is sent with chunked transfer encoding.
+func streamBody(w http.ResponseWriter, body io.Reader) error {
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, streamBufferSize)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+				return writeErr
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
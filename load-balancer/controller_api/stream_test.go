@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDataStreamsChunksAsTheyArrive(t *testing.T) {
+	const first, second = "first chunk,", "second chunk"
+	release := make(chan struct{})
+	handler := newTestDataHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(first)+len(second)))
+		w.Write([]byte(first))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+		w.Write([]byte(second))
+	}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Length"); got != "" || resp.ContentLength != -1 {
+		t.Errorf("Content-Length %q (%d) was forwarded, want a chunked response", got, resp.ContentLength)
+	}
+
+	// The first chunk must arrive while the repository still holds the second.
+	read := make(chan string, 1)
+	go func() {
+		buf := make([]byte, len(first))
+		n, _ := io.ReadFull(resp.Body, buf)
+		read <- string(buf[:n])
+	}()
+	select {
+	case got := <-read:
+		if got != first {
+			t.Fatalf("first read %q, want %q", got, first)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the first chunk did not arrive before the second was written")
+	}
+
+	close(release)
+	rest, err := io.ReadAll(resp.Body)
+	if err != nil || string(rest) != second {
+		t.Errorf("then read %q (%v), want %q", rest, err, second)
+	}
+}
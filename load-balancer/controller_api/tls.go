@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenAndServe serves handler on addr over HTTPS when TLS_CERT_FILE and
+// TLS_KEY_FILE name a certificate and its key, and over plain HTTP when
+// neither is set. Setting only one of them is an error rather than a silent
+// downgrade to plain HTTP.
+func listenAndServe(addr string, handler http.Handler) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return serve(ln, handler)
+}
+
+// serve is listenAndServe on an existing listener, which it closes on return.
+func serve(ln net.Listener, handler http.Handler) error {
+	server := &http.Server{Handler: handler}
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if certFile == "" && keyFile == "" {
+		return server.Serve(ln)
+	}
+	if certFile == "" || keyFile == "" {
+		ln.Close()
+		return errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	log.Printf("Serving HTTPS with certificate %s", certFile)
+	return server.ServeTLS(ln, certFile, keyFile)
+}
+
+// upstreamTLSFromEnv reads how the controller dials the repositories. With
+// REPOSITORY_TLS=true, http:// upstream URLs are switched to https://.
+// REPOSITORY_CA_FILE names a PEM file of CAs trusted on top of the system
+// ones, e.g. the repositories' self-signed certificate. The returned config
+// is nil when no CA file is set.
+func upstreamTLSFromEnv() (useHTTPS bool, config *tls.Config, err error) {
+	if value := os.Getenv("REPOSITORY_TLS"); value != "" {
+		useHTTPS, err = strconv.ParseBool(value)
+		if err != nil {
+			return false, nil, fmt.Errorf("invalid REPOSITORY_TLS %q: %w", value, err)
+		}
+	}
+
+	caFile := os.Getenv("REPOSITORY_CA_FILE")
+	if caFile == "" {
+		return useHTTPS, nil, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return false, nil, fmt.Errorf("reading REPOSITORY_CA_FILE: %w", err)
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if !roots.AppendCertsFromPEM(pem) {
+		return false, nil, fmt.Errorf("no PEM certificate found in REPOSITORY_CA_FILE %s", caFile)
+	}
+	return useHTTPS, &tls.Config{RootCAs: roots}, nil
+}
+
+// withHTTPS switches the http:// URLs to https://, keeping the host and path.
+func withHTTPS(urls []string) []string {
+	switched := make([]string, len(urls))
+	for i, url := range urls {
+		if rest, ok := strings.CutPrefix(url, "http://"); ok {
+			url = "https://" + rest
+		}
+		switched[i] = url
+	}
+	return switched
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeSelfSignedCert writes a self-signed certificate for 127.0.0.1 and its
+// key to PEM files, as the deployment does with its own certificate.
+func writeSelfSignedCert(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// serveForTest runs serve on a local port until the end of the test and
+// returns the listener's address.
+func serveForTest(t *testing.T, handler http.Handler) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- serve(ln, handler) }()
+	t.Cleanup(func() {
+		ln.Close()
+		if err := <-done; err != nil && !errors.Is(err, net.ErrClosed) {
+			t.Errorf("serve: %v", err)
+		}
+	})
+	return ln.Addr().String()
+}
+
+func TestControllerServesAndDialsRepositoriesOverTLS(t *testing.T) {
+	certFile, keyFile := writeSelfSignedCert(t)
+	t.Setenv("TLS_CERT_FILE", certFile)
+	t.Setenv("TLS_KEY_FILE", keyFile)
+	t.Setenv("REPOSITORY_TLS", "true")
+	t.Setenv("REPOSITORY_CA_FILE", certFile)
+	previous := log.Writer()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(previous) })
+
+	repository := serveForTest(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil {
+			t.Error("the controller reached the repository without TLS")
+		}
+		io.WriteString(w, "from the repository")
+	}))
+
+	useHTTPS, tlsConfig, err := upstreamTLSFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !useHTTPS || tlsConfig == nil {
+		t.Fatalf("upstreamTLSFromEnv() = %v, %v, want HTTPS with the CA file", useHTTPS, tlsConfig)
+	}
+	urls := withHTTPS([]string{"http://" + repository + "/data"})
+	pool := NewUpstreamPool(urls, time.Second, testBreaker, tlsConfig)
+	mux := http.NewServeMux()
+	mux.Handle("/data", newDataHandler(pool, 0, nil))
+	controller := serveForTest(t, mux)
+
+	roots := x509.NewCertPool()
+	pemBytes, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots.AppendCertsFromPEM(pemBytes)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	t.Cleanup(client.CloseIdleConnections)
+
+	resp, err := client.Get("https://" + controller + "/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "from the repository") {
+		t.Errorf("GET /data over HTTPS: got %d %q, want 200 from the repository", resp.StatusCode, body)
+	}
+}
+
+func TestUpstreamTLSFromEnvRejectsAFileWithoutCertificates(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("REPOSITORY_CA_FILE", caFile)
+	if _, _, err := upstreamTLSFromEnv(); err == nil {
+		t.Error("upstreamTLSFromEnv accepted a CA file without certificates")
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultRepositoryURL is used when REPOSITORY_URLS is not set: everything goes through HAProxy.
+	defaultRepositoryURL = "http://haproxy:8081/data"
+	// healthCheckInterval is the time between two rounds of probes.
+	healthCheckInterval = 5 * time.Second
+	// defaultUpstreamTimeout bounds a single call or probe when UPSTREAM_TIMEOUT is not set.
+	// The repository sleeps up to 10s before answering.
+	defaultUpstreamTimeout = 11 * time.Second
+)
+
+// upstream is a repository URL and its last known health.
+type upstream struct {
+	url       string
+	healthy   bool
+	lastCheck time.Time
+	lastError string
+	breaker   *CircuitBreaker
+}
+
+// UpstreamStatus is the health of one upstream as shown by /upstreams.
+type UpstreamStatus struct {
+	URL       string    `json:"url"`
+	Healthy   bool      `json:"healthy"`
+	LastCheck time.Time `json:"last_check"`
+	LastError string    `json:"last_error,omitempty"`
+	Circuit   string    `json:"circuit"`
+}
+
+// UpstreamPool tracks the health of the repository upstreams, probing them
+// periodically, and hands out the healthy ones in round-robin order.
+type UpstreamPool struct {
+	mu        sync.RWMutex
+	upstreams []*upstream
+	next      atomic.Uint64
+	client    *http.Client
+}
+
+// NewUpstreamPool creates a pool for the given URLs. Upstreams start out
+// healthy so requests are served before the first round of probes completes.
+// Calls and probes time out after timeout, and every upstream gets its own
+// circuit breaker. tlsConfig, if not nil, is used for https:// upstreams.
+func NewUpstreamPool(urls []string, timeout time.Duration, breaker BreakerConfig, tlsConfig *tls.Config) *UpstreamPool {
+	p := &UpstreamPool{
+		client: &http.Client{Timeout: timeout},
+	}
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		p.client.Transport = transport
+	}
+	for _, url := range urls {
+		p.upstreams = append(p.upstreams, &upstream{url: url, healthy: true, breaker: NewCircuitBreaker(breaker)})
+	}
+	return p
+}
+
+// upstreamTimeoutFromEnv reads the per-call timeout from UPSTREAM_TIMEOUT (e.g. "5s").
+func upstreamTimeoutFromEnv() time.Duration {
+	value := os.Getenv("UPSTREAM_TIMEOUT")
+	if value == "" {
+		return defaultUpstreamTimeout
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		log.Printf("Invalid UPSTREAM_TIMEOUT %q, using %v", value, defaultUpstreamTimeout)
+		return defaultUpstreamTimeout
+	}
+	return timeout
+}
+
+// upstreamURLsFromEnv reads the comma-separated repository URLs from REPOSITORY_URLS.
+func upstreamURLsFromEnv() []string {
+	value := os.Getenv("REPOSITORY_URLS")
+	if value == "" {
+		return []string{defaultRepositoryURL}
+	}
+	var urls []string
+	for _, url := range strings.Split(value, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// Next returns the next healthy upstream whose circuit breaker allows a
+// call, in round-robin order, or false if there is none. The outcome of the
+// call must be reported with Record, or with Abandon if it has none.
+func (p *UpstreamPool) Next() (string, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	n := uint64(len(p.upstreams))
+	start := p.next.Add(1) - 1
+	for i := uint64(0); i < n; i++ {
+		if u := p.upstreams[(start+i)%n]; u.healthy && u.breaker.Allow() {
+			return u.url, true
+		}
+	}
+	return "", false
+}
+
+// Record reports the outcome of a call to url to its circuit breaker.
+func (p *UpstreamPool) Record(url string, failed bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, u := range p.upstreams {
+		if u.url == url {
+			u.breaker.Record(failed)
+			return
+		}
+	}
+}
+
+// Abandon reports to the circuit breaker of url that a call ended without an outcome.
+func (p *UpstreamPool) Abandon(url string) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, u := range p.upstreams {
+		if u.url == url {
+			u.breaker.Abandon()
+			return
+		}
+	}
+}
+
+// Get performs a GET to url bound to ctx, with the pool's timeout and the given extra headers.
+func (p *UpstreamPool) Get(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	return p.client.Do(req)
+}
+
+// Status returns the current health of every upstream.
+func (p *UpstreamPool) Status() []UpstreamStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	statuses := make([]UpstreamStatus, len(p.upstreams))
+	for i, u := range p.upstreams {
+		statuses[i] = UpstreamStatus{URL: u.url, Healthy: u.healthy, LastCheck: u.lastCheck, LastError: u.lastError, Circuit: u.breaker.State()}
+	}
+	return statuses
+}
+
+// StartHealthChecks probes every upstream now and then every interval, until ctx is cancelled.
+func (p *UpstreamPool) StartHealthChecks(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			p.checkAll(ctx)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// checkAll probes all upstreams concurrently and records the results.
+func (p *UpstreamPool) checkAll(ctx context.Context) {
+	p.mu.RLock()
+	upstreams := append([]*upstream(nil), p.upstreams...)
+	p.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, u := range upstreams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := p.probe(ctx, u.url)
+
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if wasHealthy := u.healthy; wasHealthy != (err == nil) {
+				log.Printf("Upstream %s is now %s", u.url, healthLabel(err == nil))
+			}
+			u.healthy = err == nil
+			u.lastCheck = time.Now()
+			u.lastError = ""
+			if err != nil {
+				u.lastError = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// probe requests url and treats any 5xx or transport error as unhealthy.
+func (p *UpstreamPool) probe(ctx context.Context, url string) error {
+	resp, err := p.Get(ctx, url, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("upstream returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func healthLabel(healthy bool) string {
+	if healthy {
+		return "UP"
+	}
+	return "DOWN"
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testBreaker is a breaker configuration that never opens within a test.
+var testBreaker = BreakerConfig{FailureThreshold: 100, Cooldown: time.Minute}
+
+func TestHealthCheckSendsRequestsOnlyToHealthyUpstreams(t *testing.T) {
+	healthy, failing := &flakyUpstream{}, &flakyUpstream{}
+	failing.failing.Store(true)
+	healthyServer, failingServer := httptest.NewServer(healthy), httptest.NewServer(failing)
+	defer healthyServer.Close()
+	defer failingServer.Close()
+
+	pool := NewUpstreamPool([]string{failingServer.URL, healthyServer.URL}, time.Second, testBreaker, nil)
+	ctx := context.Background()
+	pool.checkAll(ctx)
+	if status := pool.Status(); status[0].Healthy || !status[1].Healthy {
+		t.Fatalf("after the health check: %+v, want only the second upstream healthy", status)
+	}
+
+	const requests = 10
+	for i := 0; i < requests; i++ {
+		resp, err := fetchWithRetry(ctx, pool, 0, nil)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: got status %d, want 200", i, resp.StatusCode)
+		}
+	}
+	// Each backend also got the health check's probe.
+	if calls := failing.calls.Load(); calls != 1 {
+		t.Errorf("the failing upstream got %d calls, want only the probe", calls)
+	}
+	if calls := healthy.calls.Load(); calls != requests+1 {
+		t.Errorf("the healthy upstream got %d calls, want the probe and %d requests", calls, requests)
+	}
+
+	// Once it recovers, the next round of probes brings it back.
+	failing.failing.Store(false)
+	pool.checkAll(ctx)
+	if !pool.Status()[0].Healthy {
+		t.Error("the recovered upstream is still marked down")
+	}
+}
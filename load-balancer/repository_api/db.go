@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// dbCheckInterval is the time between two pings of the database.
+	dbCheckInterval = 5 * time.Second
+	// dbPingTimeout bounds a single ping.
+	dbPingTimeout = 2 * time.Second
+)
+
+// Database is the part of *sql.DB the repository uses.
+type Database interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	PingContext(ctx context.Context) error
+	Close() error
+}
+
+// ResilientDB keeps track of whether the database is reachable and re-opens
+// the connection pool when it is not, e.g. after Postgres restarts, so the
+// service recovers without being restarted itself.
+type ResilientDB struct {
+	mu        sync.RWMutex
+	db        Database
+	available bool
+	open      func() (Database, error)
+	checkMu   sync.Mutex // Only one Check re-opens the pool at a time
+}
+
+// NewResilientDB opens the database with open. The database is assumed
+// available until the first ping says otherwise.
+func NewResilientDB(open func() (Database, error)) (*ResilientDB, error) {
+	db, err := open()
+	if err != nil {
+		return nil, err
+	}
+	return &ResilientDB{db: db, available: true, open: open}, nil
+}
+
+// Get returns the current database, or false if it is known to be unavailable.
+func (r *ResilientDB) Get() (Database, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.db, r.available
+}
+
+// Check pings the database. If the ping fails, the database is marked
+// unavailable and the pool is re-opened; it becomes available again once a
+// ping on the new pool succeeds. It reports whether the database is available.
+func (r *ResilientDB) Check(ctx context.Context) bool {
+	r.checkMu.Lock()
+	defer r.checkMu.Unlock()
+
+	db, _ := r.Get()
+	if ping(ctx, db) == nil {
+		r.setAvailable(true)
+		return true
+	}
+	r.setAvailable(false)
+
+	newDB, err := r.open()
+	if err != nil {
+		log.Printf("Error re-opening the database: %v", err)
+		return false
+	}
+	if err := ping(ctx, newDB); err != nil {
+		log.Printf("Database still unavailable: %v", err)
+		newDB.Close()
+		return false
+	}
+
+	r.mu.Lock()
+	old := r.db
+	r.db = newDB
+	r.mu.Unlock()
+	old.Close()
+	r.setAvailable(true)
+	return true
+}
+
+// StartChecks runs Check every interval until ctx is cancelled.
+func (r *ResilientDB) StartChecks(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				r.Check(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Close closes the current database.
+func (r *ResilientDB) Close() error {
+	db, _ := r.Get()
+	return db.Close()
+}
+
+func (r *ResilientDB) setAvailable(available bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.available != available {
+		if available {
+			log.Println("Database is available again")
+		} else {
+			log.Println("Database is unavailable")
+		}
+	}
+	r.available = available
+}
+
+func ping(ctx context.Context, db Database) error {
+	ctx, cancel := context.WithTimeout(ctx, dbPingTimeout)
+	defer cancel()
+	return db.PingContext(ctx)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// fakePostgres is a database/sql connector standing in for Postgres. While
+// down is set, new connections are refused and existing ones report
+// driver.ErrBadConn, like after a restart. If hold is not nil, queries block
+// until it is closed.
+type fakePostgres struct {
+	down atomic.Bool
+	hold chan struct{}
+}
+
+func (f *fakePostgres) Connect(ctx context.Context) (driver.Conn, error) {
+	if f.down.Load() {
+		return nil, errors.New("connection refused")
+	}
+	return fakeConn{f}, nil
+}
+
+func (f *fakePostgres) Driver() driver.Driver { return nil }
+
+// fakeConn answers every query with a single "hello" message.
+type fakeConn struct {
+	db *fakePostgres
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (c fakeConn) Close() error { return nil }
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+func (c fakeConn) Ping(ctx context.Context) error {
+	if c.db.down.Load() {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+func (c fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if c.db.down.Load() {
+		return nil, driver.ErrBadConn
+	}
+	if c.db.hold != nil {
+		select {
+		case <-c.db.hold:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return &fakeRows{}, nil
+}
+
+type fakeRows struct {
+	done bool
+}
+
+func (r *fakeRows) Columns() []string { return []string{"message"} }
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = "hello"
+	return nil
+}
+
+// newFakeDB returns a ResilientDB over fake and a counter of the pools it opened.
+func newFakeDB(t *testing.T, fake *fakePostgres) (*ResilientDB, *atomic.Int64) {
+	t.Helper()
+	var opens atomic.Int64
+	db, err := NewResilientDB(func() (Database, error) {
+		opens.Add(1)
+		return sql.OpenDB(fake), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, &opens
+}
+
+func getData(handler http.Handler, ctx context.Context) int {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/data", nil).WithContext(ctx))
+	return rec.Code
+}
+
+func TestDataReturns503WhileDatabaseIsDown(t *testing.T) {
+	fake := &fakePostgres{}
+	db, _ := newFakeDB(t, fake)
+	handler := newRepositoryHandler(db, NewMetrics(), 0)
+	ctx := context.Background()
+
+	if code := getData(handler, ctx); code != http.StatusOK {
+		t.Fatalf("got %d with the database up, want 200", code)
+	}
+
+	fake.down.Store(true)
+	for i := 0; i < 2; i++ {
+		if code := getData(handler, ctx); code != http.StatusServiceUnavailable {
+			t.Fatalf("request %d: got %d with the database down, want 503", i, code)
+		}
+	}
+
+	fake.down.Store(false)
+	if !db.Check(ctx) {
+		t.Fatal("Check did not recover the database")
+	}
+	if code := getData(handler, ctx); code != http.StatusOK {
+		t.Fatalf("got %d after recovery, want 200", code)
+	}
+}
+
+func TestCancelledRequestDoesNotReopenDatabase(t *testing.T) {
+	db, opens := newFakeDB(t, &fakePostgres{})
+	handler := newRepositoryHandler(db, NewMetrics(), 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	getData(handler, ctx)
+
+	if _, available := db.Get(); !available {
+		t.Error("a cancelled request marked the database unavailable")
+	}
+	if n := opens.Load(); n != 1 {
+		t.Errorf("the pool was opened %d times, want 1", n)
+	}
+}
@@ -1,17 +1,23 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"log"
 	"math/rand"
 	"net/http"
 	"os"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/lib/pq"
 )
 
+// defaultMaxLatencyMs is the upper bound of the artificial latency when MAX_LATENCY_MS is not set.
+const defaultMaxLatencyMs = 10000
+
 func main() {
 	// Get the connection string from the environment
 	connStr := os.Getenv("DATABASE_URL")
@@ -19,28 +25,98 @@ func main() {
 		log.Fatal("DATABASE_URL is not defined")
 	}
 
-	// Open a connection to the database
-	db, err := sql.Open("postgres", connStr)
+	// Open a connection to the database, re-opening it if it becomes unreachable
+	db, err := NewResilientDB(func() (Database, error) {
+		return sql.Open("postgres", connStr)
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer db.Close()
+	db.StartChecks(context.Background(), dbCheckInterval)
+
+	handler := newRepositoryHandler(db, NewMetrics(), maxLatencyFromEnv())
+
+	log.Println("Repository server listening on port 8001...")
+	log.Fatal(listenAndServe(":8001", handler))
+}
+
+// newRepositoryHandler returns the repository's routes: /data, /health,
+// /drain and /metrics. maxLatencyMs bounds the artificial latency of /data.
+func newRepositoryHandler(db *ResilientDB, metrics *Metrics, maxLatencyMs int) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+
+	// Set by POST /drain: new requests are refused while in-flight ones finish
+	var draining atomic.Bool
+
+	mux.HandleFunc("/drain", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		draining.Store(true)
+		log.Printf("Draining: refusing new requests, %d in flight", metrics.inFlight.Load())
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		status := "ok"
+		w.Header().Set("Content-Type", "application/json")
+		if draining.Load() {
+			status = "draining"
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": status})
+	})
 
 	// Handler for the request
-	http.HandleFunc("/data", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/data", func(w http.ResponseWriter, r *http.Request) {
+		if draining.Load() {
+			http.Error(w, "Node is draining", http.StatusServiceUnavailable)
+			return
+		}
+		metrics.requests.Add(1)
+		metrics.inFlight.Add(1)
+		defer metrics.inFlight.Add(-1)
+
 		hostname, _ := os.Hostname()
-		log.Printf("Repository node '%s' received a request.", hostname)
+		// Echo the controller's request ID so both sides can be correlated
+		requestID := r.Header.Get("X-Request-ID")
+		if requestID != "" {
+			w.Header().Set("X-Request-ID", requestID)
+		}
+		log.Printf("[%s] Repository node '%s' received a request.", requestID, hostname)
 
 		// Get a random message from the database
+		conn, ok := db.Get()
+		if !ok {
+			http.Error(w, "Database unavailable", http.StatusServiceUnavailable)
+			return
+		}
 		var message string
-		err := db.QueryRow("SELECT message FROM messages ORDER BY RANDOM() LIMIT 1").Scan(&message)
+		err := conn.QueryRowContext(r.Context(), "SELECT message FROM messages ORDER BY RANDOM() LIMIT 1").Scan(&message)
 		if err != nil {
+			if r.Context().Err() != nil {
+				// The client went away mid-query: the database is not to blame,
+				// and a ping on the cancelled context would fail and reopen the pool
+				return
+			}
+			// A query that fails because the database is gone is a 503, not a bug
+			if !db.Check(r.Context()) {
+				http.Error(w, "Database unavailable", http.StatusServiceUnavailable)
+				return
+			}
 			http.Error(w, "Error querying the database: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		// await random time between 0 and 10 seconds
-		waitTime := time.Duration(rand.Intn(10000)) * time.Millisecond
-		log.Printf("Repository node '%s' waiting for %s", hostname, waitTime)
+		// await random time between 0 and MAX_LATENCY_MS
+		var waitTime time.Duration
+		if maxLatencyMs > 0 {
+			waitTime = time.Duration(rand.Intn(maxLatencyMs)) * time.Millisecond
+		}
+		log.Printf("[%s] Repository node '%s' waiting for %s", requestID, hostname, waitTime)
+		metrics.ObserveWait(waitTime)
 		time.Sleep(waitTime)
 
 		// Respond with JSON
@@ -52,6 +128,20 @@ func main() {
 		json.NewEncoder(w).Encode(response)
 	})
 
-	log.Println("Repository server listening on port 8001...")
-	log.Fatal(http.ListenAndServe(":8001", nil))
-}
\ No newline at end of file
+	return mux
+}
+
+// maxLatencyFromEnv reads the upper bound of the artificial latency from
+// MAX_LATENCY_MS; 0 disables it.
+func maxLatencyFromEnv() int {
+	value := os.Getenv("MAX_LATENCY_MS")
+	if value == "" {
+		return defaultMaxLatencyMs
+	}
+	ms, err := strconv.Atoi(value)
+	if err != nil || ms < 0 {
+		log.Printf("Invalid MAX_LATENCY_MS %q, using %d", value, defaultMaxLatencyMs)
+		return defaultMaxLatencyMs
+	}
+	return ms
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDataEchoesAndLogsTheRequestID(t *testing.T) {
+	var logs bytes.Buffer
+	previous := log.Writer()
+	log.SetOutput(&logs)
+	defer log.SetOutput(previous)
+
+	db, _ := newFakeDB(t, &fakePostgres{})
+	handler := newRepositoryHandler(db, NewMetrics(), 0)
+	req := httptest.NewRequest(http.MethodGet, "/data", nil)
+	req.Header.Set("X-Request-ID", "controller-id")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200", rec.Code)
+	}
+	if got := rec.Header().Get("X-Request-ID"); got != "controller-id" {
+		t.Errorf("response X-Request-ID = %q, want the controller's", got)
+	}
+	if !strings.Contains(logs.String(), "[controller-id] Repository node") {
+		t.Errorf("the log does not carry the request ID:\n%s", logs.String())
+	}
+}
+
+func TestZeroMaxLatencyDisablesTheSleep(t *testing.T) {
+	t.Setenv("MAX_LATENCY_MS", "0")
+	db, _ := newFakeDB(t, &fakePostgres{})
+	handler := newRepositoryHandler(db, NewMetrics(), maxLatencyFromEnv())
+
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		if code := getData(handler, context.Background()); code != http.StatusOK {
+			t.Fatalf("request %d: got %d", i, code)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("20 requests took %v with MAX_LATENCY_MS=0", elapsed)
+	}
+}
+
+func TestMaxLatencyFromEnv(t *testing.T) {
+	for value, want := range map[string]int{
+		"":     defaultMaxLatencyMs,
+		"0":    0,
+		"250":  250,
+		"-1":   defaultMaxLatencyMs,
+		"1.5s": defaultMaxLatencyMs,
+	} {
+		t.Setenv("MAX_LATENCY_MS", value)
+		if got := maxLatencyFromEnv(); got != want {
+			t.Errorf("MAX_LATENCY_MS=%q: got %d, want %d", value, got, want)
+		}
+	}
+}
+
+func TestDrainRefusesNewRequestsAndFinishesInFlightOnes(t *testing.T) {
+	fake := &fakePostgres{hold: make(chan struct{})}
+	db, _ := newFakeDB(t, fake)
+	metrics := NewMetrics()
+	handler := newRepositoryHandler(db, metrics, 0)
+
+	inFlight := make(chan int, 1)
+	go func() { inFlight <- getData(handler, context.Background()) }()
+	deadline := time.Now().Add(time.Second)
+	for metrics.inFlight.Load() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("the first request never started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/drain", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("POST /drain: got %d, want 204", rec.Code)
+	}
+
+	if code := getData(handler, context.Background()); code != http.StatusServiceUnavailable {
+		t.Errorf("/data while draining: got %d, want 503", code)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), `"draining"`) {
+		t.Errorf("/health while draining: got %d %s, want 503 draining", rec.Code, rec.Body)
+	}
+
+	close(fake.hold)
+	select {
+	case code := <-inFlight:
+		if code != http.StatusOK {
+			t.Errorf("the request started before the drain got %d, want 200", code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the request started before the drain did not complete")
+	}
+	if n := metrics.inFlight.Load(); n != 0 {
+		t.Errorf("%d requests still in flight", n)
+	}
+}
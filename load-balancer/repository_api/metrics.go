@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// waitBuckets are the upper bounds, in seconds, of the wait time histogram buckets.
+var waitBuckets = []float64{0.5, 1, 2.5, 5, 7.5, 10}
+
+// Metrics holds the repository's counters, updated with atomic operations so
+// the request path never takes a lock.
+type Metrics struct {
+	requests     atomic.Uint64
+	inFlight     atomic.Int64
+	waitBuckets  []atomic.Uint64 // Non-cumulative count per bucket, plus one for +Inf
+	waitSumNanos atomic.Int64
+	waitCount    atomic.Uint64
+}
+
+// NewMetrics creates zeroed metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{waitBuckets: make([]atomic.Uint64, len(waitBuckets)+1)}
+}
+
+// ObserveWait records one artificial wait time in the histogram.
+func (m *Metrics) ObserveWait(d time.Duration) {
+	i := 0
+	for i < len(waitBuckets) && d.Seconds() > waitBuckets[i] {
+		i++
+	}
+	m.waitBuckets[i].Add(1)
+	m.waitSumNanos.Add(int64(d))
+	m.waitCount.Add(1)
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP repository_requests_total Requests received on /data.")
+	fmt.Fprintln(w, "# TYPE repository_requests_total counter")
+	fmt.Fprintf(w, "repository_requests_total %d\n", m.requests.Load())
+
+	fmt.Fprintln(w, "# HELP repository_requests_in_flight Requests on /data currently being served.")
+	fmt.Fprintln(w, "# TYPE repository_requests_in_flight gauge")
+	fmt.Fprintf(w, "repository_requests_in_flight %d\n", m.inFlight.Load())
+
+	fmt.Fprintln(w, "# HELP repository_wait_seconds Artificial latency added to /data responses.")
+	fmt.Fprintln(w, "# TYPE repository_wait_seconds histogram")
+	var cumulative uint64
+	for i, bound := range waitBuckets {
+		cumulative += m.waitBuckets[i].Load()
+		fmt.Fprintf(w, "repository_wait_seconds_bucket{le=\"%g\"} %d\n", bound, cumulative)
+	}
+	cumulative += m.waitBuckets[len(waitBuckets)].Load()
+	fmt.Fprintf(w, "repository_wait_seconds_bucket{le=\"+Inf\"} %d\n", cumulative)
+	fmt.Fprintf(w, "repository_wait_seconds_sum %g\n", time.Duration(m.waitSumNanos.Load()).Seconds())
+	fmt.Fprintf(w, "repository_wait_seconds_count %d\n", m.waitCount.Load())
+}
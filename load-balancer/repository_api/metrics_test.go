@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsCountDataRequests(t *testing.T) {
+	db, _ := newFakeDB(t, &fakePostgres{})
+	handler := newRepositoryHandler(db, NewMetrics(), 0)
+
+	const n = 7
+	for i := 0; i < n; i++ {
+		if code := getData(handler, context.Background()); code != http.StatusOK {
+			t.Fatalf("request %d: got %d", i, code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/metrics: got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, line := range []string{
+		"repository_requests_total 7",
+		"repository_requests_in_flight 0",
+		// Without artificial latency, every wait falls in the first bucket.
+		`repository_wait_seconds_bucket{le="0.5"} 7`,
+		"repository_wait_seconds_count 7",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("/metrics lacks %q:\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+)
+
+// listenAndServe serves handler on addr over HTTPS when TLS_CERT_FILE and
+// TLS_KEY_FILE name a certificate and its key, and over plain HTTP when
+// neither is set. Setting only one of them is an error rather than a silent
+// downgrade to plain HTTP.
+//
+// The controller has the same function: the two services are separate Go
+// modules built into separate images, and a shared module for a dozen lines
+// would cost more than the copy.
+func listenAndServe(addr string, handler http.Handler) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return serve(ln, handler)
+}
+
+// serve is listenAndServe on an existing listener, which it closes on return.
+func serve(ln net.Listener, handler http.Handler) error {
+	server := &http.Server{Handler: handler}
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if certFile == "" && keyFile == "" {
+		return server.Serve(ln)
+	}
+	if certFile == "" || keyFile == "" {
+		ln.Close()
+		return errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	log.Printf("Serving HTTPS with certificate %s", certFile)
+	return server.ServeTLS(ln, certFile, keyFile)
+}
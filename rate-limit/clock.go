@@ -0,0 +1,32 @@
+package main
+
+import "time"
+
+// Clock is the source of time for the rate limiters. The default realClock
+// uses the time package; tests can inject a fake clock they advance manually.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker is the subset of *time.Ticker the rate limiters use.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// realClock implements Clock with the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+// realTicker adapts *time.Ticker to the Ticker interface.
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// CompositeLimiter layers the two classic algorithms the way some gateways
+// do: a token bucket grants a burst allowance, while a leaky bucket smooths
+// what gets through to its leak rate. A request is admitted only if the token
+// bucket has a token and the leaky bucket has room in its queue.
+type CompositeLimiter struct {
+	tokens *TokenBucket
+	leaky  *LeakyBucket
+	nextID atomic.Int64 // Packet IDs handed to the leaky bucket
+}
+
+// NewCompositeLimiter creates a composite limiter from the token bucket's
+// burst size and rate (tokens per second) and the leaky bucket's queue
+// capacity and leak rate (packets per second).
+func NewCompositeLimiter(burst, tokenRate, queueCapacity, leakRate int) (*CompositeLimiter, error) {
+	if leakRate <= 0 {
+		return nil, fmt.Errorf("invalid leak rate %d: must be positive", leakRate)
+	}
+	return NewCompositeLimiterWithClock(burst, tokenRate, queueCapacity, time.Second/time.Duration(leakRate), realClock{})
+}
+
+// NewCompositeLimiterWithClock creates a composite limiter whose leaky bucket
+// leaks one packet every leakInterval, reading time from clock. The leaky
+// bucket is quiet: logging every request would flood the output under load.
+func NewCompositeLimiterWithClock(burst, tokenRate, queueCapacity int, leakInterval time.Duration, clock Clock) (*CompositeLimiter, error) {
+	tokens, err := newTokenBucket(burst, tokenRate, 0, clock)
+	if err != nil {
+		return nil, err
+	}
+	leaky, err := newLeakyBucket(queueCapacity, leakInterval, clock, true)
+	if err != nil {
+		return nil, err
+	}
+	return &CompositeLimiter{tokens: tokens, leaky: leaky}, nil
+}
+
+// Allow reports whether a request may proceed right now.
+// The token is taken first and handed back if the leaky bucket's queue is
+// full, so requests rejected for pacing do not eat into the burst allowance.
+func (l *CompositeLimiter) Allow() bool {
+	if !l.tokens.Allow() {
+		return false
+	}
+	if !l.leaky.AddPacket(int(l.nextID.Add(1))) {
+		l.tokens.refund()
+		return false
+	}
+	return true
+}
+
+// Stop stops the leaky bucket's leaking process
+func (l *CompositeLimiter) Stop() {
+	l.leaky.Stop()
+}
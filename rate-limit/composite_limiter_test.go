@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// captureStdout returns what f prints to standard output.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	f()
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestAllowDoesNotLogEveryRequest(t *testing.T) {
+	clock := newFakeClock()
+	composite, err := NewCompositeLimiterWithClock(5, 5, 3, time.Second, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer composite.Stop()
+	shared, err := NewRedisLeakyBucketWithClock(NewMemoryLeakyBucketStore(), "api", 3, time.Second, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := captureStdout(t, func() {
+		for i := 0; i < 10; i++ {
+			composite.Allow()
+			shared.Allow()
+		}
+		clock.Advance(time.Second)
+	})
+	if out != "" {
+		t.Errorf("Allow printed %q", out)
+	}
+}
+
+func TestCompositeLimiterAdmitsTheBurstThenPacesAtTheLeakRate(t *testing.T) {
+	const burst = 5
+	clock := newFakeClock()
+	composite, err := NewCompositeLimiterWithClock(burst, 10, burst, time.Second, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer composite.Stop()
+
+	if got := allowN(composite, burst); got != burst {
+		t.Fatalf("admitted %d requests of the initial burst, want %d", got, burst)
+	}
+	if composite.Allow() {
+		t.Fatal("the request after the burst was admitted")
+	}
+
+	// Tokens refill at 10/s, so the one-per-second leak is what paces the traffic.
+	const seconds = 10
+	admitted := 0
+	for i := 0; i < seconds; i++ {
+		clock.Advance(time.Second)
+		eventually(t, func() bool { return len(composite.leaky.queue) == burst-1 }, "no packet leaked after a second")
+		admitted += allowN(composite, 20)
+	}
+	if admitted != seconds {
+		t.Errorf("admitted %d requests in %d seconds at a leak rate of 1/s, want %d", admitted, seconds, seconds)
+	}
+}
+
+func TestCompositeLimiterRejectsInvalidBuckets(t *testing.T) {
+	if _, err := NewCompositeLimiter(5, 0, 3, 1); err == nil {
+		t.Error("NewCompositeLimiter accepted a token rate of 0")
+	}
+	if _, err := NewCompositeLimiter(-1, 1, 3, 1); err == nil {
+		t.Error("NewCompositeLimiter accepted a burst of -1")
+	}
+	if _, err := NewCompositeLimiter(5, 1, 3, 0); err == nil {
+		t.Error("NewCompositeLimiter accepted a leak rate of 0")
+	}
+}
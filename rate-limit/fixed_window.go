@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// FixedWindowCounter represents the fixed window counter structure.
+// Time is split into windows of equal length; each window admits up to
+// limit requests and the counter starts over when the next window begins.
+type FixedWindowCounter struct {
+	limit       int
+	window      time.Duration
+	count       int
+	windowStart time.Time
+	mutex       sync.Mutex
+	clock       Clock
+}
+
+// NewFixedWindowCounter creates and initializes a new fixed window counter
+func NewFixedWindowCounter(limit int, window time.Duration) (*FixedWindowCounter, error) {
+	return NewFixedWindowCounterWithClock(limit, window, realClock{})
+}
+
+// NewFixedWindowCounterWithClock creates a fixed window counter that reads time from clock
+func NewFixedWindowCounterWithClock(limit int, window time.Duration, clock Clock) (*FixedWindowCounter, error) {
+	if window <= 0 {
+		return nil, fmt.Errorf("invalid window %v: must be positive", window)
+	}
+	return &FixedWindowCounter{
+		limit:       limit,
+		window:      window,
+		windowStart: clock.Now().Truncate(window),
+		clock:       clock,
+	}, nil
+}
+
+// Allow counts a request and reports whether it fits in the current window
+func (c *FixedWindowCounter) Allow() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// Start a new window (and a new count) once the boundary has passed
+	if current := c.clock.Now().Truncate(c.window); current.After(c.windowStart) {
+		c.windowStart = current
+		c.count = 0
+	}
+
+	if c.count >= c.limit {
+		return false
+	}
+	c.count++
+	return true
+}
+
+// SimulateFixedWindowCounter simulates the algorithm, including its weak spot:
+// a burst at the end of one window plus a burst at the start of the next lets
+// through twice the limit in a short span.
+func SimulateFixedWindowCounter() {
+	fmt.Println("--- Simulating Fixed Window Counter ---")
+
+	// Limit: 5 requests per 1-second window
+	counter, err := NewFixedWindowCounter(5, time.Second)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// Wait until just before the next window boundary
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(900 * time.Millisecond)))
+
+	admitted := 0
+	for i := 0; i < 20; i++ {
+		if counter.Allow() {
+			admitted++
+			fmt.Printf(" [FixedWindow] Request %d allowed\n", i)
+		} else {
+			fmt.Printf(" [FixedWindow] Request %d rejected. Window limit reached!\n", i)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	fmt.Printf(" [FixedWindow] %d requests allowed in ~200ms across a window boundary (limit 5/s)\n", admitted)
+	fmt.Println("--- Fixed Window Counter simulation finished ---")
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// GCRA implements the Generic Cell Rate Algorithm, used by many API gateways
+// for smooth pacing. Instead of a queue or a token count it keeps a single
+// value, the theoretical arrival time (TAT) of the next request at the
+// configured rate. A request is admitted if it does not arrive earlier than
+// TAT minus the burst tolerance.
+type GCRA struct {
+	emissionInterval time.Duration // Time between requests at the steady rate
+	burstTolerance   time.Duration // How far ahead of schedule a request may arrive
+	tat              time.Time
+	mutex            sync.Mutex
+	clock            Clock
+}
+
+// NewGCRA creates a GCRA limiter admitting one request every emissionInterval
+// on average. burstTolerance allows burstTolerance/emissionInterval extra
+// requests back to back, e.g. 0 for strict pacing.
+func NewGCRA(emissionInterval, burstTolerance time.Duration) *GCRA {
+	return NewGCRAWithClock(emissionInterval, burstTolerance, realClock{})
+}
+
+// NewGCRAWithClock creates a GCRA limiter that reads time from clock
+func NewGCRAWithClock(emissionInterval, burstTolerance time.Duration, clock Clock) *GCRA {
+	return &GCRA{
+		emissionInterval: emissionInterval,
+		burstTolerance:   burstTolerance,
+		clock:            clock,
+	}
+}
+
+// Allow reports whether a request may proceed right now. When it may not,
+// it also returns how long to wait before retrying.
+func (g *GCRA) Allow() (bool, time.Duration) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := g.clock.Now()
+	tat := g.tat
+	if tat.Before(now) {
+		tat = now
+	}
+
+	// The request is too early if it is more than burstTolerance ahead of schedule
+	if ahead := tat.Sub(now); ahead > g.burstTolerance {
+		return false, ahead - g.burstTolerance
+	}
+
+	g.tat = tat.Add(g.emissionInterval)
+	return true, 0
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGCRASteadyStateRate(t *testing.T) {
+	clock := newFakeClock()
+	gcra := NewGCRAWithClock(100*time.Millisecond, 300*time.Millisecond, clock)
+
+	// A burst tolerance of three intervals admits 4 requests back to back.
+	burst := 0
+	for {
+		allowed, retryAfter := gcra.Allow()
+		if !allowed {
+			if retryAfter != 100*time.Millisecond {
+				t.Errorf("after the burst: retry in %v, want 100ms", retryAfter)
+			}
+			break
+		}
+		burst++
+	}
+	if burst != 4 {
+		t.Errorf("admitted a burst of %d, want 4", burst)
+	}
+
+	// Offered every 10ms for 10s, requests are admitted at one per 100ms.
+	admitted := 0
+	for i := 0; i < 1000; i++ {
+		clock.Advance(10 * time.Millisecond)
+		if allowed, _ := gcra.Allow(); allowed {
+			admitted++
+		}
+	}
+	if admitted != 100 {
+		t.Errorf("admitted %d requests in 10s at one per 100ms, want 100", admitted)
+	}
+}
@@ -1,3 +1,3 @@
-module main
+module rate-limit
 
 go 1.24.5
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// KeyedLimiter gives every key (an API key, a client IP...) its own token
+// bucket. Buckets are created on first use, and a background sweep evicts
+// those idle for longer than ttl so memory does not grow without bound.
+type KeyedLimiter struct {
+	capacity  int
+	tokenRate int
+	ttl       time.Duration
+	buckets   map[string]*keyedBucket
+	mutex     sync.Mutex
+	clock     Clock
+	done      chan struct{}
+	stopOnce  sync.Once
+}
+
+// keyedBucket is a key's bucket plus when it was last used.
+type keyedBucket struct {
+	bucket   *TokenBucket
+	lastSeen time.Time
+}
+
+// NewKeyedLimiter creates a limiter where each key gets a bucket with the
+// given capacity and token rate, and starts the idle-bucket sweep, which runs
+// every ttl.
+func NewKeyedLimiter(capacity, tokenRate int, ttl time.Duration) (*KeyedLimiter, error) {
+	return NewKeyedLimiterWithClock(capacity, tokenRate, ttl, realClock{})
+}
+
+// NewKeyedLimiterWithClock creates a keyed limiter that reads time from clock
+func NewKeyedLimiterWithClock(capacity, tokenRate int, ttl time.Duration, clock Clock) (*KeyedLimiter, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("invalid idle TTL %v: must be positive", ttl)
+	}
+	// Buckets are created lazily, so their parameters are checked up front
+	if err := checkTokenBucket(capacity, tokenRate, 0); err != nil {
+		return nil, err
+	}
+	l := &KeyedLimiter{
+		capacity:  capacity,
+		tokenRate: tokenRate,
+		ttl:       ttl,
+		buckets:   make(map[string]*keyedBucket),
+		clock:     clock,
+		done:      make(chan struct{}),
+	}
+
+	go l.sweeper(clock.NewTicker(ttl))
+	return l, nil
+}
+
+// Allow reports whether a request for key may proceed right now
+func (l *KeyedLimiter) Allow(key string) bool {
+	l.mutex.Lock()
+	kb, ok := l.buckets[key]
+	if !ok {
+		// Cannot fail: NewKeyedLimiterWithClock checked the parameters
+		bucket, _ := newTokenBucket(l.capacity, l.tokenRate, 0, l.clock)
+		kb = &keyedBucket{bucket: bucket}
+		l.buckets[key] = kb
+	}
+	kb.lastSeen = l.clock.Now()
+	l.mutex.Unlock()
+
+	return kb.bucket.Allow()
+}
+
+// Len returns how many keys currently have a bucket.
+func (l *KeyedLimiter) Len() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return len(l.buckets)
+}
+
+// sweeper evicts idle buckets on every tick until Stop is called
+func (l *KeyedLimiter) sweeper(ticker Ticker) {
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			l.sweep()
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// sweep removes the buckets not used for longer than ttl
+func (l *KeyedLimiter) sweep() {
+	now := l.clock.Now()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for key, kb := range l.buckets {
+		if now.Sub(kb.lastSeen) > l.ttl {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Stop stops the idle-bucket sweep. It is safe to call more than once.
+func (l *KeyedLimiter) Stop() {
+	l.stopOnce.Do(func() { close(l.done) })
+}
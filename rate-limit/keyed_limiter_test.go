@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyedLimiterKeysHaveIndependentBudgets(t *testing.T) {
+	limiter, err := NewKeyedLimiterWithClock(2, 1, time.Minute, newFakeClock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer limiter.Stop()
+
+	for i := 0; i < 2; i++ {
+		if !limiter.Allow("a") {
+			t.Fatalf("request %d for key a was rejected", i)
+		}
+	}
+	if limiter.Allow("a") {
+		t.Fatal("key a got more than its capacity")
+	}
+	for i := 0; i < 2; i++ {
+		if !limiter.Allow("b") {
+			t.Fatalf("request %d for key b was rejected after key a ran out", i)
+		}
+	}
+}
+
+func TestKeyedLimiterEvictsIdleKeys(t *testing.T) {
+	clock := newFakeClock()
+	limiter, err := NewKeyedLimiterWithClock(2, 1, time.Minute, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer limiter.Stop()
+
+	limiter.Allow("idle")
+	clock.Advance(30 * time.Second)
+	limiter.Allow("active")
+	clock.Advance(31 * time.Second)
+
+	eventually(t, func() bool { return limiter.Len() == 1 }, "the idle key was not evicted")
+	limiter.Allow("active")
+	if limiter.Len() != 1 {
+		t.Errorf("%d keys after the sweep, want only the active one", limiter.Len())
+	}
+}
+
+func TestKeyedLimiterRejectsNonPositiveTTL(t *testing.T) {
+	for _, ttl := range []time.Duration{0, -time.Second} {
+		if _, err := NewKeyedLimiter(1, 1, ttl); err == nil {
+			t.Errorf("NewKeyedLimiter accepted a TTL of %v", ttl)
+		}
+	}
+}
+
+func TestKeyedLimiterRejectsInvalidBuckets(t *testing.T) {
+	// Buckets are only created on first use; the error must come now
+	if _, err := NewKeyedLimiter(1, 0, time.Minute); err == nil {
+		t.Error("NewKeyedLimiter accepted a token rate of 0")
+	}
+	if _, err := NewKeyedLimiter(-1, 1, time.Minute); err == nil {
+		t.Error("NewKeyedLimiter accepted a capacity of -1")
+	}
+}
+
+func TestKeyedLimiterStopTwice(t *testing.T) {
+	limiter, err := NewKeyedLimiter(1, 1, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter.Stop()
+	limiter.Stop()
+}
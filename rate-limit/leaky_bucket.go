@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"sync"
@@ -10,32 +11,69 @@ import (
 // LeakyBucket represents the bucket structure
 type LeakyBucket struct {
 	capacity   int
-	leakRate   int 
+	interval   time.Duration // Time between two leaks
 	queue      chan int
-	leakTicker *time.Ticker
+	leakTicker Ticker
 	mutex      sync.Mutex
+	clock      Clock
+	counters   packetCounters
+	done       chan struct{}
+	stopOnce   sync.Once
+	quiet      bool // No per-packet logging, for buckets admitting requests
 }
 
-// NewLeakyBucket creates and initializes a new leaky bucket
-func NewLeakyBucket(capacity, leakRate int) *LeakyBucket {
+// NewLeakyBucket creates and initializes a new leaky bucket that leaks
+// leakRate packets per second
+func NewLeakyBucket(capacity, leakRate int) (*LeakyBucket, error) {
+	if leakRate <= 0 {
+		return nil, fmt.Errorf("invalid leak rate %d: must be positive", leakRate)
+	}
+	return NewLeakyBucketWithInterval(capacity, time.Second/time.Duration(leakRate))
+}
+
+// NewLeakyBucketWithInterval creates a leaky bucket that leaks one packet
+// every interval, which also allows rates below one packet per second
+func NewLeakyBucketWithInterval(capacity int, interval time.Duration) (*LeakyBucket, error) {
+	return NewLeakyBucketWithClock(capacity, interval, realClock{})
+}
+
+// NewLeakyBucketWithClock creates a leaky bucket whose leak ticker comes from clock
+func NewLeakyBucketWithClock(capacity int, interval time.Duration, clock Clock) (*LeakyBucket, error) {
+	return newLeakyBucket(capacity, interval, clock, false)
+}
+
+// newLeakyBucket creates a leaky bucket, which logs every packet it queues,
+// discards and processes unless quiet is set.
+func newLeakyBucket(capacity int, interval time.Duration, clock Clock, quiet bool) (*LeakyBucket, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid leak interval %v: must be positive", interval)
+	}
 	b := &LeakyBucket{
 		capacity:   capacity,
-		leakRate:   leakRate,
+		interval:   interval,
 		queue:      make(chan int, capacity),
+		clock:      clock,
+		done:       make(chan struct{}),
+		quiet:      quiet,
 	}
 
 	b.startLeaking()
-	return b
+	return b, nil
 }
 
 // startLeaking begins the "leaking" process from the bucket
 func (b *LeakyBucket) startLeaking() {
-	b.leakTicker = time.NewTicker(time.Second / time.Duration(b.leakRate))
+	b.leakTicker = b.clock.NewTicker(b.interval)
 	go func() {
-		for range b.leakTicker.C {
+		for {
+			select {
+			case <-b.leakTicker.C():
+			case <-b.done:
+				return
+			}
 			select {
 			case packetID := <-b.queue:
-				fmt.Printf(" [LeakyBucket] Packet %d processed. Queue size: %d/%d\n", packetID, len(b.queue), b.capacity)
+				b.logf(" [LeakyBucket] Packet %d processed. Queue size: %d/%d\n", packetID, len(b.queue), b.capacity)
 			default:
 				// No packets in the queue, do nothing
 			}
@@ -43,29 +81,70 @@ func (b *LeakyBucket) startLeaking() {
 	}()
 }
 
-// Stop stops the leaking process
+// Stop stops the leaking process and fails the AddPacketWait calls still
+// waiting for room. It is safe to call more than once.
 func (b *LeakyBucket) Stop() {
-	b.leakTicker.Stop()
+	b.stopOnce.Do(func() {
+		b.leakTicker.Stop()
+		close(b.done)
+	})
 }
 
 // AddPacket adds a packet to the bucket's queue
 func (b *LeakyBucket) AddPacket(packetID int) bool {
 	select {
 	case b.queue <- packetID:
-		fmt.Printf(" [LeakyBucket] Packet %d added to queue. Queue size: %d/%d\n", packetID, len(b.queue), b.capacity)
+		b.logf(" [LeakyBucket] Packet %d added to queue. Queue size: %d/%d\n", packetID, len(b.queue), b.capacity)
+		b.counters.admitted.Add(1)
 		return true
 	default:
-		fmt.Printf(" [LeakyBucket] Packet %d discarded. Bucket queue is full!\n", packetID)
+		b.logf(" [LeakyBucket] Packet %d discarded. Bucket queue is full!\n", packetID)
+		b.counters.rejected.Add(1)
 		return false
 	}
 }
 
+// AddPacketWait adds a packet to the bucket's queue, waiting for the leak to
+// make room while the queue is full. Unlike AddPacket it never drops the
+// packet silently: it returns an error if ctx is done or the bucket is
+// stopped first.
+func (b *LeakyBucket) AddPacketWait(ctx context.Context, packetID int) error {
+	select {
+	case b.queue <- packetID:
+		b.logf(" [LeakyBucket] Packet %d added to queue. Queue size: %d/%d\n", packetID, len(b.queue), b.capacity)
+		b.counters.admitted.Add(1)
+		return nil
+	case <-b.done:
+		b.counters.rejected.Add(1)
+		return fmt.Errorf("packet %d not queued: %w", packetID, ErrBucketStopped)
+	case <-ctx.Done():
+		b.counters.rejected.Add(1)
+		return fmt.Errorf("packet %d not queued: %w", packetID, ctx.Err())
+	}
+}
+
+// logf prints a packet event unless the bucket is quiet.
+func (b *LeakyBucket) logf(format string, args ...interface{}) {
+	if !b.quiet {
+		fmt.Printf(format, args...)
+	}
+}
+
+// Metrics returns how many packets were admitted and rejected so far
+func (b *LeakyBucket) Metrics() BucketMetrics {
+	return b.counters.snapshot()
+}
+
 // SimulateLeakyBucket simulates the algorithm
 func SimulateLeakyBucket() {
 	fmt.Println("--- Simulating Leaky Bucket ---")
 
 	// Bucket capacity: 5, leak rate: 2 packets/second
-	bucket := NewLeakyBucket(5, 2)
+	bucket, err := NewLeakyBucket(5, 2)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
 	defer bucket.Stop()
 
 	// Simulate packet arrival in bursts
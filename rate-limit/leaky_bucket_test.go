@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newFullLeakyBucket returns a bucket of capacity 1 leaking every second on
+// clock, with its queue already full.
+func newFullLeakyBucket(t *testing.T, clock Clock) *LeakyBucket {
+	t.Helper()
+	bucket, err := NewLeakyBucketWithClock(1, time.Second, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(bucket.Stop)
+	if !bucket.AddPacket(0) {
+		t.Fatal("the first packet did not fit")
+	}
+	return bucket
+}
+
+func TestLeakyBucketAddPacketWaitBlocksUntilLeak(t *testing.T) {
+	clock := newFakeClock()
+	bucket := newFullLeakyBucket(t, clock)
+
+	result := make(chan error, 1)
+	go func() { result <- bucket.AddPacketWait(context.Background(), 1) }()
+
+	select {
+	case err := <-result:
+		t.Fatalf("AddPacketWait returned %v with the queue full", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("AddPacketWait after the leak: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("AddPacketWait still blocked after the leak made room")
+	}
+	if m := bucket.Metrics(); m.Admitted != 2 || m.Rejected != 0 {
+		t.Errorf("metrics %+v, want 2 admitted and none rejected", m)
+	}
+}
+
+func TestLeakyBucketAddPacketWaitTimesOut(t *testing.T) {
+	bucket := newFullLeakyBucket(t, newFakeClock())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := bucket.AddPacketWait(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+	if m := bucket.Metrics(); m.Rejected != 1 {
+		t.Errorf("%d packets rejected, want 1", m.Rejected)
+	}
+}
+
+func TestLeakyBucketStopReleasesWaiters(t *testing.T) {
+	bucket := newFullLeakyBucket(t, newFakeClock())
+
+	result := make(chan error, 1)
+	go func() { result <- bucket.AddPacketWait(context.Background(), 1) }()
+	time.Sleep(10 * time.Millisecond)
+	bucket.Stop()
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, ErrBucketStopped) {
+			t.Fatalf("got %v, want ErrBucketStopped", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("AddPacketWait still blocked after Stop")
+	}
+}
+
+func TestNewLeakyBucketRejectsNonPositiveRates(t *testing.T) {
+	for _, rate := range []int{0, -1} {
+		if _, err := NewLeakyBucket(3, rate); err == nil {
+			t.Errorf("leak rate %d was accepted", rate)
+		}
+	}
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if _, err := NewLeakyBucketWithInterval(3, interval); err == nil {
+			t.Errorf("leak interval %v was accepted", interval)
+		}
+	}
+}
+
+func TestLeakyBucketLeaksSlowerThanOncePerSecond(t *testing.T) {
+	clock := newFakeClock()
+	bucket, err := newLeakyBucket(1, 2*time.Second, clock, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bucket.Stop()
+	if !bucket.AddPacket(0) {
+		t.Fatal("the first packet did not fit")
+	}
+
+	// No tick is due after one second, so nothing can have leaked.
+	clock.Advance(time.Second)
+	if bucket.AddPacket(1) {
+		t.Fatal("a packet fit one second into a two-second interval")
+	}
+	clock.Advance(time.Second)
+	eventually(t, func() bool { return len(bucket.queue) == 0 }, "no packet leaked after two seconds")
+	if !bucket.AddPacket(2) {
+		t.Error("no room after the leak")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync/atomic"
+)
+
+// BucketMetrics is a snapshot of how many packets a bucket admitted into its
+// queue and how many it rejected because the queue was full (or, with
+// AddPacketWait, because the caller gave up waiting).
+type BucketMetrics struct {
+	Admitted uint64
+	Rejected uint64
+}
+
+// packetCounters counts the packets offered to AddPacket and AddPacketWait.
+// The counters are atomic so reading them never contends with the bucket's
+// own lock.
+type packetCounters struct {
+	admitted atomic.Uint64
+	rejected atomic.Uint64
+}
+
+func (c *packetCounters) snapshot() BucketMetrics {
+	return BucketMetrics{Admitted: c.admitted.Load(), Rejected: c.rejected.Load()}
+}
+
+// MetricsHandler serves the counters of the given buckets, keyed by a name
+// used as the bucket label, in the Prometheus text exposition format.
+func MetricsHandler(buckets map[string]interface{ Metrics() BucketMetrics }) http.Handler {
+	names := make([]string, 0, len(buckets))
+	for name := range buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+		snapshots := make([]BucketMetrics, len(names))
+		for i, name := range names {
+			snapshots[i] = buckets[name].Metrics()
+		}
+
+		fmt.Fprintln(w, "# HELP ratelimit_packets_admitted_total Packets admitted into the bucket's queue.")
+		fmt.Fprintln(w, "# TYPE ratelimit_packets_admitted_total counter")
+		for i, name := range names {
+			fmt.Fprintf(w, "ratelimit_packets_admitted_total{bucket=%q} %d\n", name, snapshots[i].Admitted)
+		}
+
+		fmt.Fprintln(w, "# HELP ratelimit_packets_rejected_total Packets discarded because the bucket's queue was full.")
+		fmt.Fprintln(w, "# TYPE ratelimit_packets_rejected_total counter")
+		for i, name := range names {
+			fmt.Fprintf(w, "ratelimit_packets_rejected_total{bucket=%q} %d\n", name, snapshots[i].Rejected)
+		}
+	})
+}
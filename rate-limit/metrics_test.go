@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// checkBurstMetrics offers a burst of packets to add and checks that every
+// one of them was counted as either admitted or rejected.
+func checkBurstMetrics(t *testing.T, bucket interface{ Metrics() BucketMetrics }, add func(packetID int) bool, capacity int) {
+	t.Helper()
+	const offered = 10
+	for i := 0; i < offered; i++ {
+		add(i)
+	}
+	m := bucket.Metrics()
+	if m.Admitted != uint64(capacity) || m.Rejected != offered-uint64(capacity) {
+		t.Errorf("metrics %+v after a burst of %d into a queue of %d", m, offered, capacity)
+	}
+	if m.Admitted+m.Rejected != offered {
+		t.Errorf("admitted+rejected = %d, want the %d packets offered", m.Admitted+m.Rejected, offered)
+	}
+}
+
+func TestTokenBucketMetricsCountEveryPacketOffered(t *testing.T) {
+	// Without the processor nothing leaves the queue during the burst.
+	bucket, err := newTokenBucket(5, 1, 3, newFakeClock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBurstMetrics(t, bucket, bucket.AddPacket, 3)
+}
+
+func TestLeakyBucketMetricsCountEveryPacketOffered(t *testing.T) {
+	bucket, err := newLeakyBucket(3, time.Second, newFakeClock(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bucket.Stop()
+	checkBurstMetrics(t, bucket, bucket.AddPacket, 3)
+}
+
+func TestMetricsHandlerServesPrometheusText(t *testing.T) {
+	leaky, err := newLeakyBucket(1, time.Second, newFakeClock(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leaky.Stop()
+	leaky.AddPacket(0)
+	leaky.AddPacket(1)
+	token, err := newTokenBucket(1, 1, 2, newFakeClock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	token.AddPacket(0)
+
+	rec := get(MetricsHandler(map[string]interface{ Metrics() BucketMetrics }{"token": token, "leaky": leaky}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain") {
+		t.Errorf("Content-Type = %q, want text/plain", got)
+	}
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE ratelimit_packets_admitted_total counter",
+		"# TYPE ratelimit_packets_rejected_total counter",
+		`ratelimit_packets_admitted_total{bucket="leaky"} 1`,
+		`ratelimit_packets_admitted_total{bucket="token"} 1`,
+		`ratelimit_packets_rejected_total{bucket="leaky"} 1`,
+		`ratelimit_packets_rejected_total{bucket="token"} 0`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("the output lacks %q:\n%s", line, body)
+		}
+	}
+	// Buckets are listed by name, so the output is stable across scrapes.
+	if strings.Index(body, `{bucket="leaky"}`) > strings.Index(body, `{bucket="token"}`) {
+		t.Errorf("buckets are not sorted by name:\n%s", body)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultRetryAfter is the Retry-After hint sent with a 429 response when the
+// limiter cannot tell when it will admit requests again.
+const defaultRetryAfter = time.Second
+
+// limiterState is implemented by limiters that can report their state, like
+// TokenBucket. The middleware then adds the X-RateLimit-* headers.
+type limiterState interface {
+	State() (remaining int, resetAfter time.Duration)
+}
+
+// RateLimitMiddleware wraps an http.Handler so that requests the limiter does
+// not allow are rejected with 429 Too Many Requests and a Retry-After header.
+// Any of the limiters with an Allow method can be used, e.g. a TokenBucket.
+// If the limiter also has a State method, every response carries
+// X-RateLimit-Remaining and X-RateLimit-Reset (in seconds) so clients can back off.
+func RateLimitMiddleware(limiter interface{ Allow() bool }) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			allowed := limiter.Allow()
+
+			retryAfter := defaultRetryAfter
+			if stateful, ok := limiter.(limiterState); ok {
+				remaining, resetAfter := stateful.State()
+				w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+				w.Header().Set("X-RateLimit-Reset", ceilSeconds(resetAfter))
+				retryAfter = max(resetAfter, time.Second)
+			}
+
+			if !allowed {
+				w.Header().Set("Retry-After", ceilSeconds(retryAfter))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ceilSeconds formats d as whole seconds, rounded up as header values require.
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
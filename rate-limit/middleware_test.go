@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// get sends a GET through handler and returns the response.
+func get(handler http.Handler) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	return rec
+}
+
+func TestRateLimitMiddlewareRejectsBeyondTheBurst(t *testing.T) {
+	const burst = 5
+	bucket, err := newTokenBucket(burst, 1, 0, newFakeClock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := 0
+	handler := RateLimitMiddleware(bucket)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+	}))
+
+	for i := 0; i < burst; i++ {
+		if rec := get(handler); rec.Code != http.StatusOK {
+			t.Fatalf("request %d of a burst of %d: got %d, want 200", i+1, burst, rec.Code)
+		}
+	}
+	rec := get(handler)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("request %d: got %d, want 429", burst+1, rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want \"1\"", got)
+	}
+	if served != burst {
+		t.Errorf("the handler served %d requests, want %d", served, burst)
+	}
+}
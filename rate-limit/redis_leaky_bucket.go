@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// leakyBucketStoreTimeout bounds each round trip to the bucket's store.
+const leakyBucketStoreTimeout = time.Second
+
+// LeakyBucketStore holds the state of leaky buckets shared by several
+// limiter instances. Add must be atomic: it leaks the bucket stored under key
+// up to now and then adds one packet unless the bucket is full.
+type LeakyBucketStore interface {
+	Add(ctx context.Context, key string, capacity int, interval time.Duration, now time.Time) (allowed bool, depth int, err error)
+}
+
+// RedisLeakyBucket is a LeakyBucket whose queue depth lives in a
+// LeakyBucketStore, usually Redis, so every replica behind the load balancer
+// draws from the same budget instead of each having its own.
+//
+// It only meters the packets: instead of a ticker draining a local queue,
+// the depth is decreased by the number of intervals elapsed since the last
+// leak every time a packet arrives.
+type RedisLeakyBucket struct {
+	store    LeakyBucketStore
+	key      string
+	capacity int
+	interval time.Duration
+	clock    Clock
+}
+
+// NewRedisLeakyBucket creates a leaky bucket stored under key that holds up to
+// capacity packets and leaks one every interval. Every instance created with
+// the same store and key shares the bucket.
+func NewRedisLeakyBucket(store LeakyBucketStore, key string, capacity int, interval time.Duration) (*RedisLeakyBucket, error) {
+	return NewRedisLeakyBucketWithClock(store, key, capacity, interval, realClock{})
+}
+
+// NewRedisLeakyBucketWithClock creates a shared leaky bucket that reads time from clock
+func NewRedisLeakyBucketWithClock(store LeakyBucketStore, key string, capacity int, interval time.Duration, clock Clock) (*RedisLeakyBucket, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid leak interval %v: must be positive", interval)
+	}
+	return &RedisLeakyBucket{
+		store:    store,
+		key:      key,
+		capacity: capacity,
+		interval: interval,
+		clock:    clock,
+	}, nil
+}
+
+// AddPacket adds a packet to the shared bucket. If the store cannot be
+// reached the packet is discarded, so an outage never lets traffic through
+// unlimited.
+func (b *RedisLeakyBucket) AddPacket(packetID int) bool {
+	allowed, depth, err := b.add()
+	if err != nil {
+		log.Printf(" [RedisLeakyBucket] Packet %d discarded: %v", packetID, err)
+		return false
+	}
+	if !allowed {
+		fmt.Printf(" [RedisLeakyBucket] Packet %d discarded. Bucket queue is full!\n", packetID)
+		return false
+	}
+	fmt.Printf(" [RedisLeakyBucket] Packet %d added to queue. Queue size: %d/%d\n", packetID, depth, b.capacity)
+	return true
+}
+
+// Allow reports whether a request may proceed right now, so the bucket can be
+// used with RateLimitMiddleware. Unlike AddPacket it only logs store errors,
+// not every request.
+func (b *RedisLeakyBucket) Allow() bool {
+	allowed, _, err := b.add()
+	if err != nil {
+		log.Printf(" [RedisLeakyBucket] Request rejected: %v", err)
+		return false
+	}
+	return allowed
+}
+
+// add adds a packet to the shared bucket in the store.
+func (b *RedisLeakyBucket) add() (allowed bool, depth int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), leakyBucketStoreTimeout)
+	defer cancel()
+	return b.store.Add(ctx, b.key, b.capacity, b.interval, b.clock.Now())
+}
+
+// leak drains the packets that leaked between last and now. The returned
+// timestamp only advances by whole intervals, so partial progress towards the
+// next leak is kept; an empty bucket restarts from now.
+func leak(depth int, last, now time.Time, interval time.Duration) (int, time.Time) {
+	if elapsed := now.Sub(last); elapsed >= interval {
+		leaked := int(elapsed / interval)
+		depth = max(depth-leaked, 0)
+		last = last.Add(time.Duration(leaked) * interval)
+	}
+	if depth == 0 {
+		last = now
+	}
+	return depth, last
+}
+
+// RedisEvaler is the part of a Redis client the store needs: running a Lua
+// script atomically. A go-redis client fits it with a small adapter around
+// client.Eval(ctx, script, keys, args...).Result().
+type RedisEvaler interface {
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+}
+
+// leakyBucketScript is leak plus the add, run atomically by Redis on a hash
+// holding the depth and the last leak time (in microseconds). The key expires
+// once the bucket would have fully drained.
+const leakyBucketScript = `
+local capacity = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call('HMGET', KEYS[1], 'depth', 'last_leak')
+local depth = tonumber(state[1]) or 0
+local last = tonumber(state[2]) or now
+
+local elapsed = now - last
+if elapsed >= interval then
+  local leaked = math.floor(elapsed / interval)
+  depth = math.max(depth - leaked, 0)
+  last = last + leaked * interval
+end
+if depth == 0 then
+  last = now
+end
+
+local allowed = 0
+if depth < capacity then
+  depth = depth + 1
+  allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'depth', depth, 'last_leak', last)
+redis.call('PEXPIRE', KEYS[1], math.ceil(capacity * interval / 1000) + 1000)
+return {allowed, depth}
+`
+
+// redisLeakyBucketStore keeps the buckets in Redis.
+type redisLeakyBucketStore struct {
+	client RedisEvaler
+}
+
+// NewRedisLeakyBucketStore returns a store that keeps the buckets in Redis.
+// The time comes from the caller, so the replicas' clocks should be in sync.
+func NewRedisLeakyBucketStore(client RedisEvaler) LeakyBucketStore {
+	return redisLeakyBucketStore{client: client}
+}
+
+func (s redisLeakyBucketStore) Add(ctx context.Context, key string, capacity int, interval time.Duration, now time.Time) (bool, int, error) {
+	result, err := s.client.Eval(ctx, leakyBucketScript, []string{key}, capacity, interval.Microseconds(), now.UnixMicro())
+	if err != nil {
+		return false, 0, fmt.Errorf("running leaky bucket script: %w", err)
+	}
+	values, ok := result.([]interface{})
+	if !ok || len(values) != 2 {
+		return false, 0, fmt.Errorf("unexpected leaky bucket script result %v", result)
+	}
+	allowed, ok1 := values[0].(int64)
+	depth, ok2 := values[1].(int64)
+	if !ok1 || !ok2 {
+		return false, 0, fmt.Errorf("unexpected leaky bucket script result %v", result)
+	}
+	return allowed == 1, int(depth), nil
+}
+
+// memoryLeakyBucketStore keeps the buckets in process memory. It stands in for
+// Redis in tests and single-instance setups; unlike Redis it never expires
+// idle buckets.
+type memoryLeakyBucketStore struct {
+	buckets map[string]memoryLeakyBucket
+	mutex   sync.Mutex
+}
+
+// memoryLeakyBucket is the state the Redis hash holds.
+type memoryLeakyBucket struct {
+	depth    int
+	lastLeak time.Time
+}
+
+// NewMemoryLeakyBucketStore returns a store that keeps the buckets in memory.
+func NewMemoryLeakyBucketStore() LeakyBucketStore {
+	return &memoryLeakyBucketStore{buckets: make(map[string]memoryLeakyBucket)}
+}
+
+func (s *memoryLeakyBucketStore) Add(ctx context.Context, key string, capacity int, interval time.Duration, now time.Time) (bool, int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b.lastLeak = now
+	}
+	b.depth, b.lastLeak = leak(b.depth, b.lastLeak, now, interval)
+
+	allowed := b.depth < capacity
+	if allowed {
+		b.depth++
+	}
+	s.buckets[key] = b
+	return allowed, b.depth, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRedisLeakyBucketInstancesShareOneBudget(t *testing.T) {
+	const capacity = 3
+	clock := newFakeClock()
+	store := NewMemoryLeakyBucketStore()
+	replicas := make([]*RedisLeakyBucket, 2)
+	for i := range replicas {
+		bucket, err := NewRedisLeakyBucketWithClock(store, "api", capacity, time.Second, clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		replicas[i] = bucket
+	}
+
+	admitted := 0
+	for i := 0; i < 10; i++ {
+		if replicas[i%2].Allow() {
+			admitted++
+		}
+	}
+	if admitted != capacity {
+		t.Fatalf("two replicas admitted %d requests together, want the shared capacity of %d", admitted, capacity)
+	}
+
+	clock.Advance(time.Second)
+	if !replicas[0].Allow() {
+		t.Fatal("no room after one leak interval")
+	}
+	if replicas[1].Allow() {
+		t.Error("one leak interval made room for more than one request")
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import "time"
+
+// TieredLimiter enforces a per-key cap and a global cap together, e.g. 100
+// req/s per API key but no more than 10k req/s overall. A request is admitted
+// only if both its key's bucket and the shared global bucket allow it.
+type TieredLimiter struct {
+	global *TokenBucket
+	keys   *KeyedLimiter
+}
+
+// NewTieredLimiter creates a tiered limiter from the global bucket's capacity
+// and rate and the per-key buckets' capacity and rate. Idle per-key buckets
+// are evicted after ttl.
+func NewTieredLimiter(globalCapacity, globalRate, keyCapacity, keyRate int, ttl time.Duration) (*TieredLimiter, error) {
+	return NewTieredLimiterWithClock(globalCapacity, globalRate, keyCapacity, keyRate, ttl, realClock{})
+}
+
+// NewTieredLimiterWithClock creates a tiered limiter that reads time from clock
+func NewTieredLimiterWithClock(globalCapacity, globalRate, keyCapacity, keyRate int, ttl time.Duration, clock Clock) (*TieredLimiter, error) {
+	global, err := newTokenBucket(globalCapacity, globalRate, 0, clock)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := NewKeyedLimiterWithClock(keyCapacity, keyRate, ttl, clock)
+	if err != nil {
+		return nil, err
+	}
+	return &TieredLimiter{global: global, keys: keys}, nil
+}
+
+// Allow reports whether a request for key may proceed right now.
+// The global token is taken first and handed back if the key is over its own
+// cap, so a noisy key that keeps getting rejected does not drain the global
+// bucket for everybody else.
+func (l *TieredLimiter) Allow(key string) bool {
+	if !l.global.Allow() {
+		return false
+	}
+	if !l.keys.Allow(key) {
+		l.global.refund()
+		return false
+	}
+	return true
+}
+
+// Stop stops the idle-bucket sweep of the per-key buckets
+func (l *TieredLimiter) Stop() {
+	l.keys.Stop()
+}
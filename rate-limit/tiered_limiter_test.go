@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestTieredLimiter returns a tiered limiter on a fake clock, so no token
+// is refilled during the test.
+func newTestTieredLimiter(t *testing.T, globalCapacity, keyCapacity int) *TieredLimiter {
+	t.Helper()
+	limiter, err := NewTieredLimiterWithClock(globalCapacity, 1, keyCapacity, 1, time.Minute, newFakeClock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(limiter.Stop)
+	return limiter
+}
+
+// allowKeyN sends n requests for key through limiter and returns how many were admitted.
+func allowKeyN(limiter *TieredLimiter, key string, n int) int {
+	admitted := 0
+	for i := 0; i < n; i++ {
+		if limiter.Allow(key) {
+			admitted++
+		}
+	}
+	return admitted
+}
+
+func TestTieredLimiterNoisyKeyLeavesOthersTheirShare(t *testing.T) {
+	limiter := newTestTieredLimiter(t, 5, 2)
+
+	if got := allowKeyN(limiter, "noisy", 20); got != 2 {
+		t.Fatalf("the noisy key got %d requests through, want its per-key cap of 2", got)
+	}
+	if got := allowKeyN(limiter, "a", 5); got != 2 {
+		t.Fatalf("key a got %d requests through after the noisy key, want its per-key cap of 2", got)
+	}
+	// Only one global token is left, although key b is under its own cap.
+	if got := allowKeyN(limiter, "b", 5); got != 1 {
+		t.Errorf("key b got %d requests through, want the 1 left under the global cap", got)
+	}
+}
+
+func TestTieredLimiterPerKeyRejectionRefundsTheGlobalToken(t *testing.T) {
+	const globalCapacity = 3
+	limiter := newTestTieredLimiter(t, globalCapacity, 1)
+
+	if got := allowKeyN(limiter, "noisy", 100); got != 1 {
+		t.Fatalf("the noisy key got %d requests through, want 1", got)
+	}
+	for _, key := range []string{"a", "b"} {
+		if !limiter.Allow(key) {
+			t.Fatalf("key %s was rejected: the noisy key's rejections used up the global budget", key)
+		}
+	}
+	if limiter.Allow("c") {
+		t.Errorf("more than the global capacity of %d requests got through", globalCapacity)
+	}
+}
+
+func TestTieredLimiterRejectsInvalidBuckets(t *testing.T) {
+	if _, err := NewTieredLimiter(10, 0, 1, 1, time.Minute); err == nil {
+		t.Error("NewTieredLimiter accepted a global rate of 0")
+	}
+	if _, err := NewTieredLimiter(10, 1, 1, 0, time.Minute); err == nil {
+		t.Error("NewTieredLimiter accepted a per-key rate of 0")
+	}
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"sync"
 	"time"
 )
 
+// ErrBucketStopped is returned by AddPacketWait when the bucket is stopped
+// while the packet waits for room in the queue.
+var ErrBucketStopped = errors.New("bucket stopped")
+
 // TokenBucket represents the token bucket structure
 type TokenBucket struct {
 	capacity      int
@@ -15,78 +21,227 @@ type TokenBucket struct {
 	lastRefill    time.Time
 	mutex         sync.Mutex
 	packetQueue   chan int
+	clock         Clock
+	done          chan struct{}
+	stopOnce      sync.Once
+	running       sync.WaitGroup
+	counters      packetCounters
 }
 
 // NewTokenBucket creates and initializes a new token bucket
-func NewTokenBucket(capacity, tokenRate, queueCapacity int) *TokenBucket {
-	tb := &TokenBucket{
+func NewTokenBucket(capacity, tokenRate, queueCapacity int) (*TokenBucket, error) {
+	return NewTokenBucketWithClock(capacity, tokenRate, queueCapacity, realClock{})
+}
+
+// NewTokenBucketWithClock creates a token bucket that reads time from clock
+func NewTokenBucketWithClock(capacity, tokenRate, queueCapacity int, clock Clock) (*TokenBucket, error) {
+	tb, err := newTokenBucket(capacity, tokenRate, queueCapacity, clock)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start a worker to process packets when tokens are available
+	tb.running.Add(1)
+	go tb.processor()
+	return tb, nil
+}
+
+// newTokenBucket creates a token bucket without the packet processor, for
+// callers that only use Allow.
+func newTokenBucket(capacity, tokenRate, queueCapacity int, clock Clock) (*TokenBucket, error) {
+	if err := checkTokenBucket(capacity, tokenRate, queueCapacity); err != nil {
+		return nil, err
+	}
+	return &TokenBucket{
 		capacity:   capacity,
 		tokens:     capacity, // Start with a full bucket
 		tokenRate:  tokenRate,
-		lastRefill: time.Now(),
+		lastRefill: clock.Now(),
 		packetQueue: make(chan int, queueCapacity),
+		clock:      clock,
+		done:       make(chan struct{}),
+	}, nil
+}
+
+// checkTokenBucket validates the parameters of a token bucket. The rate is
+// capped at one token per nanosecond, the shortest interval between tokens.
+func checkTokenBucket(capacity, tokenRate, queueCapacity int) error {
+	if tokenRate <= 0 || tokenRate > int(time.Second) {
+		return fmt.Errorf("invalid token rate %d: must be between 1 and %d per second", tokenRate, int(time.Second))
 	}
-	
-	// Start a worker to process packets when tokens are available
-	go tb.processor()
-	return tb
+	if capacity < 0 {
+		return fmt.Errorf("invalid capacity %d: must not be negative", capacity)
+	}
+	if queueCapacity < 0 {
+		return fmt.Errorf("invalid queue capacity %d: must not be negative", queueCapacity)
+	}
+	return nil
 }
 
-// refill adds tokens to the bucket based on time
+// refill adds tokens to the bucket based on time.
+// lastRefill only advances by the time the added whole tokens took, so the
+// fraction of a token earned since then carries over to the next call instead
+// of being discarded, and the long-run rate matches tokenRate exactly.
 func (b *TokenBucket) refill() {
-	now := time.Now()
+	now := b.clock.Now()
+	if b.tokens >= b.capacity {
+		// A full bucket earns nothing: the next token is due one interval
+		// after it stops being full.
+		b.lastRefill = now
+		return
+	}
+
 	// Calculate how many tokens should have been added since the last refill
 	elapsed := now.Sub(b.lastRefill)
 	tokensToAdd := int(elapsed.Seconds() * float64(b.tokenRate))
+	if tokensToAdd <= 0 {
+		return
+	}
 
-	if tokensToAdd > 0 {
-		b.tokens = min(b.tokens + tokensToAdd, b.capacity)
+	if b.tokens+tokensToAdd > b.capacity {
+		// Tokens beyond capacity are lost, and so is the fraction earned
+		// since. Landing exactly on capacity keeps it, as a token is usually
+		// taken right away.
+		b.tokens = b.capacity
 		b.lastRefill = now
+		return
+	}
+	b.tokens += tokensToAdd
+	b.lastRefill = b.lastRefill.Add(time.Duration(int64(tokensToAdd) * int64(time.Second) / int64(b.tokenRate)))
+}
+
+// Allow reports whether a request may proceed right now, consuming one token
+// if so. It never blocks: with no tokens left it returns false immediately.
+func (b *TokenBucket) Allow() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill()
+	if b.tokens == 0 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// refund gives back a token taken by Allow for a request that was rejected
+// further on, e.g. by another tier of a TieredLimiter.
+func (b *TokenBucket) refund() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.tokens < b.capacity {
+		b.tokens++
 	}
 }
 
+// State returns the tokens currently available and how long until at least
+// one token is, which is zero when the bucket is not empty.
+func (b *TokenBucket) State() (remaining int, resetAfter time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill()
+	if b.tokens > 0 {
+		return b.tokens, 0
+	}
+	nextToken := b.lastRefill.Add(time.Second / time.Duration(b.tokenRate))
+	return 0, max(nextToken.Sub(b.clock.Now()), 0)
+}
+
 // processor handles taking packets from the queue and tokens from the bucket
 func (b *TokenBucket) processor() {
-	ticker := time.NewTicker(time.Second / time.Duration(b.tokenRate))
+	defer b.running.Done()
+	ticker := b.clock.NewTicker(time.Second / time.Duration(b.tokenRate))
 	defer ticker.Stop()
-	
-	for range ticker.C {
+
+	for {
+		select {
+		case <-ticker.C():
+		case <-b.done:
+			b.drain()
+			return
+		}
+
+		// Only the processor reads the queue, so a packet is there if len > 0
+		if len(b.packetQueue) == 0 || !b.Allow() {
+			continue
+		}
+		packetID := <-b.packetQueue
+
 		b.mutex.Lock()
-		b.refill()
+		fmt.Printf(" [TokenBucket] Packet %d sent! Tokens remaining: %d/%d\n", packetID, b.tokens, b.capacity)
 		b.mutex.Unlock()
+	}
+}
 
+// drain discards the packets still queued when the bucket is stopped
+func (b *TokenBucket) drain() {
+	for {
 		select {
 		case packetID := <-b.packetQueue:
-			b.mutex.Lock()
-			if b.tokens > 0 {
-				b.tokens--
-				fmt.Printf(" [TokenBucket] Packet %d sent! Tokens remaining: %d/%d\n", packetID, b.tokens, b.capacity)
-			}
-			b.mutex.Unlock()
+			fmt.Printf(" [TokenBucket] Packet %d dropped. Bucket stopped!\n", packetID)
 		default:
-			// No packets in the queue, do nothing
+			return
 		}
 	}
 }
 
+// Stop stops the packet processor, dropping any packets still queued, and
+// waits for it to exit. It is safe to call more than once.
+func (b *TokenBucket) Stop() {
+	b.stopOnce.Do(func() { close(b.done) })
+	b.running.Wait()
+}
+
 // AddPacket adds a packet to the token bucket's queue
 func (b *TokenBucket) AddPacket(packetID int) bool {
 	select {
 	case b.packetQueue <- packetID:
 		fmt.Printf(" [TokenBucket] Packet %d added to queue. Queue size: %d/%d\n", packetID, len(b.packetQueue), cap(b.packetQueue))
+		b.counters.admitted.Add(1)
 		return true
 	default:
 		fmt.Printf(" [TokenBucket] Packet %d discarded. Queue is full!\n", packetID)
+		b.counters.rejected.Add(1)
 		return false
 	}
 }
 
+// AddPacketWait adds a packet to the token bucket's queue, waiting for room
+// while the queue is full. Unlike AddPacket it never drops the packet
+// silently: it returns an error if ctx is done or the bucket is stopped first.
+func (b *TokenBucket) AddPacketWait(ctx context.Context, packetID int) error {
+	select {
+	case b.packetQueue <- packetID:
+		fmt.Printf(" [TokenBucket] Packet %d added to queue. Queue size: %d/%d\n", packetID, len(b.packetQueue), cap(b.packetQueue))
+		b.counters.admitted.Add(1)
+		return nil
+	case <-b.done:
+		b.counters.rejected.Add(1)
+		return fmt.Errorf("packet %d not queued: %w", packetID, ErrBucketStopped)
+	case <-ctx.Done():
+		b.counters.rejected.Add(1)
+		return fmt.Errorf("packet %d not queued: %w", packetID, ctx.Err())
+	}
+}
+
+// Metrics returns how many packets were admitted and rejected so far
+func (b *TokenBucket) Metrics() BucketMetrics {
+	return b.counters.snapshot()
+}
+
 // SimulateTokenBucket simulates the algorithm
 func SimulateTokenBucket() {
 	fmt.Println("--- Simulating Token Bucket ---")
 
 	// Bucket capacity: 5 tokens, token rate: 2/second, queue capacity: 10
-	bucket := NewTokenBucket(5, 2, 10)
+	bucket, err := NewTokenBucket(5, 2, 10)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer bucket.Stop()
 
 	// Simulate packet arrival
 	for i := 0; i < 20; i++ {
@@ -111,4 +266,6 @@ func main() {
 	SimulateLeakyBucket()
 	fmt.Println()
 	SimulateTokenBucket()
+	fmt.Println()
+	SimulateFixedWindowCounter()
 }
\ No newline at end of file
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// newEmptyTokenBucket returns a bucket on clock, without the packet
+// processor, with every token already taken.
+func newEmptyTokenBucket(t *testing.T, capacity, tokenRate int, clock Clock) *TokenBucket {
+	t.Helper()
+	bucket, err := newTokenBucket(capacity, tokenRate, 0, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < capacity; i++ {
+		if !bucket.Allow() {
+			t.Fatalf("a full bucket of %d refused request %d", capacity, i)
+		}
+	}
+	if bucket.Allow() {
+		t.Fatal("an empty bucket allowed a request")
+	}
+	return bucket
+}
+
+func TestTokenBucketAllowRefillsAtTokenRate(t *testing.T) {
+	clock := newFakeClock()
+	start := clock.Now()
+	bucket := newEmptyTokenBucket(t, 5, 2, clock)
+
+	for _, step := range []struct {
+		advance time.Duration
+		allowed bool
+	}{
+		{499 * time.Millisecond, false},
+		{time.Millisecond, true}, // 500ms: one token
+		{0, false},
+		// The 300ms beyond a token carry over, so 200ms more complete the next.
+		{800 * time.Millisecond, true},
+		{0, false},
+		{200 * time.Millisecond, true},
+		{0, false},
+	} {
+		clock.Advance(step.advance)
+		if got := bucket.Allow(); got != step.allowed {
+			t.Fatalf("at %v: Allow() = %v, want %v", clock.Now().Sub(start), got, step.allowed)
+		}
+	}
+}
+
+// drainCount takes tokens from bucket until it refuses and returns how many it took.
+func drainCount(bucket *TokenBucket) int {
+	n := 0
+	for bucket.Allow() {
+		n++
+	}
+	return n
+}
+
+func TestTokenBucketRefillIsCappedAtCapacity(t *testing.T) {
+	clock := newFakeClock()
+	bucket := newEmptyTokenBucket(t, 10, 3, clock)
+
+	clock.Advance(time.Second)
+	if got := drainCount(bucket); got != 3 {
+		t.Errorf("one second at 3 tokens/s refilled %d tokens, want 3", got)
+	}
+	clock.Advance(10 * time.Second)
+	if got := drainCount(bucket); got != 10 {
+		t.Errorf("ten seconds at 3 tokens/s refilled %d tokens, want the capacity of 10", got)
+	}
+}
+
+func TestTokenBucketStateReportsTheNextToken(t *testing.T) {
+	clock := newFakeClock()
+	bucket := newEmptyTokenBucket(t, 3, 4, clock)
+
+	if remaining, resetAfter := bucket.State(); remaining != 0 || resetAfter != 250*time.Millisecond {
+		t.Errorf("empty bucket at 4 tokens/s: State() = %d, %v, want 0, 250ms", remaining, resetAfter)
+	}
+	clock.Advance(100 * time.Millisecond)
+	if remaining, resetAfter := bucket.State(); remaining != 0 || resetAfter != 150*time.Millisecond {
+		t.Errorf("100ms later: State() = %d, %v, want 0, 150ms", remaining, resetAfter)
+	}
+	clock.Advance(150 * time.Millisecond)
+	if remaining, resetAfter := bucket.State(); remaining != 1 || resetAfter != 0 {
+		t.Errorf("once a token is due: State() = %d, %v, want 1, 0", remaining, resetAfter)
+	}
+}
+
+func TestTokenBucketStopEndsTheProcessor(t *testing.T) {
+	before := runtime.NumGoroutine()
+	bucket, err := NewTokenBucketWithClock(1, 1, 1, newFakeClock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := runtime.NumGoroutine(); n != before+1 {
+		t.Fatalf("%d goroutines with a bucket running, want %d", n, before+1)
+	}
+
+	bucket.Stop()
+	eventually(t, func() bool { return runtime.NumGoroutine() == before }, "the processor is still running after Stop")
+	bucket.Stop() // A second Stop must not block or panic
+}
+
+func TestTokenBucketKeepsTheRateWithSmallSteps(t *testing.T) {
+	// At 7 tokens/s a token takes 142.857ms, so no 1ms step ever lands on a
+	// token boundary; dropping the fractions would lose tokens.
+	const tokenRate = 7
+	clock := newFakeClock()
+	bucket := newEmptyTokenBucket(t, 1, tokenRate, clock)
+
+	admitted := 0
+	for i := 0; i < 60_000; i++ {
+		clock.Advance(time.Millisecond)
+		if bucket.Allow() {
+			admitted++
+		}
+	}
+	if admitted != tokenRate*60 {
+		t.Errorf("admitted %d requests in a minute of 1ms steps, want %d", admitted, tokenRate*60)
+	}
+}
+
+func TestNewTokenBucketRejectsInvalidParameters(t *testing.T) {
+	for _, tc := range []struct{ capacity, tokenRate, queueCapacity int }{
+		{5, 0, 0},
+		{5, -1, 0},
+		{5, int(time.Second) + 1, 0}, // The interval between tokens would round to 0
+		{-1, 1, 0},
+		{5, 1, -1},
+	} {
+		if _, err := NewTokenBucket(tc.capacity, tc.tokenRate, tc.queueCapacity); err == nil {
+			t.Errorf("capacity %d, token rate %d, queue capacity %d were accepted", tc.capacity, tc.tokenRate, tc.queueCapacity)
+		}
+	}
+	bucket, err := newTokenBucket(0, int(time.Second), 0, newFakeClock())
+	if err != nil {
+		t.Errorf("a bucket of 0 tokens at one per nanosecond was rejected: %v", err)
+	} else if _, reset := bucket.State(); reset <= 0 {
+		t.Errorf("at one token per nanosecond the next token is due in %v", reset)
+	}
+}